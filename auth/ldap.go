@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/go-ldap/ldap/v3"
+	"net/url"
+	"time"
+)
+
+// LDAP登录的账号来源（sp_manager.mg_source）
+const LdapSource = "ldap"
+
+/* LDAP认证：先用查询账号（BindDN）按用户名查找用户的DN，再用用户的DN和密码绑定，绑定成功即认证通过 */
+type LdapProvider struct {
+	// 服务器地址，例如ldap://127.0.0.1:389、ldaps://ldap.example.com:636
+	URL string
+	// 使用ldap://连接时是否通过StartTLS加密
+	StartTLS bool
+	// 为空时按服务器地址校验证书
+	TLSConfig *tls.Config
+	// 查询用户使用的账号，为空时匿名查询
+	BindDN       string
+	BindPassword string
+	// 查找用户的位置和过滤条件，过滤条件中的%s替换为转义后的用户名，例如(uid=%s)
+	BaseDN     string
+	UserFilter string
+	// 用户名、邮箱、手机号、用户组对应的属性
+	UserNameAttr string
+	EmailAttr    string
+	MobileAttr   string
+	GroupAttr    string
+	Timeout      time.Duration
+}
+
+// 根据配置文件中Ldap开头的配置项创建LDAP认证
+func LdapProviderFromConfig() *LdapProvider {
+	return &LdapProvider{
+		URL:          beego.AppConfig.String("LdapURL"),
+		StartTLS:     beego.AppConfig.DefaultBool("LdapStartTLS", false),
+		BindDN:       beego.AppConfig.String("LdapBindDN"),
+		BindPassword: beego.AppConfig.String("LdapBindPassword"),
+		BaseDN:       beego.AppConfig.String("LdapBaseDN"),
+		UserFilter:   beego.AppConfig.DefaultString("LdapUserFilter", "(uid=%s)"),
+		UserNameAttr: beego.AppConfig.DefaultString("LdapUserNameAttr", "uid"),
+		EmailAttr:    beego.AppConfig.DefaultString("LdapEmailAttr", "mail"),
+		MobileAttr:   beego.AppConfig.DefaultString("LdapMobileAttr", "mobile"),
+		GroupAttr:    beego.AppConfig.DefaultString("LdapGroupAttr", "memberOf"),
+		Timeout:      time.Second * time.Duration(beego.AppConfig.DefaultInt("LdapTimeout", 5)),
+	}
+}
+
+func (this *LdapProvider) Name() string {
+	return LdapSource
+}
+
+func (this *LdapProvider) Authenticate(userName, password string) (*Identity, error) {
+	// 密码为空时很多LDAP服务器会当作匿名绑定并返回成功，必须拒绝
+	if userName == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	tlsConfig := this.TLSConfig
+	if tlsConfig == nil {
+		u, err := url.Parse(this.URL)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	conn, err := ldap.DialURL(this.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if this.Timeout > 0 {
+		conn.SetTimeout(this.Timeout)
+	}
+	if this.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if this.BindDN != "" {
+		err = conn.Bind(this.BindDN, this.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP查询账号绑定失败：%v", err)
+		}
+	}
+
+	// 按用户名查找用户，必须正好找到一个
+	attrs := []string{this.UserNameAttr, this.EmailAttr, this.MobileAttr, this.GroupAttr}
+	request := ldap.NewSearchRequest(this.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(this.UserFilter, ldap.EscapeFilter(userName)), attrs, nil)
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	// 使用用户自己的DN和密码绑定
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Provider: LdapSource, Subject: entry.DN, UserName: entry.GetAttributeValue(this.UserNameAttr),
+		Email: entry.GetAttributeValue(this.EmailAttr), Mobile: entry.GetAttributeValue(this.MobileAttr),
+		Groups: entry.GetAttributeValues(this.GroupAttr)}
+	if identity.UserName == "" {
+		identity.UserName = userName
+	}
+	return identity, nil
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"fmt"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// 测试用的LDAP服务器，只实现LDAP认证用到的简单绑定、查找和解绑
+type fakeLdapUser struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+type fakeLdapServer struct {
+	listener net.Listener
+	users    []fakeLdapUser
+	// 查询账号
+	bindDN       string
+	bindPassword string
+}
+
+const (
+	ldapBindRequest      = 0
+	ldapBindResponse     = 1
+	ldapUnbindRequest    = 2
+	ldapSearchRequest    = 3
+	ldapSearchResEntry   = 4
+	ldapSearchResDone    = 5
+	ldapResultSuccess    = 0
+	ldapResultInvalidCrd = 49
+)
+
+func startFakeLdap(t *testing.T, users []fakeLdapUser) *fakeLdapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeLdapServer{listener: listener, users: users,
+		bindDN: "cn=reader,dc=example,dc=com", bindPassword: "reader-secret"}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (this *fakeLdapServer) url() string {
+	return "ldap://" + this.listener.Addr().String()
+}
+
+func (this *fakeLdapServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageId := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldapBindRequest:
+			name := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := int64(ldapResultInvalidCrd)
+			if this.checkBind(name, password) {
+				code = ldapResultSuccess
+			}
+			conn.Write(ldapResponse(messageId, ldapBindResponse, code).Bytes())
+		case ldapSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			for _, user := range this.users {
+				if this.matches(user, filter) {
+					conn.Write(ldapEntry(messageId, user).Bytes())
+				}
+			}
+			conn.Write(ldapResponse(messageId, ldapSearchResDone, ldapResultSuccess).Bytes())
+		case ldapUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (this *fakeLdapServer) checkBind(name, password string) bool {
+	if password == "" {
+		return false
+	}
+	if name == this.bindDN {
+		return password == this.bindPassword
+	}
+	for _, user := range this.users {
+		if user.dn == name && user.password == password {
+			return true
+		}
+	}
+	return false
+}
+
+// 只支持(uid=xxx)形式的过滤条件，用于确认用户名经过了转义
+func (this *fakeLdapServer) matches(user fakeLdapUser, filter string) bool {
+	for _, uid := range user.attrs["uid"] {
+		if filter == fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)) {
+			return true
+		}
+	}
+	return false
+}
+
+func ldapMessage(messageId int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageId, "MessageID"))
+	return packet
+}
+
+func ldapResponse(messageId int64, tag ber.Tag, code int64) *ber.Packet {
+	packet := ldapMessage(messageId)
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	packet.AppendChild(response)
+	return packet
+}
+
+func ldapEntry(messageId int64, user fakeLdapUser) *ber.Packet {
+	packet := ldapMessage(messageId)
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapSearchResEntry, nil, "Search Result Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, user.dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range user.attrs {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "val"))
+		}
+		attribute.AppendChild(vals)
+		attributes.AppendChild(attribute)
+	}
+	entry.AppendChild(attributes)
+	packet.AppendChild(entry)
+	return packet
+}
+
+func newTestLdapProvider(server *fakeLdapServer) *LdapProvider {
+	return &LdapProvider{URL: server.url(), BindDN: server.bindDN, BindPassword: server.bindPassword,
+		BaseDN: "ou=people,dc=example,dc=com", UserFilter: "(uid=%s)", UserNameAttr: "uid", EmailAttr: "mail",
+		MobileAttr: "mobile", GroupAttr: "memberOf"}
+}
+
+var testLdapUsers = []fakeLdapUser{
+	{dn: "uid=alice,ou=people,dc=example,dc=com", password: "alice-pwd", attrs: map[string][]string{
+		"uid":      {"alice"},
+		"mail":     {"alice@example.com"},
+		"mobile":   {"13800000000"},
+		"memberOf": {"cn=jd-goods,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+	}},
+	{dn: "uid=bob,ou=people,dc=example,dc=com", password: "bob-pwd", attrs: map[string][]string{
+		"uid": {"bob"},
+	}},
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	server := startFakeLdap(t, testLdapUsers)
+	provider := newTestLdapProvider(server)
+
+	identity, err := provider.Authenticate("alice", "alice-pwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Identity{Provider: LdapSource, Subject: "uid=alice,ou=people,dc=example,dc=com", UserName: "alice",
+		Email: "alice@example.com", Mobile: "13800000000",
+		Groups: []string{"cn=jd-goods,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"}}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("identity = %+v, want %+v", identity, want)
+	}
+}
+
+func TestLdapAuthenticateRejected(t *testing.T) {
+	server := startFakeLdap(t, testLdapUsers)
+	provider := newTestLdapProvider(server)
+
+	cases := []struct {
+		name     string
+		userName string
+		password string
+	}{
+		{"密码错误", "alice", "wrong"},
+		{"用户不存在", "carol", "alice-pwd"},
+		{"密码为空", "alice", ""},
+		{"用户名中的通配符", "*", "alice-pwd"},
+		{"用其他用户的密码", "bob", "alice-pwd"},
+	}
+	for _, c := range cases {
+		_, err := provider.Authenticate(c.userName, c.password)
+		if err != ErrInvalidCredentials {
+			t.Errorf("%s：err = %v, want ErrInvalidCredentials", c.name, err)
+		}
+	}
+}
+
+func TestLdapServiceBindFailure(t *testing.T) {
+	server := startFakeLdap(t, testLdapUsers)
+	provider := newTestLdapProvider(server)
+	provider.BindPassword = "wrong"
+
+	// 查询账号配置错误是服务端的问题，不能当作用户密码错误
+	_, err := provider.Authenticate("alice", "alice-pwd")
+	if err == nil || err == ErrInvalidCredentials {
+		t.Errorf("err = %v, want a bind error", err)
+	}
+}
+
+func TestLdapUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	provider := &LdapProvider{URL: "ldap://" + addr, UserFilter: "(uid=%s)"}
+	_, err = provider.Authenticate("alice", "alice-pwd")
+	if err == nil || err == ErrInvalidCredentials {
+		t.Errorf("err = %v, want a connection error", err)
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	mapping := ParseGroupRoles([]string{
+		"CN=jd-goods,OU=groups,DC=example,DC=com=31",
+		" jd-admins = 30",
+		"jd-admins=32",
+		"broken",
+		"bad=abc",
+	})
+	want := map[string][]int{
+		"cn=jd-goods,ou=groups,dc=example,dc=com": {31},
+		"jd-admins": {30, 32},
+	}
+	if !reflect.DeepEqual(mapping, want) {
+		t.Errorf("mapping = %v, want %v", mapping, want)
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"JDStore/models"
+	"JDStore/utils"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"strconv"
+)
+
+// 用户不存在时用于比较的密码hash，使其与密码错误时的响应时间一致
+var dummyPwdHash = utils.HashAndSalt("JDStore-dummy-password")
+
+/* 本地密码认证，使用sp_manager中保存的密码hash */
+type LocalProvider struct{}
+
+func (this *LocalProvider) Name() string {
+	return models.SourceLocal
+}
+
+/* 用户不存在、密码错误、服务账号以及外部来源的账号都返回ErrInvalidCredentials，
+*  并且同样进行一次密码比较，避免通过提示或响应时间猜测用户名 */
+func (this *LocalProvider) Authenticate(userName, password string) (*Identity, error) {
+	o := orm.NewOrm()
+	manager := models.SpManager{MgName: userName}
+	err := o.Read(&manager, "MgName")
+	// 服务账号只能使用API Key访问接口，外部账号只能通过身份提供方登录，已删除的管理员不能登录，都按用户不存在处理
+	if err == nil && (manager.MgType == models.ManagerService || !manager.IsLocal() || manager.IsDeleted()) {
+		err = orm.ErrNoRows
+	}
+	if err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		manager.MgPwd = dummyPwdHash
+	}
+	/*将请求参数中的密码和数据库中加密后的密码进行比较，这里用到的是封装好的函数。
+	* 关于如何使用hash对密码进行加密、解密的详情参见：https://www.kancloud.cn/golang_programe/golang/1144844*/
+	result := utils.ComparePasswords(manager.MgPwd, []byte(password))
+	if err != nil || !result {
+		return nil, ErrInvalidCredentials
+	}
+	/*密码hash的计算强度低于当前配置时（例如旧版本使用bcrypt.MinCost生成的hash），用本次登录的密码重新生成*/
+	if utils.NeedsRehash(manager.MgPwd) {
+		manager.MgPwd = utils.HashAndSalt(password)
+		if _, err := o.Update(&manager, "MgPwd"); err != nil {
+			logs.Error("更新密码hash出错", err)
+		}
+	}
+	return &Identity{Provider: models.SourceLocal, Subject: strconv.Itoa(manager.MgId), UserName: manager.MgName,
+		Email: manager.MgEmail, Mobile: manager.MgMobile}, nil
+}
+
+// 查询本地账号
+func localManager(userName string) (*models.SpManager, error) {
+	o := orm.NewOrm()
+	manager := models.SpManager{MgName: userName}
+	err := o.Read(&manager, "MgName")
+	if err == orm.ErrNoRows || err == nil && manager.IsDeleted() {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &manager, nil
+}
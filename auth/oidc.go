@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect登录的账号来源（sp_manager.mg_source）
+const OidcSource = "oidc"
+
+/* OpenID Connect授权码登录：前端跳转到身份提供方的授权地址，用户登录后身份提供方带着code跳转回前端，
+*  前端把code提交给login/oidc，这里用code换取id token，校验签名、签发方、受众、有效期和nonce后得到用户信息 */
+type OidcProvider struct {
+	// 签发方地址，从<Issuer>/.well-known/openid-configuration读取各接口地址
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// 身份提供方登录后跳转回的前端地址，必须与在身份提供方登记的一致
+	RedirectURL string
+	Scopes      []string
+	// 用户名、用户组所在的claim
+	UserNameClaim string
+	GroupsClaim   string
+	Client        *http.Client
+
+	lock      sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcProvider     *OidcProvider
+	oidcProviderOnce sync.Once
+)
+
+// 根据配置文件中Oidc开头的配置项创建OpenID Connect登录，没有配置OidcIssuer时返回nil
+func OidcProviderFromConfig() *OidcProvider {
+	oidcProviderOnce.Do(func() {
+		issuer := beego.AppConfig.String("OidcIssuer")
+		if issuer == "" {
+			return
+		}
+		oidcProvider = &OidcProvider{
+			Issuer:        issuer,
+			ClientId:      beego.AppConfig.String("OidcClientId"),
+			ClientSecret:  beego.AppConfig.String("OidcClientSecret"),
+			RedirectURL:   beego.AppConfig.String("OidcRedirectURL"),
+			Scopes:        beego.AppConfig.Strings("OidcScopes"),
+			UserNameClaim: beego.AppConfig.DefaultString("OidcUserNameClaim", "preferred_username"),
+			GroupsClaim:   beego.AppConfig.DefaultString("OidcGroupsClaim", "groups"),
+		}
+	})
+	return oidcProvider
+}
+
+func (this *OidcProvider) client() *http.Client {
+	if this.Client != nil {
+		return this.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (this *OidcProvider) getJson(u string, v interface{}) error {
+	resp, err := this.client().Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求%s失败：%s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// 读取身份提供方的配置，读取成功后缓存
+func (this *OidcProvider) getDiscovery() (*oidcDiscovery, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.discovery != nil {
+		return this.discovery, nil
+	}
+	var discovery oidcDiscovery
+	err := this.getJson(strings.TrimSuffix(this.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != this.Issuer {
+		return nil, fmt.Errorf("签发方不一致：%s", discovery.Issuer)
+	}
+	this.discovery = &discovery
+	return this.discovery, nil
+}
+
+/* 生成身份提供方的授权地址，state和nonce用于在登录回来时确认是本次发起的登录 */
+func (this *OidcProvider) AuthCodeURL(state, nonce string) (string, error) {
+	discovery, err := this.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	scopes := this.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {this.ClientId},
+		"redirect_uri":  {this.RedirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+/* 用授权码换取id token并校验，返回用户信息。code无效或id token校验未通过时返回ErrInvalidCredentials */
+func (this *OidcProvider) Exchange(code, nonce string) (*Identity, error) {
+	if code == "" {
+		return nil, ErrInvalidCredentials
+	}
+	discovery, err := this.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {this.RedirectURL},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(this.ClientId), url.QueryEscape(this.ClientSecret))
+	resp, err := this.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// 授权码无效或已使用时返回400；401表示client id或client secret配置错误，不是用户的问题
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, ErrInvalidCredentials
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("换取id token失败：%s", resp.Status)
+	}
+	var tokenResp struct {
+		IdToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return nil, err
+	}
+	if tokenResp.IdToken == "" {
+		return nil, errors.New("身份提供方没有返回id token")
+	}
+	claims, err := this.verifyIdToken(tokenResp.IdToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Provider: OidcSource}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.UserName, _ = claims[this.UserNameClaim].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Mobile, _ = claims["phone_number"].(string)
+	if groups, ok := claims[this.GroupsClaim].([]interface{}); ok {
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, g)
+			}
+		}
+	}
+	if identity.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if identity.UserName == "" {
+		identity.UserName = identity.Subject
+	}
+	return identity, nil
+}
+
+// 校验id token：签名（RS256）、签发方、受众、有效期以及nonce。读取JWKS失败时返回ErrProviderUnavailable
+func (this *OidcProvider) verifyIdToken(idToken, nonce string) (jwt.MapClaims, error) {
+	var keyErr error
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := this.publicKey(kid)
+		if err == ErrProviderUnavailable {
+			keyErr = err
+		}
+		return key, err
+	})
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidCredentials
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if iss, _ := claims["iss"].(string); iss != this.Issuer {
+		return nil, ErrInvalidCredentials
+	}
+	if !audienceContains(claims["aud"], this.ClientId) {
+		return nil, ErrInvalidCredentials
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, ErrInvalidCredentials
+	}
+	return claims, nil
+}
+
+// aud可以是字符串，也可以是字符串数组
+func audienceContains(aud interface{}, clientId string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 根据kid查找身份提供方的公钥，找不到时重新读取一次JWKS（身份提供方可能轮换了密钥）。
+// 读取JWKS失败时返回ErrProviderUnavailable
+func (this *OidcProvider) publicKey(kid string) (*rsa.PublicKey, error) {
+	this.lock.Lock()
+	key := this.keys[kid]
+	this.lock.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	discovery, err := this.getDiscovery()
+	if err != nil {
+		logs.Error("读取身份提供方配置出错", err)
+		return nil, ErrProviderUnavailable
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = this.getJson(discovery.JwksURI, &set)
+	if err != nil {
+		logs.Error("读取身份提供方的JWKS出错", err)
+		return nil, ErrProviderUnavailable
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	this.lock.Lock()
+	this.keys = keys
+	this.lock.Unlock()
+	if keys[kid] == nil {
+		return nil, fmt.Errorf("Unknown kid: %v", kid)
+	}
+	return keys[kid], nil
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试用的OpenID Connect身份提供方，实现配置发现、JWKS和授权码换取id token
+type fakeOidcServer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	clientId string
+	secret   string
+	// 不为0时JWKS接口返回该状态码，模拟身份提供方故障
+	jwksStatus int
+
+	lock sync.Mutex
+	// 授权码对应的id token中的claims，授权码只能使用一次
+	codes map[string]jwt.MapClaims
+}
+
+func startFakeOidc(t *testing.T) *fakeOidcServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeOidcServer{key: key, kid: "key-1", clientId: "jdstore", secret: "client-secret",
+		codes: make(map[string]jwt.MapClaims)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fake.issuer(),
+			"authorization_endpoint": fake.issuer() + "/authorize",
+			"token_endpoint":         fake.issuer() + "/token",
+			"jwks_uri":               fake.issuer() + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		fake.lock.Lock()
+		status := fake.jwksStatus
+		fake.lock.Unlock()
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": fake.kid,
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", fake.handleToken)
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (this *fakeOidcServer) issuer() string {
+	return this.server.URL
+}
+
+func (this *fakeOidcServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientId, secret, ok := r.BasicAuth()
+	if !ok || clientId != this.clientId || secret != this.secret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	this.lock.Lock()
+	claims, ok := this.codes[r.FormValue("code")]
+	delete(this.codes, r.FormValue("code"))
+	this.lock.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": this.sign(claims, this.key, this.kid),
+		"access_token": "access", "token_type": "Bearer"})
+}
+
+func (this *fakeOidcServer) sign(claims jwt.MapClaims, key *rsa.PrivateKey, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, _ := token.SignedString(key)
+	return signed
+}
+
+// 模拟用户在身份提供方登录成功，返回授权码
+func (this *fakeOidcServer) issueCode(claims jwt.MapClaims) string {
+	code := "code-" + big.NewInt(time.Now().UnixNano()).String()
+	this.lock.Lock()
+	this.codes[code] = claims
+	this.lock.Unlock()
+	return code
+}
+
+func (this *fakeOidcServer) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                this.issuer(),
+		"aud":                this.clientId,
+		"sub":                "user-42",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": "carol",
+		"email":              "carol@example.com",
+		"groups":             []string{"jd-admins", "staff"},
+	}
+}
+
+func newTestOidcProvider(fake *fakeOidcServer) *OidcProvider {
+	return &OidcProvider{Issuer: fake.issuer(), ClientId: fake.clientId, ClientSecret: fake.secret,
+		RedirectURL: "https://admin.example.com/oidc/callback", UserNameClaim: "preferred_username",
+		GroupsClaim: "groups"}
+}
+
+func TestOidcAuthCodeURL(t *testing.T) {
+	fake := startFakeOidc(t)
+	provider := newTestOidcProvider(fake)
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, fake.issuer()+"/authorize?") {
+		t.Errorf("url = %s", authURL)
+	}
+	query := u.Query()
+	want := map[string]string{"response_type": "code", "client_id": "jdstore", "state": "state-1", "nonce": "nonce-1",
+		"redirect_uri": "https://admin.example.com/oidc/callback", "scope": "openid profile email"}
+	for k, v := range want {
+		if query.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, query.Get(k), v)
+		}
+	}
+}
+
+func TestOidcExchange(t *testing.T) {
+	fake := startFakeOidc(t)
+	provider := newTestOidcProvider(fake)
+
+	code := fake.issueCode(fake.claims("nonce-1"))
+	identity, err := provider.Exchange(code, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Identity{Provider: OidcSource, Subject: "user-42", UserName: "carol", Email: "carol@example.com",
+		Groups: []string{"jd-admins", "staff"}}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("identity = %+v, want %+v", identity, want)
+	}
+
+	// 授权码只能使用一次
+	_, err = provider.Exchange(code, "nonce-1")
+	if err != ErrInvalidCredentials {
+		t.Errorf("reused code: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOidcExchangeRejectsInvalidIdToken(t *testing.T) {
+	fake := startFakeOidc(t)
+	provider := newTestOidcProvider(fake)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		nonce  string
+	}{
+		{"nonce不一致", func(claims jwt.MapClaims) {}, "other-nonce"},
+		{"受众不一致", func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, "nonce-1"},
+		{"签发方不一致", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }, "nonce-1"},
+		{"已过期", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }, "nonce-1"},
+		{"没有sub", func(claims jwt.MapClaims) { delete(claims, "sub") }, "nonce-1"},
+	}
+	for _, c := range cases {
+		claims := fake.claims("nonce-1")
+		c.modify(claims)
+		_, err := provider.Exchange(fake.issueCode(claims), c.nonce)
+		if err != ErrInvalidCredentials {
+			t.Errorf("%s：err = %v, want ErrInvalidCredentials", c.name, err)
+		}
+	}
+
+	// aud为数组时包含本系统即可
+	claims := fake.claims("nonce-1")
+	claims["aud"] = []string{"other-client", fake.clientId}
+	if _, err := provider.Exchange(fake.issueCode(claims), "nonce-1"); err != nil {
+		t.Errorf("aud array: err = %v", err)
+	}
+
+	// 使用其他密钥签名的id token
+	forged := fake.sign(fake.claims("nonce-1"), otherKey, fake.kid)
+	if _, err := provider.verifyIdToken(forged, "nonce-1"); err != ErrInvalidCredentials {
+		t.Errorf("forged signature: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	// 不接受HS256等其他算法（例如用公开的client id作为HMAC密钥伪造）
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, fake.claims("nonce-1"))
+	hmacSigned, _ := hmacToken.SignedString([]byte(fake.clientId))
+	if _, err := provider.verifyIdToken(hmacSigned, "nonce-1"); err != ErrInvalidCredentials {
+		t.Errorf("HS256: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOidcJwksUnavailable(t *testing.T) {
+	fake := startFakeOidc(t)
+	provider := newTestOidcProvider(fake)
+	fake.lock.Lock()
+	fake.jwksStatus = http.StatusInternalServerError
+	fake.lock.Unlock()
+
+	// 读取JWKS失败是身份提供方的问题，不能当作身份验证失败
+	_, err := provider.Exchange(fake.issueCode(fake.claims("nonce-1")), "nonce-1")
+	if err != ErrProviderUnavailable {
+		t.Errorf("jwks down: err = %v, want ErrProviderUnavailable", err)
+	}
+
+	// 恢复后可以正常登录，未知的kid仍然是身份验证失败
+	fake.lock.Lock()
+	fake.jwksStatus = 0
+	fake.lock.Unlock()
+	if _, err := provider.Exchange(fake.issueCode(fake.claims("nonce-1")), "nonce-1"); err != nil {
+		t.Errorf("jwks recovered: err = %v", err)
+	}
+	unknownKid := fake.sign(fake.claims("nonce-1"), fake.key, "key-2")
+	if _, err := provider.verifyIdToken(unknownKid, "nonce-1"); err != ErrInvalidCredentials {
+		t.Errorf("unknown kid: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOidcExchangeWrongClientSecret(t *testing.T) {
+	fake := startFakeOidc(t)
+	provider := newTestOidcProvider(fake)
+	provider.ClientSecret = "wrong"
+
+	// 客户端配置错误是服务端的问题，不能当作用户身份验证失败
+	_, err := provider.Exchange(fake.issueCode(fake.claims("nonce-1")), "nonce-1")
+	if err == nil || err == ErrInvalidCredentials {
+		t.Errorf("err = %v, want a client authentication error", err)
+	}
+}
+
+func TestOidcIssuerMismatch(t *testing.T) {
+	fake := startFakeOidc(t)
+	provider := newTestOidcProvider(fake)
+	provider.Issuer = fake.issuer() + "/"
+
+	// 配置的签发方与身份提供方返回的不一致时不能登录
+	if _, err := provider.AuthCodeURL("state-1", "nonce-1"); err == nil {
+		t.Error("expected issuer mismatch error")
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"JDStore/models"
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"strconv"
+	"strings"
+)
+
+// 登录认证。用户名密码登录时按配置文件LoginProviders中的顺序依次尝试各个认证方式（本地密码、LDAP），
+// OpenID Connect使用授权码方式单独登录。外部身份认证通过后自动创建或更新对应的管理员，
+// 并按配置文件中的<Provider>GroupRoles把用户组对应为角色
+
+// 用户名或密码错误。各认证方式对用户不存在和密码错误返回同一个错误，避免猜测用户名
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// 身份提供方暂时不可用（例如读取OpenID Connect的JWKS失败），不是用户的身份验证失败
+var ErrProviderUnavailable = errors.New("身份提供方不可用")
+
+/* 认证通过后，身份提供方返回的用户信息 */
+type Identity struct {
+	// 认证方式：local、ldap、oidc
+	Provider string
+	// 用户在身份提供方中的唯一标识，例如LDAP中的DN、OIDC中的sub
+	Subject  string
+	UserName string
+	Email    string
+	Mobile   string
+	// 用户所在的用户组，用于对应角色
+	Groups []string
+}
+
+/* 用户名密码认证方式 */
+type PasswordProvider interface {
+	Name() string
+	// 校验用户名和密码，失败时返回ErrInvalidCredentials；其他错误表示认证服务不可用
+	Authenticate(userName, password string) (*Identity, error)
+}
+
+// 根据配置创建用户名密码认证方式，默认只使用本地密码
+func PasswordProviders() []PasswordProvider {
+	names := beego.AppConfig.Strings("LoginProviders")
+	var providers []PasswordProvider
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "":
+		case models.SourceLocal:
+			providers = append(providers, &LocalProvider{})
+		case LdapSource:
+			providers = append(providers, LdapProviderFromConfig())
+		default:
+			logs.Error("未知的登录方式", name)
+		}
+	}
+	if len(providers) == 0 {
+		providers = append(providers, &LocalProvider{})
+	}
+	return providers
+}
+
+/* 使用用户名和密码登录：依次尝试各认证方式，第一个认证通过的为准，返回对应的管理员 */
+func Login(providers []PasswordProvider, userName, password string) (*models.SpManager, error) {
+	for _, provider := range providers {
+		identity, err := provider.Authenticate(userName, password)
+		if err == ErrInvalidCredentials {
+			continue
+		}
+		if err != nil {
+			logs.Error("登录认证出错", provider.Name(), err)
+			return nil, err
+		}
+		return Resolve(identity)
+	}
+	return nil, ErrInvalidCredentials
+}
+
+/* 找出身份对应的管理员，外部身份自动创建或更新管理员 */
+func Resolve(identity *Identity) (*models.SpManager, error) {
+	if identity.Provider == models.SourceLocal {
+		return localManager(identity.UserName)
+	}
+	roleIds := GroupRoleIds(identity.Provider, identity.Groups)
+	return models.ProvisionExternalManager(identity.Provider, identity.Subject, identity.UserName,
+		identity.Email, identity.Mobile, roleIds)
+}
+
+/* 把用户组对应为角色id。配置项为<Provider>GroupRoles（例如LdapGroupRoles），格式为“用户组=角色id”，
+*  多个用分号分隔；LDAP的用户组是包含“=”的DN，以最后一个“=”分隔。没有对应任何角色时使用AuthDefaultRole */
+func GroupRoleIds(provider string, groups []string) []int {
+	key := strings.ToUpper(provider[:1]) + provider[1:] + "GroupRoles"
+	mapping := ParseGroupRoles(beego.AppConfig.Strings(key))
+	var roleIds []int
+	for _, group := range groups {
+		roleIds = append(roleIds, mapping[strings.ToLower(group)]...)
+	}
+	if len(roleIds) == 0 {
+		if roleId := beego.AppConfig.DefaultInt("AuthDefaultRole", 0); roleId > 0 {
+			roleIds = append(roleIds, roleId)
+		}
+	}
+	return roleIds
+}
+
+// 解析用户组和角色的对应关系，用户组不区分大小写，同一用户组可以对应多个角色
+func ParseGroupRoles(items []string) map[string][]int {
+	mapping := make(map[string][]int)
+	for _, item := range items {
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			continue
+		}
+		roleId, err := strconv.Atoi(strings.TrimSpace(item[i+1:]))
+		if err != nil {
+			logs.Error("用户组对应的角色id格式错误", item)
+			continue
+		}
+		group := strings.ToLower(strings.TrimSpace(item[:i]))
+		mapping[group] = append(mapping[group], roleId)
+	}
+	return mapping
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"JDStore/utils"
+	"fmt"
+	"os"
+)
+
+// 命令行工具，在服务器上管理token签名密钥。
+// ./JDStore rotate-key [RS256|EdDSA]：生成新的签名密钥，不指定算法时使用RS256；
+// ./JDStore retire-key <kid>：停用一个密钥，它签发的token立即失效。
+// 运行中的服务会自动读取修改后的密钥，不需要重启
+func runCommand(args []string) int {
+	switch args[0] {
+	case "rotate-key":
+		alg := utils.AlgRS256
+		if len(args) > 1 {
+			alg = args[1]
+		}
+		info, err := utils.RotateTokenKey(alg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "轮换密钥失败：", err)
+			return 1
+		}
+		fmt.Printf("已生成新的签名密钥 kid=%s alg=%s\n", info.Kid, info.Alg)
+		return 0
+	case "retire-key":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "请指定要停用的密钥kid")
+			return 2
+		}
+		info, err := utils.RetireTokenKey(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "停用密钥失败：", err)
+			return 1
+		}
+		fmt.Printf("已停用密钥 kid=%s\n", info.Kid)
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "未知的命令：", args[0])
+	return 2
+}
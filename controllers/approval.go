@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"JDStore/models"
+	"encoding/json"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+type ApprovalController struct {
+	beego.Controller
+}
+
+// 获取修改申请列表，可按状态筛选 【接口：change-requests 请求方式：get】
+func (this *ApprovalController) GetChangeRequests() {
+	var resChangeRequests models.ResChangeRequests
+
+	/* 获取数据 */
+	pagenum, err := this.GetInt("pagenum")
+	if err != nil || pagenum <= 0 {
+		logs.Error("pagenum为空或类型错误")
+		resChangeRequests.Meta = &models.ResMeta{"pagenum为空或类型错误", 400}
+		this.Data["json"] = resChangeRequests
+		this.ServeJSON()
+		return
+	}
+	pagesize, err := this.GetInt("pagesize")
+	if err != nil || pagesize <= 0 {
+		logs.Error("pagesize为空或类型错误")
+		resChangeRequests.Meta = &models.ResMeta{"pagesize为空或类型错误", 400}
+		this.Data["json"] = resChangeRequests
+		this.ServeJSON()
+		return
+	}
+	status := this.GetString("status")
+	if status != "" && status != models.ChangePending && status != models.ChangeApproved && status != models.ChangeRejected {
+		resChangeRequests.Meta = &models.ResMeta{"status必须是pending、approved、rejected", 400}
+		this.Data["json"] = resChangeRequests
+		this.ServeJSON()
+		return
+	}
+
+	total, requests, err := models.QueryChangeRequests(status, pagenum, pagesize)
+	if err != nil {
+		logs.Error("查询修改申请出错", err)
+		resChangeRequests.Meta = &models.ResMeta{"查询修改申请出错", 400}
+		this.Data["json"] = resChangeRequests
+		this.ServeJSON()
+		return
+	}
+
+	resChangeRequests.Data = &models.ResChangeRequestsData{total, pagenum, requests}
+	resChangeRequests.Meta = &models.ResMeta{"获取修改申请列表成功", 200}
+	this.Data["json"] = resChangeRequests
+	this.ServeJSON()
+}
+
+// 批准修改申请，批准后立即执行修改 【接口：change-requests/:id/approve 请求方式：put】
+func (this *ApprovalController) ApproveChangeRequest() {
+	this.decide(true)
+}
+
+// 拒绝修改申请 【接口：change-requests/:id/reject 请求方式：put】
+func (this *ApprovalController) RejectChangeRequest() {
+	this.decide(false)
+}
+
+// 审批修改申请，申请人不能审批自己的申请
+func (this *ApprovalController) decide(approve bool) {
+	var resChangeRequest models.ResChangeRequest
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resChangeRequest.Meta = &models.ResMeta{"修改申请id错误", 400}
+		this.Data["json"] = resChangeRequest
+		this.ServeJSON()
+		return
+	}
+	// 审批意见是可选的，body为空时不解析
+	var params models.DecideChangeParams
+	if len(this.Ctx.Input.RequestBody) > 0 {
+		err = json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+		if err != nil {
+			resChangeRequest.Meta = &models.ResMeta{"请求体中参数错误", 400}
+			this.Data["json"] = resChangeRequest
+			this.ServeJSON()
+			return
+		}
+	}
+	approver, err := models.CurrentManager(this.Ctx)
+	if err != nil {
+		resChangeRequest.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resChangeRequest
+		this.ServeJSON()
+		return
+	}
+
+	resChangeRequest.Data, err = models.DecideChangeRequest(id, approver, approve, params.Comment)
+	if err == models.ErrSelfApproval || err == models.ErrOutOfScope {
+		resChangeRequest.Meta = &models.ResMeta{err.Error(), 403}
+		this.Data["json"] = resChangeRequest
+		this.ServeJSON()
+		return
+	}
+	if err != nil {
+		logs.Error("审批修改申请出错", err)
+		resChangeRequest.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resChangeRequest
+		this.ServeJSON()
+		return
+	}
+
+	msg := "已拒绝修改申请"
+	if approve {
+		msg = "已批准修改申请，修改已生效"
+	}
+	resChangeRequest.Meta = &models.ResMeta{msg, 200}
+	this.Data["json"] = resChangeRequest
+	this.ServeJSON()
+}
+
+// 提交修改申请，返回202表示修改尚未生效，需要等待审批
+func serveChangeRequest(c *beego.Controller, action string, entityId int, payload interface{}) {
+	var resChangeRequest models.ResChangeRequest
+	requester, err := models.CurrentManager(c.Ctx)
+	if err != nil {
+		resChangeRequest.Meta = &models.ResMeta{"无效token", 401}
+		c.Data["json"] = resChangeRequest
+		c.ServeJSON()
+		return
+	}
+	resChangeRequest.Data, err = models.CreateChangeRequest(action, entityId, payload, requester)
+	if err != nil {
+		logs.Error("提交修改申请出错", err)
+		resChangeRequest.Meta = &models.ResMeta{err.Error(), 400}
+		c.Data["json"] = resChangeRequest
+		c.ServeJSON()
+		return
+	}
+	resChangeRequest.Meta = &models.ResMeta{"修改已提交，等待审批", 202}
+	c.Data["json"] = resChangeRequest
+	c.ServeJSON()
+}
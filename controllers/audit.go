@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"JDStore/models"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+type AuditController struct {
+	beego.Controller
+}
+
+// 获取审计日志列表，可按操作人、对象类型、对象id、时间范围筛选 【接口：audit-logs 请求方式：get】
+func (this *AuditController) GetAuditLogs() {
+	var resAuditLogs models.ResAuditLogs
+
+	/* 获取数据 */
+	pagenum, err := this.GetInt("pagenum")
+	if err != nil || pagenum <= 0 {
+		logs.Error("pagenum为空或类型错误")
+		resAuditLogs.Meta = &models.ResMeta{"pagenum为空或类型错误", 400}
+		this.Data["json"] = resAuditLogs
+		this.ServeJSON()
+		return
+	}
+	pagesize, err := this.GetInt("pagesize")
+	if err != nil || pagesize <= 0 {
+		logs.Error("pagesize为空或类型错误")
+		resAuditLogs.Meta = &models.ResMeta{"pagesize为空或类型错误", 400}
+		this.Data["json"] = resAuditLogs
+		this.ServeJSON()
+		return
+	}
+	// 时间范围是unix时间戳，不传表示不限制
+	startTime, err := this.GetInt("start_time", 0)
+	if err != nil {
+		resAuditLogs.Meta = &models.ResMeta{"start_time类型错误", 400}
+		this.Data["json"] = resAuditLogs
+		this.ServeJSON()
+		return
+	}
+	endTime, err := this.GetInt("end_time", 0)
+	if err != nil {
+		resAuditLogs.Meta = &models.ResMeta{"end_time类型错误", 400}
+		this.Data["json"] = resAuditLogs
+		this.ServeJSON()
+		return
+	}
+	params := models.AuditLogParams{
+		Pagenum: pagenum, Pagesize: pagesize,
+		Actor: this.GetString("actor"), EntityType: this.GetString("entity_type"), EntityId: this.GetString("entity_id"),
+		StartTime: startTime, EndTime: endTime}
+
+	total, auditLogs, err := models.QueryAuditLogs(&params)
+	if err != nil {
+		logs.Error("查询审计日志出错", err)
+		resAuditLogs.Meta = &models.ResMeta{"查询审计日志出错", 400}
+		this.Data["json"] = resAuditLogs
+		this.ServeJSON()
+		return
+	}
+
+	resAuditLogs.Data = &models.ResAuditLogsData{total, pagenum, auditLogs}
+	resAuditLogs.Meta = &models.ResMeta{"获取审计日志成功", 200}
+	this.Data["json"] = resAuditLogs
+	this.ServeJSON()
+}
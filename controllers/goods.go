@@ -17,20 +17,47 @@ type GoodsController struct {
 	beego.Controller
 }
 
+// 查询当前管理员的数据范围，出错时返回错误信息
+func (this *GoodsController) dataScope() (*models.DataScope, *models.ResMeta) {
+	scope, err := models.CurrentDataScope(this.Ctx)
+	if err != nil {
+		logs.Error("查询数据范围出错", err)
+		return nil, &models.ResMeta{"查询数据范围出错", 400}
+	}
+	return scope, nil
+}
+
+// 校验分类是否在当前管理员的数据范围内，校验未通过时返回错误信息（超出范围时为403）
+func (this *GoodsController) checkCateScope(catId int) *models.ResMeta {
+	scope, meta := this.dataScope()
+	if meta != nil {
+		return meta
+	}
+	err := scope.CheckCategory(catId)
+	if err == models.ErrOutOfScope {
+		logs.Error(err)
+		return &models.ResMeta{err.Error(), 403}
+	}
+	if err != nil {
+		logs.Error("校验数据范围出错", err)
+		return &models.ResMeta{"分类id不存在", 400}
+	}
+	return nil
+}
+
+// 商品操作出错时返回的错误信息，超出数据范围时为403
+func goodScopeMeta(err error, msg string) *models.ResMeta {
+	if err == models.ErrOutOfScope {
+		return &models.ResMeta{err.Error(), 403}
+	}
+	return &models.ResMeta{msg, 400}
+}
+
 // 获取商品分类数据列表
 func (this *GoodsController) GetGoodsCate() {
 	// 不带分页参数返回的数据格式
 	var resCateList models.ResCateList
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 149)
-	if !hasRight {
-		resCateList.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resCateList
-		this.ServeJSON()
-		return
-	}
-
 	// 获取数据
 	typ, err := this.GetInt("type")
 	errStr := fmt.Sprintf("%s", err)
@@ -71,9 +98,18 @@ func (this *GoodsController) GetGoodsCate() {
 		return
 	}
 
+	// 只返回数据范围内的分类
+	scope, meta := this.dataScope()
+	if meta != nil {
+		resCateList.Meta = meta
+		this.Data["json"] = resCateList
+		this.ServeJSON()
+		return
+	}
+
 	if pagenum == 0 || pagesize == 0 {
 		// 获取不带分页参数的商品分类列表
-		resCateList.Data, err = models.GetCateList(typ)
+		resCateList.Data, err = models.GetCateList(typ, scope)
 		if err != nil {
 			resCateList.Meta = &models.ResMeta{"获取不带分页参数的商品分类列表时出错", 400}
 			this.Data["json"] = resCateList
@@ -89,7 +125,7 @@ func (this *GoodsController) GetGoodsCate() {
 	// 带分页参数返回的数据格式
 	var resCatePage models.ResCatePage
 	// 获取带分页参数的商品分类列表
-	resCatePage.Data, err = models.GetCatePage(typ, pagenum, pagesize)
+	resCatePage.Data, err = models.GetCatePage(typ, pagenum, pagesize, scope)
 	if err != nil {
 		resCatePage.Meta = &models.ResMeta{"获取带分页参数的商品分类列表时出错", 400}
 		this.Data["json"] = resCatePage
@@ -105,15 +141,6 @@ func (this *GoodsController) GetGoodsCate() {
 func (this *GoodsController) AddGoodsCate() {
 	var resAddCate models.ResAddCate
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 122)
-	if !hasRight {
-		resAddCate.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resAddCate
-		this.ServeJSON()
-		return
-	}
-
 	// 获取数据
 	var addCateParams models.AddCateParams
 	err := json.Unmarshal(this.Ctx.Input.RequestBody, &addCateParams)
@@ -147,6 +174,13 @@ func (this *GoodsController) AddGoodsCate() {
 		this.ServeJSON()
 		return
 	}
+	// 只能在数据范围内的分类下添加子分类
+	if meta := this.checkCateScope(addCateParams.Cat_pid); meta != nil {
+		resAddCate.Meta = meta
+		this.Data["json"] = resAddCate
+		this.ServeJSON()
+		return
+	}
 
 	// 数据校验通过，向数据库中插入数据
 	category := models.SpCategory{
@@ -194,6 +228,12 @@ func (this *GoodsController) UpdateCateName() {
 		this.ServeJSON()
 		return
 	}
+	if meta := this.checkCateScope(id); meta != nil {
+		resAddCate.Meta = meta
+		this.Data["json"] = resAddCate
+		this.ServeJSON()
+		return
+	}
 
 	// 获取请求体中的参数
 	var updateCateParams models.UpdateCateParams
@@ -234,15 +274,6 @@ func (this *GoodsController) UpdateCateName() {
 func (this *GoodsController) DeleteCate() {
 	var resAddCate models.ResAddCate
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 123)
-	if !hasRight {
-		resAddCate.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resAddCate
-		this.ServeJSON()
-		return
-	}
-
 	// 获取数据
 	id, err := this.GetInt(":id")
 	if err != nil {
@@ -261,6 +292,12 @@ func (this *GoodsController) DeleteCate() {
 		this.ServeJSON()
 		return
 	}
+	if meta := this.checkCateScope(id); meta != nil {
+		resAddCate.Meta = meta
+		this.Data["json"] = resAddCate
+		this.ServeJSON()
+		return
+	}
 	// 数据校验通过，删除分类（实际上执行的是假删操作，修改删除状态）
 	cate.CatDeleted = 1
 	_, err = o.Update(&cate, "CatDeleted")
@@ -280,16 +317,6 @@ func (this *GoodsController) DeleteCate() {
 func (this *GoodsController) GetAttrList() {
 	var resAttrList models.ResAttrList
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 142)
-	if !hasRight {
-		logs.Error("权限不足")
-		resAttrList.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resAttrList
-		this.ServeJSON()
-		return
-	}
-
 	// 获取数据
 	id, err := this.GetInt(":id")
 	if err != nil {
@@ -318,6 +345,12 @@ func (this *GoodsController) GetAttrList() {
 		this.ServeJSON()
 		return
 	}
+	if meta := this.checkCateScope(id); meta != nil {
+		resAttrList.Meta = meta
+		this.Data["json"] = resAttrList
+		this.ServeJSON()
+		return
+	}
 
 	// 获取属性类型
 	sel := this.GetString("sel")
@@ -363,16 +396,6 @@ func (this *GoodsController) GetAttrList() {
 func (this *GoodsController) AddAttr() {
 	var resAttr models.ResAttr
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 156)
-	if !hasRight {
-		logs.Error("权限不足")
-		resAttr.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resAttr
-		this.ServeJSON()
-		return
-	}
-
 	// 获取数据
 	id, err := this.GetInt(":id")
 	if err != nil {
@@ -390,6 +413,12 @@ func (this *GoodsController) AddAttr() {
 		this.ServeJSON()
 		return
 	}
+	if meta := this.checkCateScope(id); meta != nil {
+		resAttr.Meta = meta
+		this.Data["json"] = resAttr
+		this.ServeJSON()
+		return
+	}
 
 	// 获取请求体中的参数
 	var addAttrParams models.AddAttrParams
@@ -447,8 +476,6 @@ func (this *GoodsController) AddAttr() {
 func (this *GoodsController) UpdateAttr() {
 	var resAttr models.ResAttr
 
-	// 没有找到修改参数相应的权限，此处省略权限验证
-
 	// 获取数据
 	id, err := this.GetInt(":id")
 	if err != nil {
@@ -466,6 +493,12 @@ func (this *GoodsController) UpdateAttr() {
 		this.ServeJSON()
 		return
 	}
+	if meta := this.checkCateScope(id); meta != nil {
+		resAttr.Meta = meta
+		this.Data["json"] = resAttr
+		this.ServeJSON()
+		return
+	}
 
 	attrId, err := this.GetInt(":attrId")
 	if err != nil {
@@ -566,16 +599,6 @@ func (this *GoodsController) UpdateAttr() {
 func (this *GoodsController) DeleteAttr() {
 	var resAttr models.ResAttr
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 157)
-	if !hasRight {
-		logs.Error("权限不足")
-		resAttr.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resAttr
-		this.ServeJSON()
-		return
-	}
-
 	// 获取数据
 	id, err := this.GetInt(":id")
 	if err != nil {
@@ -593,6 +616,12 @@ func (this *GoodsController) DeleteAttr() {
 		this.ServeJSON()
 		return
 	}
+	if meta := this.checkCateScope(id); meta != nil {
+		resAttr.Meta = meta
+		this.Data["json"] = resAttr
+		this.ServeJSON()
+		return
+	}
 
 	attrId, err := this.GetInt(":attrId")
 	if err != nil {
@@ -631,16 +660,6 @@ func (this *GoodsController) DeleteAttr() {
 func (this *GoodsController) GetGoodsList() {
 	var resGoodsList models.ResGoodsList
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 153)
-	if !hasRight {
-		logs.Error("权限不足")
-		resGoodsList.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resGoodsList
-		this.ServeJSON()
-		return
-	}
-
 	/* 获取数据 */
 	query := this.GetString("query")
 	pagenum, err := this.GetInt("pagenum")
@@ -674,8 +693,17 @@ func (this *GoodsController) GetGoodsList() {
 		return
 	}
 
+	// 只查询数据范围内的商品
+	scope, meta := this.dataScope()
+	if meta != nil {
+		resGoodsList.Meta = meta
+		this.Data["json"] = resGoodsList
+		this.ServeJSON()
+		return
+	}
+
 	// 验证完毕，执行查询操作
-	total, goodsList, err := models.GetGoodsList(query, pagenum, pagesize)
+	total, goodsList, err := models.GetGoodsList(query, pagenum, pagesize, scope)
 	if err != nil {
 		logs.Error(err)
 		resGoodsList.Meta = &models.ResMeta{"查询执行出错", 400}
@@ -694,16 +722,6 @@ func (this *GoodsController) GetGoodsList() {
 func (this *GoodsController) UploadPicture() {
 	var resUpload models.ResUpload
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 150)
-	if !hasRight {
-		logs.Error("权限不足")
-		resUpload.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resUpload
-		this.ServeJSON()
-		return
-	}
-
 	oriPicPath, err := UploadFile(&this.Controller, "uploadPicture")
 	if err != nil {
 		resUpload.Meta = &models.ResMeta{err.Error(), 400}
@@ -787,16 +805,6 @@ func UploadFile(this *beego.Controller, filePath string) (string, error) {
 func (this *GoodsController) AddGood() {
 	var resAddGood models.ResAddGood
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 105)
-	if !hasRight {
-		logs.Error("权限不足")
-		resAddGood.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resAddGood
-		this.ServeJSON()
-		return
-	}
-
 	// 获取请求体中的参数
 	var addGoodBody models.AddGoodBody
 	err := json.Unmarshal(this.Ctx.Input.RequestBody, &addGoodBody)
@@ -808,10 +816,18 @@ func (this *GoodsController) AddGood() {
 		return
 	}
 
-	resAddGoodData, err := models.AddGood(addGoodBody)
+	scope, meta := this.dataScope()
+	if meta != nil {
+		resAddGood.Meta = meta
+		this.Data["json"] = resAddGood
+		this.ServeJSON()
+		return
+	}
+
+	resAddGoodData, err := models.AddGood(addGoodBody, scope)
 	if err != nil {
 		logs.Error(err)
-		resAddGood.Meta = &models.ResMeta{"添加商品失败", 400}
+		resAddGood.Meta = goodScopeMeta(err, "添加商品失败")
 		this.Data["json"] = resAddGood
 		this.ServeJSON()
 		return
@@ -823,25 +839,57 @@ func (this *GoodsController) AddGood() {
 	this.ServeJSON()
 }
 
-// 修改商品信息
-func (this *GoodsController) UpdateGoodInfo() {
-	var resGoodInfo models.ResGoodInfo
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 116)
-	if !hasRight {
-		resGoodInfo.Meta = &models.ResMeta{"权限不足", 403}
-		logs.Error("权限不足")
-		this.Data["json"] = resGoodInfo
+// 获取商品详情，包括图片、参数和分类路径，用于编辑商品时填充表单 【接口：goods/:id 请求方式：get】
+func (this *GoodsController) GetGoodInfo() {
+	var resGoodDetail models.ResGoodDetail
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resGoodDetail.Meta = &models.ResMeta{"商品id错误", 400}
+		this.Data["json"] = resGoodDetail
+		this.ServeJSON()
+		return
+	}
+
+	scope, meta := this.dataScope()
+	if meta != nil {
+		resGoodDetail.Meta = meta
+		this.Data["json"] = resGoodDetail
+		this.ServeJSON()
+		return
+	}
+
+	// 商品不在数据范围内时返回403
+	resGoodDetail.Data, err = models.GetGoodDetail(id, scope)
+	if err == orm.ErrNoRows {
+		resGoodDetail.Meta = &models.ResMeta{"商品id不存在", 400}
+		this.Data["json"] = resGoodDetail
+		this.ServeJSON()
+		return
+	}
+	if err != nil {
+		logs.Error("获取商品详情失败", err)
+		resGoodDetail.Meta = goodScopeMeta(err, "获取商品详情失败")
+		this.Data["json"] = resGoodDetail
 		this.ServeJSON()
 		return
 	}
+	resGoodDetail.Meta = &models.ResMeta{"获取商品详情成功", 200}
+	this.Data["json"] = resGoodDetail
+	this.ServeJSON()
+}
+
+// 修改商品信息，参数与添加商品相同，没有传入的字段保持不变。返回修改后的商品详情 【接口：goods/:id 请求方式：put】
+func (this *GoodsController) UpdateGoodInfo() {
+	var resGoodDetail models.ResGoodDetail
 
 	// 获取数据
 	id, err := this.GetInt(":id")
 	if err != nil {
-		resGoodInfo.Meta = &models.ResMeta{"商品id错误", 400}
+		resGoodDetail.Meta = &models.ResMeta{"商品id错误", 400}
 		logs.Error("商品id错误")
-		this.Data["json"] = resGoodInfo
+		this.Data["json"] = resGoodDetail
 		this.ServeJSON()
 		return
 	}
@@ -849,44 +897,75 @@ func (this *GoodsController) UpdateGoodInfo() {
 	// 验证商品id是否存在
 	goodExists := models.GoodExists(id)
 	if !goodExists {
-		resGoodInfo.Meta = &models.ResMeta{"商品id不存在", 400}
+		resGoodDetail.Meta = &models.ResMeta{"商品id不存在", 400}
 		logs.Error("商品id不存在")
-		this.Data["json"] = resGoodInfo
+		this.Data["json"] = resGoodDetail
 		this.ServeJSON()
 		return
 	}
 
 	// 获取请求体中的参数
-	//good:=models.SpGoods{}
-	good := new(models.SpGoods)
-	err = json.Unmarshal(this.Ctx.Input.RequestBody, &good)
+	var body models.UpdateGoodBody
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &body)
 	if err != nil {
-		resGoodInfo.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		resGoodDetail.Meta = &models.ResMeta{"请求体中参数错误", 400}
 		logs.Error("请求体中的参数错误")
-		this.Data["json"] = resGoodInfo
+		this.Data["json"] = resGoodDetail
 		this.ServeJSON()
 		return
 	}
-	if good.GoodsName == "" {
-		resGoodInfo.Meta = &models.ResMeta{"商品名称不能为空", 400}
+	if body.Goods_name == "" {
+		resGoodDetail.Meta = &models.ResMeta{"商品名称不能为空", 400}
 		logs.Error("商品名称不能为空")
-		this.Data["json"] = resGoodInfo
+		this.Data["json"] = resGoodDetail
 		this.ServeJSON()
 		return
 	}
 
-	// 执行数据库更新操作
-	resGood, err := models.UpdateGood(good, id)
+	scope, meta := this.dataScope()
+	if meta != nil {
+		resGoodDetail.Meta = meta
+		this.Data["json"] = resGoodDetail
+		this.ServeJSON()
+		return
+	}
+	requester, err := models.CurrentManager(this.Ctx)
 	if err != nil {
-		resGoodInfo.Meta = &models.ResMeta{"修改商品失败", 400}
+		resGoodDetail.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resGoodDetail
+		this.ServeJSON()
+		return
+	}
+
+	// 执行数据库更新操作，商品不在数据范围内时返回403。
+	// 价格有变化且开启了价格审批时，在同一个事务中提交修改申请，批准后才修改价格
+	change, err := models.UpdateGood(&body, id, scope, requester)
+	if err == models.ErrChangePending {
+		resGoodDetail.Meta = &models.ResMeta{"商品价格已有待审批的修改申请，本次修改未保存", 400}
 		logs.Error("修改商品失败", err)
-		this.Data["json"] = resGoodInfo
+		this.Data["json"] = resGoodDetail
 		this.ServeJSON()
 		return
 	}
-	resGoodInfo.Data = &models.ResGoodInfoData{resGood.GoodsName, resGood.GoodsPrice, resGood.GoodsWeight}
-	resGoodInfo.Meta = &models.ResMeta{"修改商品成功", 200}
-	this.Data["json"] = resGoodInfo
+	if err != nil {
+		resGoodDetail.Meta = goodScopeMeta(err, "修改商品失败："+err.Error())
+		logs.Error("修改商品失败", err)
+		this.Data["json"] = resGoodDetail
+		this.ServeJSON()
+		return
+	}
+	if change != nil {
+		this.Data["json"] = &models.ResChangeRequest{change, &models.ResMeta{"商品已修改，价格修改已提交，等待审批", 202}}
+		this.ServeJSON()
+		return
+	}
+
+	resGoodDetail.Data, err = models.GetGoodDetail(id, scope)
+	if err != nil {
+		logs.Error("获取商品详情失败", err)
+	}
+	resGoodDetail.Meta = &models.ResMeta{"修改商品成功", 200}
+	this.Data["json"] = resGoodDetail
 	this.ServeJSON()
 	return
 }
@@ -894,15 +973,6 @@ func (this *GoodsController) UpdateGoodInfo() {
 // 删除商品接口
 func (this *GoodsController) DeleteGood() {
 	var resGoodInfo models.ResGoodInfo
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 117)
-	if !hasRight {
-		resGoodInfo.Meta = &models.ResMeta{"权限不足", 403}
-		logs.Error("权限不足")
-		this.Data["json"] = resGoodInfo
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	id, err := this.GetInt(":id")
@@ -924,10 +994,18 @@ func (this *GoodsController) DeleteGood() {
 		return
 	}
 
-	// 执行数据库更新操作（假删）
-	err = models.DeleteGood(id)
+	scope, meta := this.dataScope()
+	if meta != nil {
+		resGoodInfo.Meta = meta
+		this.Data["json"] = resGoodInfo
+		this.ServeJSON()
+		return
+	}
+
+	// 执行数据库更新操作（假删），商品不在数据范围内时返回403
+	err = models.DeleteGood(id, scope)
 	if err != nil {
-		resGoodInfo.Meta = &models.ResMeta{"删除商品失败", 400}
+		resGoodInfo.Meta = goodScopeMeta(err, "删除商品失败")
 		logs.Error("修改商品失败", err)
 		this.Data["json"] = resGoodInfo
 		this.ServeJSON()
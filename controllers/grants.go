@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"JDStore/models"
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+)
+
+type GrantsController struct {
+	beego.Controller
+}
+
+// 根据路由中的:id查询管理员，查询失败时返回错误信息
+func (this *GrantsController) manager() (*models.SpManager, *models.ResMeta) {
+	userId, err := this.GetInt(":id")
+	if err != nil {
+		return nil, &models.ResMeta{"管理员id格式错误", 400}
+	}
+	manager := models.SpManager{MgId: userId}
+	o := orm.NewOrm()
+	err = o.Read(&manager)
+	if err != nil {
+		return nil, &models.ResMeta{"管理员id不存在", 400}
+	}
+	// 已删除的管理员只能查看临时授权，不能授予或撤销
+	if manager.IsDeleted() && this.Ctx.Input.Method() != "GET" {
+		return nil, &models.ResMeta{"管理员已被删除，请先恢复", 400}
+	}
+	return &manager, nil
+}
+
+// 获取管理员的临时授权，history=true时包括已过期、已撤销的授权 【接口：users/:id/grants 请求方式：get】
+func (this *GrantsController) GetUserGrants() {
+	var resGrantList models.ResGrantList
+
+	// 获取数据
+	manager, meta := this.manager()
+	if meta != nil {
+		resGrantList.Meta = meta
+		this.Data["json"] = resGrantList
+		this.ServeJSON()
+		return
+	}
+	history, err := this.GetBool("history", false)
+	if err != nil {
+		resGrantList.Meta = &models.ResMeta{"history参数错误", 400}
+		this.Data["json"] = resGrantList
+		this.ServeJSON()
+		return
+	}
+
+	resGrantList.Data, err = models.GetManagerGrants(manager.MgId, history)
+	if err != nil {
+		logs.Error("查询临时授权出错", err)
+		resGrantList.Meta = &models.ResMeta{"查询临时授权出错", 400}
+		this.Data["json"] = resGrantList
+		this.ServeJSON()
+		return
+	}
+	resGrantList.Meta = &models.ResMeta{"获取临时授权成功", 200}
+	this.Data["json"] = resGrantList
+	this.ServeJSON()
+}
+
+// 临时授予管理员一个角色或权限，到期后自动失效 【接口：users/:id/grants 请求方式：post】
+func (this *GrantsController) AddUserGrant() {
+	var resGrantInfo models.ResGrantInfo
+
+	// 获取数据
+	manager, meta := this.manager()
+	if meta != nil {
+		resGrantInfo.Meta = meta
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+	var params models.GrantParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		resGrantInfo.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+
+	// 校验请求参数
+	valid := validation.Validation{}
+	b, err := valid.Valid(&params)
+	if err != nil {
+		resGrantInfo.Meta = &models.ResMeta{"数据校验时发生内部错误", 400}
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+	if !b {
+		msg := ""
+		// 出错就退出循环，不必再继续遍历
+		for _, err := range valid.Errors {
+			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
+			break
+		}
+		resGrantInfo.Meta = &models.ResMeta{msg, 400}
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+
+	// 记录授权人
+	grantedBy := ""
+	current, err := models.CurrentManager(this.Ctx)
+	if err == nil {
+		grantedBy = current.MgName
+	}
+	resGrantInfo.Data, err = models.AddGrant(manager, &params, grantedBy)
+	if err != nil {
+		resGrantInfo.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+	resGrantInfo.Meta = &models.ResMeta{"添加临时授权成功", 201}
+	this.Data["json"] = resGrantInfo
+	this.ServeJSON()
+}
+
+// 提前撤销管理员的临时授权 【接口：users/:id/grants/:grantId 请求方式：delete】
+func (this *GrantsController) RevokeUserGrant() {
+	var resGrantInfo models.ResGrantInfo
+
+	// 获取数据
+	manager, meta := this.manager()
+	if meta != nil {
+		resGrantInfo.Meta = meta
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+	grantId, err := this.GetInt(":grantId")
+	if err != nil {
+		resGrantInfo.Meta = &models.ResMeta{"临时授权id格式错误", 400}
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+
+	resGrantInfo.Data, err = models.RevokeGrant(manager.MgId, grantId)
+	if err != nil {
+		resGrantInfo.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resGrantInfo
+		this.ServeJSON()
+		return
+	}
+	resGrantInfo.Meta = &models.ResMeta{"撤销临时授权成功", 200}
+	this.Data["json"] = resGrantInfo
+	this.ServeJSON()
+}
+
+// 获取所有管理员即将到期的临时授权，within为小时数，默认72小时 【接口：grants/expiring 请求方式：get】
+func (this *GrantsController) GetExpiringGrants() {
+	var resGrantList models.ResGrantList
+
+	within, err := this.GetInt("within", 72)
+	if err != nil || within <= 0 {
+		resGrantList.Meta = &models.ResMeta{"within必须是大于0的整数", 400}
+		this.Data["json"] = resGrantList
+		this.ServeJSON()
+		return
+	}
+
+	resGrantList.Data, err = models.GetExpiringGrants(within * 3600)
+	if err != nil {
+		logs.Error("查询即将到期的临时授权出错", err)
+		resGrantList.Meta = &models.ResMeta{"查询即将到期的临时授权出错", 400}
+		this.Data["json"] = resGrantList
+		this.ServeJSON()
+		return
+	}
+	resGrantList.Meta = &models.ResMeta{"获取即将到期的临时授权成功", 200}
+	this.Data["json"] = resGrantList
+	this.ServeJSON()
+}
@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"JDStore/utils"
+	"github.com/astaxie/beego"
+)
+
+type JwksController struct {
+	beego.Controller
+}
+
+// 返回校验token的公钥（JWKS格式），其他服务据此校验本系统签发的token，不需要登录 【接口：/.well-known/jwks.json 请求方式：get】
+func (this *JwksController) GetJwks() {
+	// 允许其他服务缓存一段时间，轮换密钥后新密钥最迟在缓存过期后被获取
+	this.Ctx.Output.Header("Cache-Control", "public, max-age=300")
+	this.Data["json"] = utils.Jwks()
+	this.ServeJSON()
+}
@@ -1,19 +1,30 @@
 package controllers
 
 import (
+	"JDStore/auth"
 	"JDStore/models"
 	"JDStore/utils"
 	"encoding/json"
+	"fmt"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/validation"
+	"strings"
 )
 
 type LoginController struct {
 	beego.Controller
 }
 
+// 记录登录尝试（包括客户端IP和User-Agent），记录失败不影响登录流程
+func (this *LoginController) recordLoginAttempt(userName, result string) {
+	err := models.RecordLoginAttempt(userName, this.Ctx.Input.IP(), this.Ctx.Input.UserAgent(), result)
+	if err != nil {
+		logs.Error("记录登录尝试失败", err)
+	}
+}
+
 func (this *LoginController) HandlePost() {
 	/*获取post请求中的参数（非json格式）*/
 	//var user GetParams
@@ -62,33 +73,459 @@ func (this *LoginController) HandlePost() {
 		return
 	}
 
-	/*从数据库中查询用户名是否存在以及密码是否正确*/
+	/*同一用户名或同一IP连续登录失败时，限制登录频率，失败次数过多时暂时锁定*/
+	ip := this.Ctx.Input.IP()
+	wait, err := models.CheckLoginAllowed(user.UserName, ip)
+	if err != nil {
+		logs.Error("查询登录失败记录出错", err)
+		resLogin.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	if wait > 0 {
+		this.recordLoginAttempt(user.UserName, models.LoginBlocked)
+		resLogin.Meta = &models.ResMeta{fmt.Sprintf("登录失败次数过多，请%d秒后再试", wait), 429}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+
+	/*按配置的认证方式（本地密码、LDAP等）依次校验用户名和密码，外部账号第一次登录时自动创建。
+	* 用户不存在和密码错误返回相同的提示，避免通过提示猜测用户名*/
+	manager, err := auth.Login(auth.PasswordProviders(), user.UserName, user.PassWord)
+	if err == auth.ErrInvalidCredentials {
+		this.recordLoginAttempt(user.UserName, models.LoginFailure)
+		resLogin.Meta = &models.ResMeta{"用户名或密码错误", 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	if err != nil {
+		this.serveExternalLoginError(err)
+		return
+	}
+	this.completeLogin(manager)
+}
+
+// 外部身份认证通过后创建管理员失败，或认证服务不可用
+func (this *LoginController) serveExternalLoginError(err error) {
+	var resLogin models.ResLogin
+	if err == models.ErrNoMappedRole || err == models.ErrManagerNameTaken || err == models.ErrManagerDeleted {
+		resLogin.Meta = &models.ResMeta{err.Error(), 403}
+	} else {
+		logs.Error("登录认证出错", err)
+		resLogin.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+	}
+	this.Data["json"] = resLogin
+	this.ServeJSON()
+}
+
+// 用户身份已确认，需要两步验证时先返回一个mfa token，输入动态验证码后再签发token（接口：login/mfa），否则直接签发token
+func (this *LoginController) completeLogin(manager *models.SpManager) {
+	var resLogin models.ResLogin
+	// 已禁用或已删除的管理员不能登录
+	if err := manager.CheckActive(); err != nil {
+		this.recordLoginAttempt(manager.MgName, models.LoginFailure)
+		resLogin.Meta = &models.ResMeta{err.Error(), 403}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	required, enrollRequired, err := models.MfaRequired(manager)
+	if err != nil {
+		logs.Error("查询两步验证信息出错", err)
+		resLogin.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	if required {
+		var resMfa models.ResMfa
+		resMfa.Data = &models.ResMfaData{MfaToken: utils.CreateMfaToken(manager.MgName), EnrollRequired: enrollRequired}
+		resMfa.Meta = &models.ResMeta{"请输入动态验证码", 202}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	/*创建一个token，将其和用户信息一同返回给前端，并返回成功信息。*/
+	this.recordLoginAttempt(manager.MgName, models.LoginSuccess)
+	this.serveLoginSuccess(manager, "", "", nil, "登陆成功")
+}
+
+// 获取OpenID Connect身份提供方的授权地址，前端跳转到该地址登录 【接口：login/oidc 请求方式：get】
+func (this *LoginController) OidcLoginURL() {
+	var resOidc models.ResOidcLogin
+	provider := auth.OidcProviderFromConfig()
+	if provider == nil {
+		resOidc.Meta = &models.ResMeta{"没有开启OpenID Connect登录", 404}
+		this.Data["json"] = resOidc
+		this.ServeJSON()
+		return
+	}
+	// nonce保存在state token中，登录回来时与id token中的nonce比较
+	nonce, err := utils.RandomToken()
+	if err != nil {
+		logs.Error("生成nonce出错", err)
+		resOidc.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+		this.Data["json"] = resOidc
+		this.ServeJSON()
+		return
+	}
+	state := utils.CreateOidcStateToken(nonce)
+	authURL, err := provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		logs.Error("读取身份提供方配置出错", err)
+		resOidc.Meta = &models.ResMeta{"身份提供方不可用", 502}
+		this.Data["json"] = resOidc
+		this.ServeJSON()
+		return
+	}
+	resOidc.Data = &models.ResOidcLoginData{authURL, state}
+	resOidc.Meta = &models.ResMeta{"请跳转到身份提供方登录", 200}
+	this.Data["json"] = resOidc
+	this.ServeJSON()
+}
+
+// 身份提供方登录后跳转回前端，前端提交code和state完成登录 【接口：login/oidc 请求方式：post】
+func (this *LoginController) HandleOidcLogin() {
+	var resLogin models.ResLogin
+	provider := auth.OidcProviderFromConfig()
+	if provider == nil {
+		resLogin.Meta = &models.ResMeta{"没有开启OpenID Connect登录", 404}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	var params models.OidcLoginParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil || params.Code == "" || params.State == "" {
+		resLogin.Meta = &models.ResMeta{"code和state不能为空", 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+
+	// state token只能使用一次
+	claims, ok := utils.ParseToken(params.State, utils.OidcStateToken)
+	if !ok {
+		resLogin.Meta = &models.ResMeta{"登录已过期，请重新登录", 401}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	utils.RevokeTokenString(params.State, utils.OidcStateToken)
+	nonce, _ := claims["userName"].(string)
+
+	identity, err := provider.Exchange(params.Code, nonce)
+	if err == auth.ErrInvalidCredentials {
+		resLogin.Meta = &models.ResMeta{"身份验证失败，请重新登录", 401}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	// 读取身份提供方的公钥失败，稍后重试即可，不是身份验证失败
+	if err == auth.ErrProviderUnavailable {
+		resLogin.Meta = &models.ResMeta{"身份提供方暂时不可用，请稍后重试", 503}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	if err != nil {
+		logs.Error("OpenID Connect登录出错", err)
+		resLogin.Meta = &models.ResMeta{"身份提供方不可用", 502}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	manager, err := auth.Resolve(identity)
+	if err != nil {
+		this.serveExternalLoginError(err)
+		return
+	}
+	this.completeLogin(manager)
+}
+
+// 登录成功，签发token并将其和用户信息一同返回给前端。sid为空时表示新的登录，创建一个会话
+func (this *LoginController) serveLoginSuccess(manager *models.SpManager, sid, refreshJti string, recoveryCodes []string, msg string) {
+	var resLogin models.ResLogin
+	if sid == "" {
+		var err error
+		refreshJti = utils.NewTokenId()
+		sid, err = models.CreateSession(manager, this.Ctx.Input.IP(), this.Ctx.Input.UserAgent(), refreshJti)
+		if err != nil {
+			logs.Error("创建登录会话出错", err)
+			resLogin.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+			this.Data["json"] = resLogin
+			this.ServeJSON()
+			return
+		}
+	}
+	// access token中保存管理员的角色和权限版本号，之后的请求不需要再查询
+	identity, err := models.ManagerTokenIdentity(manager, sid)
+	if err != nil {
+		logs.Error("查询管理员权限出错", err)
+		resLogin.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	token := utils.CreateToken(identity)
+	refreshToken := utils.CreateRefreshToken(manager.MgName, sid, refreshJti)
+	resLogin.Data = &models.ResData{manager.MgId, manager.RoleId, manager.MgName,
+		manager.MgMobile, manager.MgEmail, utils.GetHeaderTokenValue(token), refreshToken, recoveryCodes}
+	resLogin.Meta = &models.ResMeta{msg, 200}
+	this.Data["json"] = resLogin
+	this.ServeJSON()
+}
+
+// 两步验证：校验mfa token和动态验证码（或恢复码），通过后签发token 【接口：login/mfa 请求方式：post】
+// 尚未绑定（所属角色要求必须启用）时，先调用login/mfa/enroll获取密钥，这里校验通过即完成绑定
+func (this *LoginController) HandleMfa() {
+	var resLogin models.ResLogin
+	var params models.MfaParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil || params.Code == "" {
+		resLogin.Meta = &models.ResMeta{"验证码不能为空", 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	manager, ok := this.mfaManager(params.MfaToken)
+	if !ok {
+		return
+	}
+
+	// 验证码同样受登录失败次数的限制
+	ip := this.Ctx.Input.IP()
+	wait, err := models.CheckLoginAllowed(manager.MgName, ip)
+	if err != nil {
+		logs.Error("查询登录失败记录出错", err)
+		resLogin.Meta = &models.ResMeta{"登录校验时发生内部错误", 500}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	if wait > 0 {
+		this.recordLoginAttempt(manager.MgName, models.LoginBlocked)
+		resLogin.Meta = &models.ResMeta{fmt.Sprintf("登录失败次数过多，请%d秒后再试", wait), 429}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+
+	mfa, err := models.GetManagerMfa(manager.MgId)
+	if err != nil || mfa == nil {
+		resLogin.Meta = &models.ResMeta{"请先绑定两步验证", 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	var recoveryCodes []string
+	if mfa.Enabled == 1 {
+		ok, err = models.VerifyMfaCode(manager.MgId, params.Code)
+	} else {
+		recoveryCodes, err = models.ConfirmMfaEnroll(manager.MgId, params.Code)
+		ok = err == nil
+	}
+	if !ok {
+		this.recordLoginAttempt(manager.MgName, models.LoginFailure)
+		resLogin.Meta = &models.ResMeta{"验证码错误", 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+
+	// mfa token只能使用一次
+	utils.RevokeTokenString(params.MfaToken, utils.MfaToken)
+	this.recordLoginAttempt(manager.MgName, models.LoginSuccess)
+	this.serveLoginSuccess(manager, "", "", recoveryCodes, "登陆成功")
+}
+
+// 登录时绑定两步验证，返回密钥和二维码地址 【接口：login/mfa/enroll 请求方式：post】
+func (this *LoginController) HandleMfaEnroll() {
+	var resMfa models.ResMfa
+	var params models.MfaParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		logs.Info("json.Unmarshal is err:", err)
+	}
+	manager, ok := this.mfaManager(params.MfaToken)
+	if !ok {
+		return
+	}
+	resMfa.Data, err = models.StartMfaEnroll(manager)
+	if err != nil {
+		resMfa.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	resMfa.Data.MfaToken = params.MfaToken
+	resMfa.Meta = &models.ResMeta{"请使用验证器扫描二维码，并输入动态验证码完成绑定", 200}
+	this.Data["json"] = resMfa
+	this.ServeJSON()
+}
+
+// 校验mfa token并查询对应的管理员，校验未通过时直接返回错误信息
+func (this *LoginController) mfaManager(mfaToken string) (*models.SpManager, bool) {
+	var resLogin models.ResLogin
+	claims, ok := utils.ParseToken(mfaToken, utils.MfaToken)
+	if !ok {
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return nil, false
+	}
+	o := orm.NewOrm()
+	manager := models.SpManager{MgName: claims["userName"].(string)}
+	err := o.Read(&manager, "MgName")
+	if err == nil {
+		err = manager.CheckActive()
+	}
+	if err != nil {
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return nil, false
+	}
+	return &manager, true
+}
+
+// 使用refresh token换取新的token 【接口：login/refresh 请求方式：post】
+func (this *LoginController) RefreshToken() {
+	var resLogin models.ResLogin
+	var params models.RefreshParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil || params.RefreshToken == "" {
+		resLogin.Meta = &models.ResMeta{"refresh_token不能为空", 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+
+	// 校验refresh token
+	claims, ok := utils.ParseToken(params.RefreshToken, utils.RefreshToken)
+	if !ok {
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	// 用户可能已在token签发后被删除或禁用
 	o := orm.NewOrm()
-	manager := models.SpManager{MgName: user.UserName}
+	manager := models.SpManager{MgName: claims["userName"].(string)}
 	err = o.Read(&manager, "MgName")
+	if err == nil {
+		err = manager.CheckActive()
+	}
 	if err != nil {
-		resLogin.Meta = &models.ResMeta{"用户不存在", 400}
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
 		this.Data["json"] = resLogin
 		this.ServeJSON()
 		return
 	}
-	/*将请求参数中的密码和数据库中加密后的密码进行比较，这里用到的是封装好的函数。
-	* 关于如何使用hash对密码进行加密、解密的详情参见：https://www.kancloud.cn/golang_programe/golang/1144844*/
-	data := []byte(user.PassWord)
-	result := utils.ComparePasswords(manager.MgPwd, data)
-	if !result {
-		resLogin.Meta = &models.ResMeta{"密码错误", 400}
+
+	// refresh token只能使用一次：延续原来的会话，同时把会话的refresh token换成新签发的。
+	// 并发使用同一个refresh token时只有一个成功；已经用过的refresh token再次使用时退出整个会话。
+	// 升级前签发的refresh token没有会话，无法判断是否已被使用，需要重新登录
+	sid, _ := claims["sid"].(string)
+	jti, _ := claims["jti"].(string)
+	if sid == "" {
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
 		this.Data["json"] = resLogin
 		this.ServeJSON()
 		return
-	} else {
-		/*程序执行到这里说明，用户存在且密码正确。创建一个token，将其和用户信息一同返回给前端，并返回成功信息。*/
-		token := utils.CreateToken(manager.MgName)
-		resLogin.Data = &models.ResData{manager.MgId, manager.RoleId, manager.MgName,
-			manager.MgMobile, manager.MgEmail, utils.GetHeaderTokenValue(token)}
-		resLogin.Meta = &models.ResMeta{"登陆成功", 200}
+	}
+	newJti := utils.NewTokenId()
+	err = models.RotateSession(sid, jti, newJti)
+	if err != nil {
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		if err == models.ErrRefreshReused {
+			resLogin.Meta.Msg = err.Error()
+		}
+		logs.Error("刷新token失败", err)
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	this.serveLoginSuccess(&manager, sid, newJti, nil, "刷新token成功")
+}
+
+// 退出登录，吊销当前的access token以及请求体中的refresh token 【接口：logout 请求方式：post】
+func (this *LoginController) Logout() {
+	var resLogin models.ResLogin
+	// 路由拦截器已经校验过请求头中的token
+	token := strings.TrimPrefix(this.Ctx.Input.Header("Authorization"), "Bearer ")
+	identity := models.CurrentIdentity(this.Ctx)
+	if identity == nil {
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	// 结束当前会话，该会话签发的其他token一并失效
+	if identity.Sid != "" {
+		if _, err := models.RevokeSession(identity.MgId, identity.Sid); err != nil {
+			logs.Error("结束登录会话出错", err)
+		}
+	}
+	utils.RevokeTokenString(token, utils.AccessToken)
+
+	// refresh token是可选的，前端没有保存时只吊销access token
+	var params models.RefreshParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err == nil && params.RefreshToken != "" {
+		claims, ok := utils.ParseToken(params.RefreshToken, utils.RefreshToken)
+		// 只能吊销自己的refresh token
+		if ok && claims["userName"] == identity.UserName {
+			utils.RevokeTokenString(params.RefreshToken, utils.RefreshToken)
+		}
+	}
+
+	resLogin.Meta = &models.ResMeta{"退出登录成功", 200}
+	this.Data["json"] = resLogin
+	this.ServeJSON()
+}
+
+// 使用管理员发送的重置令牌设置新密码 【接口：login/password/reset 请求方式：post】
+func (this *LoginController) ResetPassword() {
+	var resLogin models.ResLogin
+	var params models.ResetPasswordParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		logs.Info("json.Unmarshal is err:", err)
+	}
+
+	/*数据校验*/
+	valid := validation.Validation{}
+	b, err := valid.Valid(&params)
+	if err != nil {
+		resLogin.Meta = &models.ResMeta{"数据校验时发生内部错误", 500}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+	if !b {
+		msg := ""
+		for _, err := range valid.Errors {
+			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
+			break
+		}
+		resLogin.Meta = &models.ResMeta{msg, 400}
+		this.Data["json"] = resLogin
+		this.ServeJSON()
+		return
+	}
+
+	err = models.ResetPassword(params.Token, params.NewPassword)
+	if err != nil {
+		resLogin.Meta = &models.ResMeta{err.Error(), 400}
 		this.Data["json"] = resLogin
 		this.ServeJSON()
 		return
 	}
+	resLogin.Meta = &models.ResMeta{"重置密码成功，请使用新密码登录", 200}
+	this.Data["json"] = resLogin
+	this.ServeJSON()
 }
@@ -15,16 +15,6 @@ type OrdersController struct {
 func (this *OrdersController) GetOrdersList() {
 	var resOrdersList models.ResOrdersList
 
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 107)
-	if !hasRight {
-		logs.Error("权限不足")
-		resOrdersList.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resOrdersList
-		this.ServeJSON()
-		return
-	}
-
 	/* 获取数据 */
 	query := this.GetString("query")
 	pagenum, err := this.GetInt("pagenum")
@@ -58,8 +48,17 @@ func (this *OrdersController) GetOrdersList() {
 		return
 	}
 
-	// 验证完毕，执行查询操作
-	total, ordersList, err := models.GetOrdersList(query, pagenum, pagesize)
+	scope, err := models.CurrentDataScope(this.Ctx)
+	if err != nil {
+		logs.Error("查询数据范围出错", err)
+		resOrdersList.Meta = &models.ResMeta{"查询数据范围出错", 400}
+		this.Data["json"] = resOrdersList
+		this.ServeJSON()
+		return
+	}
+
+	// 验证完毕，执行查询操作，只返回数据范围内的订单
+	total, ordersList, err := models.GetOrdersList(query, pagenum, pagesize, scope)
 	if err != nil {
 		logs.Error("查询执行出错", err)
 		resOrdersList.Meta = &models.ResMeta{"查询执行出错", 400}
@@ -77,15 +76,6 @@ func (this *OrdersController) GetOrdersList() {
 // 修改订单地址 【接口：orders:id 请求方式：put】
 func (this *OrdersController) UpdateOrderAddr() {
 	var resUpdateAddr models.ResUpdateAddr
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 154)
-	if !hasRight {
-		resUpdateAddr.Meta = &models.ResMeta{"权限不足", 403}
-		logs.Error("权限不足")
-		this.Data["json"] = resUpdateAddr
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	id, err := this.GetInt(":order_id")
@@ -125,8 +115,24 @@ func (this *OrdersController) UpdateOrderAddr() {
 		return
 	}
 
-	// 执行数据库更新操作
-	resAddr, err := models.UpdateAddr(order, id)
+	scope, err := models.CurrentDataScope(this.Ctx)
+	if err != nil {
+		logs.Error("查询数据范围出错", err)
+		resUpdateAddr.Meta = &models.ResMeta{"查询数据范围出错", 400}
+		this.Data["json"] = resUpdateAddr
+		this.ServeJSON()
+		return
+	}
+
+	// 执行数据库更新操作，订单来源不在数据范围内时返回403
+	resAddr, err := models.UpdateAddr(order, id, scope)
+	if err == models.ErrOutOfScope {
+		resUpdateAddr.Meta = &models.ResMeta{err.Error(), 403}
+		logs.Error(err)
+		this.Data["json"] = resUpdateAddr
+		this.ServeJSON()
+		return
+	}
 	if err != nil {
 		resUpdateAddr.Meta = &models.ResMeta{"修改订单地址失败", 400}
 		logs.Error("修改订单地址失败", err)
@@ -13,15 +13,6 @@ type ReportController struct {
 // 获取数据报表 【接口：reports/type/1  请求方式：get】
 func (this ReportController) GetReport() {
 	var resReport models.ResReport
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 146)
-	if !hasRight {
-		resReport.Meta = &models.ResMeta{"权限不足", 403}
-		logs.Error("权限不足")
-		this.Data["json"] = resReport
-		this.ServeJSON()
-		return
-	}
 
 	resReportData, err := models.GetReport()
 	if err != nil {
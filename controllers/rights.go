@@ -8,8 +8,6 @@ import (
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/validation"
-	"strconv"
-	"strings"
 )
 
 type RightsController struct {
@@ -18,15 +16,6 @@ type RightsController struct {
 
 // 获取权限列表
 func (this *RightsController) GetRightsList() {
-	var validateRight models.ResRoles
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 112)
-	if !hasRight {
-		validateRight.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = validateRight
-		this.ServeJSON()
-		return
-	}
 	// 获取数据
 	GetRightsType := this.GetString(":type")
 
@@ -71,14 +60,6 @@ func (this *RightsController) GetRightsList() {
 // 获取角色列表
 func (this *RightsController) GetRolesList() {
 	var resRoles models.ResRoles
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 138)
-	if !hasRight {
-		resRoles.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resRoles
-		this.ServeJSON()
-		return
-	}
 	roleList, err := models.QueryRoleList()
 	if err != nil {
 		logs.Error(err)
@@ -96,14 +77,6 @@ func (this *RightsController) GetRolesList() {
 // 删除角色指定权限
 func (this *RightsController) DeleteRight() {
 	var resDelRight models.ResDelRight
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 135)
-	if !hasRight {
-		resDelRight.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resDelRight
-		this.ServeJSON()
-		return
-	}
 	// 获取数据
 	roleId, err := this.GetInt(":roleId")
 	if err != nil {
@@ -131,38 +104,36 @@ func (this *RightsController) DeleteRight() {
 		this.ServeJSON()
 		return
 	}
-	// 判断参数中的权限id是否存在于该角色的所有权限之中
-	isInRights := false
-	roleRights := strings.Split(spRole.PsIds, ",")
-	// 记录权限对应的索引，便于后续删除
-	var index int
-	for i, v := range roleRights {
-		if v == strconv.FormatInt(int64(rightId), 10) {
-			index = i
-			isInRights = true
-			break
-		}
+	// 取消该角色的权限
+	isInRights, err := models.RevokeRolePermission(roleId, rightId)
+	if err != nil {
+		resDelRight.Meta = &models.ResUsersMeta{"更新数据库执行出错", 400}
+		this.Data["json"] = resDelRight
+		this.ServeJSON()
+		return
 	}
 	// 没有在角色列表中找到该权限
 	if !isInRights {
+		inherited, _ := models.RoleInheritsPermission(roleId, rightId)
+		if inherited {
+			resDelRight.Meta = &models.ResUsersMeta{"该权限继承自父角色，请在父角色中删除", 400}
+			this.Data["json"] = resDelRight
+			this.ServeJSON()
+			return
+		}
 		resDelRight.Meta = &models.ResUsersMeta{"权限不存在", 400}
 		this.Data["json"] = resDelRight
 		this.ServeJSON()
 		return
 	}
-	// 权限存在，执行删除
-	roleRights = append(roleRights[:index], roleRights[index+1:]...)
-	// 更新数据库
-	spRole.PsIds = strings.Join(roleRights, ",")
-	_, err = o.Update(&spRole, "PsIds")
+	// 更新成功，返回该角色的权限列表
+	resRoleRights, err := models.GetRoleRights(roleId)
 	if err != nil {
-		resDelRight.Meta = &models.ResUsersMeta{"更新数据库执行出错", 400}
+		resDelRight.Meta = &models.ResUsersMeta{"查询角色权限出错", 400}
 		this.Data["json"] = resDelRight
 		this.ServeJSON()
 		return
 	}
-	// 更新成功，返回该角色的权限列表
-	resRoleRights, err := models.GetRoleRights(0, roleRights)
 	resDelRight.Data = resRoleRights
 	resDelRight.Meta = &models.ResUsersMeta{"删除权限成功", 200}
 	this.Data["json"] = resDelRight
@@ -172,14 +143,6 @@ func (this *RightsController) DeleteRight() {
 // 更新某个角色的权限
 func (this *RightsController) UpdateRoleRights() {
 	var resRoles models.ResRoles
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 141)
-	if !hasRight {
-		resRoles.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resRoles
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	// 获取角色id
@@ -253,19 +216,13 @@ func (this *RightsController) UpdateRoleRights() {
 		return
 	}
 
-	// 请求参数验证通过，更新数据库
-	var role models.SpRole
-	role.RoleId = roleId
-	err = o.Read(&role)
-	if err != nil {
-		resRoles.Meta = &models.ResUsersMeta{"查询角色出错", 400}
-		this.Data["json"] = resRoles
-		this.ServeJSON()
+	// 请求参数验证通过，开启审批时提交修改申请，否则直接更新数据库
+	psIds, _ := postRights.PsIds()
+	if models.ApprovalRequired(models.ActionRoleRights) {
+		serveChangeRequest(&this.Controller, models.ActionRoleRights, roleId, &models.RoleRightsChange{psIds})
 		return
 	}
-	/* 赋予根权限0 */
-	role.PsIds = "0," + postRights.Rids
-	_, err = o.Update(&role, "PsIds")
+	err = models.SetRolePermissions(roleId, psIds)
 	if err != nil {
 		resRoles.Meta = &models.ResUsersMeta{"更新字段值出错", 400}
 		this.Data["json"] = resRoles
@@ -282,14 +239,6 @@ func (this *RightsController) UpdateRoleRights() {
 // 修改角色信息
 func (this *RightsController) UpdateRoleInfo() {
 	var resRoleInfo models.ResRoleInfo
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 140)
-	if !hasRight {
-		resRoleInfo.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resRoleInfo
-		this.ServeJSON()
-		return
-	}
 
 	//获取数据
 	id, err := this.GetInt(":id")
@@ -325,18 +274,37 @@ func (this *RightsController) UpdateRoleInfo() {
 		this.ServeJSON()
 		return
 	}
+	// 修改父角色时校验继承关系不能形成环
+	oldPid := role.RolePid
+	if roleParams.RolePid != nil {
+		err = models.CheckRoleParent(id, *roleParams.RolePid)
+		if err != nil {
+			resRoleInfo.Meta = &models.ResMeta{err.Error(), 400}
+			this.Data["json"] = resRoleInfo
+			this.ServeJSON()
+			return
+		}
+		role.RolePid = *roleParams.RolePid
+	}
 	// 更新角色信息
 	role.RoleName = roleParams.RoleName
 	role.RoleDesc = roleParams.RoleDesc
-	_, err = o.Update(&role, "RoleName", "RoleDesc")
+	_, err = o.Update(&role, "RoleName", "RoleDesc", "RolePid")
 	if err != nil {
 		resRoleInfo.Meta = &models.ResMeta{"更新执行出错", 400}
 		this.Data["json"] = resRoleInfo
 		this.ServeJSON()
 		return
 	}
+	// 父角色变化后继承的权限随之变化，拥有该角色（及其子孙角色）的管理员需要刷新token
+	if role.RolePid != oldPid {
+		err = models.BumpRoleVersions(id)
+		if err != nil {
+			logs.Error("修改角色权限版本号出错", err)
+		}
+	}
 	// 返回成功信息
-	resRoleInfo.Data = &models.ResRoleInfoData{role.RoleId, role.RoleName, role.RoleDesc}
+	resRoleInfo.Data = &models.ResRoleInfoData{role.RoleId, role.RoleName, role.RoleDesc, role.RolePid}
 	resRoleInfo.Meta = &models.ResMeta{"更新角色信息成功", 200}
 	this.Data["json"] = resRoleInfo
 	this.ServeJSON()
@@ -345,14 +313,6 @@ func (this *RightsController) UpdateRoleInfo() {
 // 删除角色信息
 func (this *RightsController) DeleteRole() {
 	var resRoleInfo models.ResRoleInfo
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 130)
-	if !hasRight {
-		resRoleInfo.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resRoleInfo
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	id, err := this.GetInt(":id")
@@ -362,6 +322,21 @@ func (this *RightsController) DeleteRole() {
 		this.ServeJSON()
 		return
 	}
+	// 有子角色时不能删除，否则子角色会失去继承的权限
+	if models.RoleHasChildren(id) {
+		resRoleInfo.Meta = &models.ResMeta{"该角色下还有子角色，不能删除", 400}
+		this.Data["json"] = resRoleInfo
+		this.ServeJSON()
+		return
+	}
+	// 删除角色前先使拥有该角色的管理员的token失效
+	err = models.BumpRoleVersions(id)
+	if err != nil {
+		resRoleInfo.Meta = &models.ResMeta{"删除执行失败", 400}
+		this.Data["json"] = resRoleInfo
+		this.ServeJSON()
+		return
+	}
 	// 删除角色
 	role := models.SpRole{RoleId: id}
 	o := orm.NewOrm()
@@ -388,14 +363,6 @@ func (this *RightsController) DeleteRole() {
 // 添加角色
 func (this *RightsController) AddRole() {
 	var resRoleInfo models.ResRoleInfo
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 129)
-	if !hasRight {
-		resRoleInfo.Meta = &models.ResMeta{"权限不足", 403}
-		this.Data["json"] = resRoleInfo
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	var roleParams models.GetRoleParams
@@ -422,6 +389,16 @@ func (this *RightsController) AddRole() {
 		this.ServeJSON()
 		return
 	}
+	if roleParams.RolePid != nil {
+		err = models.CheckRoleParent(0, *roleParams.RolePid)
+		if err != nil {
+			resRoleInfo.Meta = &models.ResMeta{err.Error(), 400}
+			this.Data["json"] = resRoleInfo
+			this.ServeJSON()
+			return
+		}
+		role.RolePid = *roleParams.RolePid
+	}
 	// 添加角色到数据库
 	_, err = o.Insert(&role)
 	if err != nil {
@@ -431,8 +408,303 @@ func (this *RightsController) AddRole() {
 		return
 	}
 	// 返回成功信息
-	resRoleInfo.Data = &models.ResRoleInfoData{role.RoleId, role.RoleName, role.RoleDesc}
+	resRoleInfo.Data = &models.ResRoleInfoData{role.RoleId, role.RoleName, role.RoleDesc, role.RolePid}
 	resRoleInfo.Meta = &models.ResMeta{"添加角色成功", 200}
 	this.Data["json"] = resRoleInfo
 	this.ServeJSON()
 }
+
+// 设置角色是否必须启用两步验证 【接口：roles/:id/mfa 请求方式：put】
+func (this *RightsController) UpdateRoleMfa() {
+	var resRoleInfo models.ResRoleInfo
+
+	//获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resRoleInfo.Meta = &models.ResMeta{"角色id错误", 400}
+		this.Data["json"] = resRoleInfo
+		this.ServeJSON()
+		return
+	}
+	var params models.RoleMfaParams
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		resRoleInfo.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resRoleInfo
+		this.ServeJSON()
+		return
+	}
+
+	// 查询角色信息
+	role := models.SpRole{RoleId: id}
+	o := orm.NewOrm()
+	err = o.Read(&role, "RoleId")
+	if err != nil {
+		resRoleInfo.Meta = &models.ResMeta{"角色id不存在", 400}
+		this.Data["json"] = resRoleInfo
+		this.ServeJSON()
+		return
+	}
+	// 更新角色设置
+	role.MfaRequired = 0
+	if params.Required {
+		role.MfaRequired = 1
+	}
+	_, err = o.Update(&role, "MfaRequired")
+	if err != nil {
+		resRoleInfo.Meta = &models.ResMeta{"更新执行出错", 400}
+		this.Data["json"] = resRoleInfo
+		this.ServeJSON()
+		return
+	}
+	// 返回成功信息
+	resRoleInfo.Data = &models.ResRoleInfoData{role.RoleId, role.RoleName, role.RoleDesc, role.RolePid}
+	resRoleInfo.Meta = &models.ResMeta{"更新角色两步验证设置成功", 200}
+	this.Data["json"] = resRoleInfo
+	this.ServeJSON()
+}
+
+// 获取角色的有效权限，以及每个权限来自哪个角色 【接口：roles/:id/effective-rights 请求方式：get】
+func (this *RightsController) GetEffectiveRights() {
+	var resEffectiveRights models.ResEffectiveRights
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resEffectiveRights.Meta = &models.ResMeta{"角色id错误", 400}
+		this.Data["json"] = resEffectiveRights
+		this.ServeJSON()
+		return
+	}
+
+	resEffectiveRights.Data, err = models.GetEffectiveRights(id)
+	if err != nil {
+		logs.Error("查询角色有效权限出错", err)
+		resEffectiveRights.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resEffectiveRights
+		this.ServeJSON()
+		return
+	}
+	resEffectiveRights.Meta = &models.ResMeta{"获取角色有效权限成功", 200}
+	this.Data["json"] = resEffectiveRights
+	this.ServeJSON()
+}
+
+// 获取角色的数据范围 【接口：roles/:id/scope 请求方式：get】
+func (this *RightsController) GetRoleScope() {
+	var resRoleScope models.ResRoleScope
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resRoleScope.Meta = &models.ResMeta{"角色id错误", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+	o := orm.NewOrm()
+	if !o.QueryTable("sp_role").Filter("role_id", id).Exist() {
+		resRoleScope.Meta = &models.ResMeta{"角色id不存在", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+
+	resRoleScope.Data, err = models.GetRoleScope(id)
+	if err != nil {
+		logs.Error("查询角色数据范围出错", err)
+		resRoleScope.Meta = &models.ResMeta{"查询执行出错", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+	resRoleScope.Meta = &models.ResMeta{"获取角色数据范围成功", 200}
+	this.Data["json"] = resRoleScope
+	this.ServeJSON()
+}
+
+// 设置角色的数据范围，cat_one_ids为空表示不限制分类，order_sources为空表示不限制订单来源 【接口：roles/:id/scope 请求方式：put】
+func (this *RightsController) UpdateRoleScope() {
+	var resRoleScope models.ResRoleScope
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resRoleScope.Meta = &models.ResMeta{"角色id错误", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+	var params models.RoleScopeParams
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		resRoleScope.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+	o := orm.NewOrm()
+	if !o.QueryTable("sp_role").Filter("role_id", id).Exist() {
+		resRoleScope.Meta = &models.ResMeta{"角色id不存在", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+
+	// 更新数据范围
+	err = models.SetRoleScope(id, params.CatOneIds, params.OrderSources)
+	if err != nil {
+		resRoleScope.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+	resRoleScope.Data, err = models.GetRoleScope(id)
+	if err != nil {
+		logs.Error("查询角色数据范围出错", err)
+		resRoleScope.Meta = &models.ResMeta{"查询执行出错", 400}
+		this.Data["json"] = resRoleScope
+		this.ServeJSON()
+		return
+	}
+	resRoleScope.Meta = &models.ResMeta{"设置角色数据范围成功", 200}
+	this.Data["json"] = resRoleScope
+	this.ServeJSON()
+}
+
+// 解析并校验添加、修改权限时body中的参数，校验未通过时直接返回错误信息
+func (this *RightsController) permissionParams() (*models.PermissionParams, bool) {
+	var resPermission models.ResPermission
+	var params models.PermissionParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		resPermission.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return nil, false
+	}
+	valid := validation.Validation{}
+	b, err := valid.Valid(&params)
+	if err != nil {
+		resPermission.Meta = &models.ResMeta{"数据校验时发生内部错误", 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return nil, false
+	}
+	if !b {
+		msg := ""
+		// 出错就退出循环，不必再继续遍历
+		for _, err := range valid.Errors {
+			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
+			break
+		}
+		resPermission.Meta = &models.ResMeta{msg, 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return nil, false
+	}
+	return &params, true
+}
+
+// 添加权限（菜单或接口） 【接口：rights 请求方式：post】
+func (this *RightsController) AddPermission() {
+	var resPermission models.ResPermission
+	params, ok := this.permissionParams()
+	if !ok {
+		return
+	}
+	data, err := models.AddPermission(params)
+	if err != nil {
+		logs.Error("添加权限出错", err)
+		resPermission.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	resPermission.Data = data
+	resPermission.Meta = &models.ResMeta{"添加权限成功", 201}
+	this.Data["json"] = resPermission
+	this.ServeJSON()
+}
+
+// 修改权限 【接口：rights/:id 请求方式：put】
+func (this *RightsController) UpdatePermission() {
+	var resPermission models.ResPermission
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resPermission.Meta = &models.ResMeta{"权限id参数错误", 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	params, ok := this.permissionParams()
+	if !ok {
+		return
+	}
+	data, err := models.UpdatePermission(id, params)
+	if err != nil {
+		logs.Error("修改权限出错", err)
+		resPermission.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	resPermission.Data = data
+	resPermission.Meta = &models.ResMeta{"修改权限成功", 200}
+	this.Data["json"] = resPermission
+	this.ServeJSON()
+}
+
+// 删除权限，cascade=true时同时删除子权限并从角色中移除 【接口：rights/:id 请求方式：delete】
+func (this *RightsController) DeletePermission() {
+	var resPermission models.ResPermission
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resPermission.Meta = &models.ResMeta{"权限id参数错误", 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	cascade, err := this.GetBool("cascade", false)
+	if err != nil {
+		resPermission.Meta = &models.ResMeta{"cascade参数错误", 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	err = models.DeletePermission(id, cascade)
+	if err != nil {
+		logs.Error("删除权限出错", err)
+		resPermission.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	resPermission.Meta = &models.ResMeta{"删除权限成功", 200}
+	this.Data["json"] = resPermission
+	this.ServeJSON()
+}
+
+// 调整菜单顺序，body为[{"id":权限id,"order":顺序}] 【接口：rights/order 请求方式：put】
+func (this *RightsController) SortPermissions() {
+	var resPermission models.ResPermission
+	var orders []models.PermissionOrder
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &orders)
+	if err != nil || len(orders) == 0 {
+		resPermission.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	err = models.SortPermissions(orders)
+	if err != nil {
+		logs.Error("调整菜单顺序出错", err)
+		resPermission.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resPermission
+		this.ServeJSON()
+		return
+	}
+	resPermission.Meta = &models.ResMeta{"调整菜单顺序成功", 200}
+	this.Data["json"] = resPermission
+	this.ServeJSON()
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"JDStore/models"
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/validation"
+)
+
+type ServiceAccountsController struct {
+	beego.Controller
+}
+
+// 获取所有服务账号及其API Key（不含Key本身） 【接口：service-accounts 请求方式：get】
+func (this *ServiceAccountsController) GetServiceAccounts() {
+	var resAccounts models.ResServiceAccounts
+	var err error
+	resAccounts.Data, err = models.GetServiceAccounts()
+	if err != nil {
+		logs.Error("查询服务账号出错", err)
+		resAccounts.Meta = &models.ResMeta{"查询服务账号出错", 400}
+		this.Data["json"] = resAccounts
+		this.ServeJSON()
+		return
+	}
+	resAccounts.Meta = &models.ResMeta{"获取服务账号成功", 200}
+	this.Data["json"] = resAccounts
+	this.ServeJSON()
+}
+
+// 创建服务账号并分配角色 【接口：service-accounts 请求方式：post】
+func (this *ServiceAccountsController) AddServiceAccount() {
+	var resAccount models.ResServiceAccountInfo
+
+	// 获取数据
+	var params models.ServiceAccountParams
+	err := json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		resAccount.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+
+	// 校验请求参数
+	valid := validation.Validation{}
+	b, err := valid.Valid(&params)
+	if err != nil {
+		resAccount.Meta = &models.ResMeta{"数据校验时发生内部错误", 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+	if !b {
+		msg := ""
+		// 出错就退出循环，不必再继续遍历
+		for _, err := range valid.Errors {
+			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
+			break
+		}
+		resAccount.Meta = &models.ResMeta{msg, 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+
+	resAccount.Data, err = models.CreateServiceAccount(&params)
+	if err != nil {
+		logs.Error("创建服务账号出错", err)
+		resAccount.Meta = &models.ResMeta{"创建服务账号失败", 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+	resAccount.Meta = &models.ResMeta{"创建服务账号成功", 201}
+	this.Data["json"] = resAccount
+	this.ServeJSON()
+}
+
+// 删除服务账号，它的API Key随之失效 【接口：service-accounts/:id 请求方式：delete】
+func (this *ServiceAccountsController) DeleteServiceAccount() {
+	var resAccount models.ResServiceAccountInfo
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resAccount.Meta = &models.ResMeta{"服务账号id格式错误", 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+	resAccount.Data, err = models.GetServiceAccount(id)
+	if err != nil {
+		resAccount.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+	err = models.DeleteServiceAccount(id)
+	if err != nil {
+		logs.Error("删除服务账号出错", err)
+		resAccount.Meta = &models.ResMeta{"删除服务账号失败", 400}
+		this.Data["json"] = resAccount
+		this.ServeJSON()
+		return
+	}
+	resAccount.Meta = &models.ResMeta{"删除服务账号成功", 200}
+	this.Data["json"] = resAccount
+	this.ServeJSON()
+}
+
+// 为服务账号创建API Key，返回的key只显示这一次 【接口：service-accounts/:id/keys 请求方式：post】
+func (this *ServiceAccountsController) AddApiKey() {
+	var resKey models.ResApiKeyInfo
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resKey.Meta = &models.ResMeta{"服务账号id格式错误", 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+	var params models.ApiKeyParams
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		resKey.Meta = &models.ResMeta{"请求体中参数错误", 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+
+	// 校验请求参数
+	valid := validation.Validation{}
+	b, err := valid.Valid(&params)
+	if err != nil {
+		resKey.Meta = &models.ResMeta{"数据校验时发生内部错误", 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+	if !b {
+		msg := ""
+		// 出错就退出循环，不必再继续遍历
+		for _, err := range valid.Errors {
+			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
+			break
+		}
+		resKey.Meta = &models.ResMeta{msg, 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+
+	resKey.Data, err = models.CreateApiKey(id, &params)
+	if err != nil {
+		resKey.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+	resKey.Meta = &models.ResMeta{"创建API Key成功，请立即保存，之后无法再次查看", 201}
+	this.Data["json"] = resKey
+	this.ServeJSON()
+}
+
+// 吊销服务账号的API Key 【接口：service-accounts/:id/keys/:keyId 请求方式：delete】
+func (this *ServiceAccountsController) RevokeApiKey() {
+	var resKey models.ResApiKeyInfo
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resKey.Meta = &models.ResMeta{"服务账号id格式错误", 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+	keyId, err := this.GetInt(":keyId")
+	if err != nil {
+		resKey.Meta = &models.ResMeta{"API Key id格式错误", 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+
+	resKey.Data, err = models.RevokeApiKey(id, keyId)
+	if err != nil {
+		resKey.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resKey
+		this.ServeJSON()
+		return
+	}
+	resKey.Meta = &models.ResMeta{"吊销API Key成功", 200}
+	this.Data["json"] = resKey
+	this.ServeJSON()
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"JDStore/models"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+)
+
+type SessionsController struct {
+	beego.Controller
+}
+
+// 根据路由中的:id查询管理员，查询失败时返回错误信息
+func (this *SessionsController) manager() (*models.SpManager, *models.ResMeta) {
+	userId, err := this.GetInt(":id")
+	if err != nil {
+		return nil, &models.ResMeta{"管理员id格式错误", 400}
+	}
+	manager := models.SpManager{MgId: userId}
+	o := orm.NewOrm()
+	err = o.Read(&manager)
+	if err != nil {
+		return nil, &models.ResMeta{"管理员id不存在", 400}
+	}
+	return &manager, nil
+}
+
+// 获取管理员当前的登录会话（设备、ip、登录时间、最后访问时间） 【接口：users/:id/sessions 请求方式：get】
+func (this *SessionsController) GetUserSessions() {
+	var resSessionList models.ResSessionList
+
+	// 获取数据
+	manager, meta := this.manager()
+	if meta != nil {
+		resSessionList.Meta = meta
+		this.Data["json"] = resSessionList
+		this.ServeJSON()
+		return
+	}
+	// 标出发起本次请求的会话（使用API Key访问时没有会话）
+	var currentSid string
+	if identity := models.CurrentIdentity(this.Ctx); identity != nil {
+		currentSid = identity.Sid
+	}
+
+	var err error
+	resSessionList.Data, err = models.GetManagerSessions(manager.MgId, currentSid)
+	if err != nil {
+		logs.Error("查询登录会话出错", err)
+		resSessionList.Meta = &models.ResMeta{"查询登录会话出错", 400}
+		this.Data["json"] = resSessionList
+		this.ServeJSON()
+		return
+	}
+	resSessionList.Meta = &models.ResMeta{"获取登录会话成功", 200}
+	this.Data["json"] = resSessionList
+	this.ServeJSON()
+}
+
+// 分页获取管理员的登录记录（包括失败和被拒绝的尝试），按时间倒序 【接口：users/:id/logins 请求方式：get】
+func (this *SessionsController) GetUserLogins() {
+	var resLogins models.ResLoginAttempts
+
+	// 获取数据
+	manager, meta := this.manager()
+	if meta != nil {
+		resLogins.Meta = meta
+		this.Data["json"] = resLogins
+		this.ServeJSON()
+		return
+	}
+	pagenum, err := this.GetInt("pagenum")
+	if err != nil || pagenum <= 0 {
+		resLogins.Meta = &models.ResMeta{"pagenum为空或类型错误", 400}
+		this.Data["json"] = resLogins
+		this.ServeJSON()
+		return
+	}
+	pagesize, err := this.GetInt("pagesize")
+	if err != nil || pagesize <= 0 {
+		resLogins.Meta = &models.ResMeta{"pagesize为空或类型错误", 400}
+		this.Data["json"] = resLogins
+		this.ServeJSON()
+		return
+	}
+
+	resLogins.Data, err = models.GetManagerLoginAttempts(manager.MgId, pagenum, pagesize)
+	if err != nil {
+		logs.Error("查询登录记录出错", err)
+		resLogins.Meta = &models.ResMeta{"查询登录记录出错", 400}
+		this.Data["json"] = resLogins
+		this.ServeJSON()
+		return
+	}
+	resLogins.Meta = &models.ResMeta{"获取登录记录成功", 200}
+	this.Data["json"] = resLogins
+	this.ServeJSON()
+}
+
+// 远程退出管理员的一个登录会话，该会话的token随即失效 【接口：users/:id/sessions/:sid 请求方式：delete】
+func (this *SessionsController) RevokeUserSession() {
+	var resSessionInfo models.ResSessionInfo
+
+	// 获取数据
+	manager, meta := this.manager()
+	if meta != nil {
+		resSessionInfo.Meta = meta
+		this.Data["json"] = resSessionInfo
+		this.ServeJSON()
+		return
+	}
+	sid := this.GetString(":sid")
+
+	var err error
+	resSessionInfo.Data, err = models.RevokeSession(manager.MgId, sid)
+	if err != nil {
+		resSessionInfo.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resSessionInfo
+		this.ServeJSON()
+		return
+	}
+	resSessionInfo.Meta = &models.ResMeta{"退出登录会话成功", 200}
+	this.Data["json"] = resSessionInfo
+	this.ServeJSON()
+}
@@ -4,11 +4,14 @@ import (
 	"JDStore/models"
 	"JDStore/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/validation"
+	"io"
+	"io/ioutil"
 	"strings"
 	"time"
 )
@@ -20,17 +23,8 @@ type UsersController struct {
 // 获取用户列表
 func (this *UsersController) HandleGetUsers() {
 	var resUsers models.ResUsers
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 110)
-	if !hasRight {
-		resUsers.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resUsers
-		this.ServeJSON()
-		return
-	}
 
 	/* 获取数据 */
-	query := this.GetString("query")
 	pagenum, err := this.GetInt("pagenum")
 	if err != nil {
 		resUsers.Meta = models.ResUsersMeta{"pagenum 参数错误", 400}
@@ -77,19 +71,19 @@ func (this *UsersController) HandleGetUsers() {
 		}
 	}
 
-	// 查询管理员的总记录数
-	o := orm.NewOrm()
-	// total,err:=o.QueryTable("sp_manager").Count()
-	// 替换上面的查询方式，使用模糊匹配
-	total, err := o.QueryTable("sp_manager").Filter("mg_name__contains", query).Count()
-	if err != nil {
-		resUsers.Meta = models.ResUsersMeta{"查询总记录数出错", 400}
+	// 查询条件
+	search, msg := this.searchParams()
+	if msg != "" {
+		resUsers.Meta = models.ResUsersMeta{msg, 400}
 		this.Data["json"] = resUsers
 		this.ServeJSON()
 		return
 	}
-	// 查询管理员列表
-	managers, err := models.GetManagers(query, pagenum, pagesize)
+	search.Pagenum = pagenum
+	search.Pagesize = pagesize
+
+	// 查询管理员列表及总记录数
+	total, managers, err := models.SearchManagers(search)
 	if err != nil {
 		resUsers.Meta = models.ResUsersMeta{"查询管理员列表出错", 400}
 		this.Data["json"] = resUsers
@@ -102,17 +96,53 @@ func (this *UsersController) HandleGetUsers() {
 	this.ServeJSON()
 }
 
+// 获取查询管理员列表的条件（分页参数除外），参数错误时返回错误信息。
+// 用户名、邮箱、手机号为模糊匹配；rid为角色id（0表示超级管理员）；mg_state为true或false；
+// start_time、end_time为创建时间范围（unix时间戳）；sortby为排序字段，order为asc或desc
+func (this *UsersController) searchParams() (*models.ManagerSearchParams, string) {
+	search := models.ManagerSearchParams{Query: this.GetString("query"), Email: this.GetString("email"),
+		Mobile: this.GetString("mobile"), State: -1, SortBy: this.GetString("sortby")}
+	var err error
+	// deleted为true时查询已删除的管理员（用于恢复或彻底删除）
+	search.Deleted, _ = this.GetBool("deleted")
+	search.RoleId, err = this.GetInt("rid", -1)
+	if err != nil || search.RoleId < -1 {
+		return nil, "rid 参数错误"
+	}
+	if this.GetString("mg_state") != "" {
+		state, err := this.GetBool("mg_state")
+		if err != nil {
+			return nil, "mg_state 参数错误"
+		}
+		search.State = 0
+		if state {
+			search.State = 1
+		}
+	}
+	search.StartTime, err = this.GetInt("start_time", 0)
+	if err != nil {
+		return nil, "start_time 参数错误"
+	}
+	search.EndTime, err = this.GetInt("end_time", 0)
+	if err != nil {
+		return nil, "end_time 参数错误"
+	}
+	if search.SortBy != "" && !models.IsManagerSortColumn(search.SortBy) {
+		return nil, "sortby 参数错误"
+	}
+	switch this.GetString("order") {
+	case "", "asc":
+	case "desc":
+		search.Desc = true
+	default:
+		return nil, "order 参数错误"
+	}
+	return &search, ""
+}
+
 // 修改用户的状态
 func (this *UsersController) PutUserState() {
 	var resUserState models.ResUserState
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 159)
-	if !hasRight {
-		resUserState.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resUserState
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	uId, err := this.GetInt(":uId")
@@ -121,6 +151,7 @@ func (this *UsersController) PutUserState() {
 		resUserState.Meta = &models.ResUsersMeta{"uId参数错误", 400}
 		this.Data["json"] = resUserState
 		this.ServeJSON()
+		return
 	}
 
 	state, err := this.GetBool(":type")
@@ -129,18 +160,18 @@ func (this *UsersController) PutUserState() {
 		resUserState.Meta = &models.ResUsersMeta{"type参数错误", 400}
 		this.Data["json"] = resUserState
 		this.ServeJSON()
+		return
 	}
 
 	// 这里无需做进一步数据校验，因为参数为空时，服务器直接报404错误
 
-	o := orm.NewOrm()
-	// 查询mg_id为uId的用户
-	user := models.SpManager{MgId: uId}
-	err = o.Read(&user)
+	// 查询mg_id为uId的用户，已删除的管理员需要先恢复
+	user, err := models.ReadUndeletedManager(uId)
 	if err != nil {
-		resUserState.Meta = &models.ResUsersMeta{"管理员ID不存在", 400}
+		resUserState.Meta = &models.ResUsersMeta{managerReadMsg(err, "管理员ID不存在"), 400}
 		this.Data["json"] = resUserState
 		this.ServeJSON()
+		return
 	}
 
 	// 修改字段值
@@ -150,12 +181,20 @@ func (this *UsersController) PutUserState() {
 		user.MgState = 0
 	}
 
-	// 执行更新操作
-	_, err = o.Update(&user, "mg_state")
-	if err != nil {
+	// 执行更新操作，只修改仍未删除的管理员
+	o := orm.NewOrm()
+	num, err := o.QueryTable("sp_manager").Filter("mg_id", uId).Filter("deleted_at", 0).
+		Update(orm.Params{"mg_state": user.MgState})
+	if err != nil || num == 0 {
 		resUserState.Meta = &models.ResUsersMeta{"修改执行出错", 400}
 		this.Data["json"] = resUserState
 		this.ServeJSON()
+		return
+	}
+
+	// 禁用管理员时，吊销该管理员已签发的所有token
+	if user.MgState == 0 {
+		models.RevokeManagerTokens(user.MgId, user.MgName)
 	}
 
 	// 返回成功信息
@@ -171,14 +210,6 @@ func (this *UsersController) PutUserState() {
 // 添加新的用户
 func (this *UsersController) AddUser() {
 	var resAddUser models.ResAddUser
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 131)
-	if !hasRight {
-		resAddUser.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resAddUser
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	var user models.CreateUser
@@ -229,9 +260,10 @@ func (this *UsersController) AddUser() {
 		return
 	}
 	// 用户名不存在，可以向数据库增加一条记录
+	// 新建的管理员默认启用，禁用的管理员不能登录
 	manager = models.SpManager{
 		MgName: user.UserName, MgPwd: utils.HashAndSalt(user.PassWord),
-		MgTime: int(time.Now().Unix()), MgMobile: user.Mobile, MgEmail: user.Email}
+		MgTime: int(time.Now().Unix()), MgMobile: user.Mobile, MgEmail: user.Email, MgState: 1}
 	_, err = o.Insert(&manager)
 	if err != nil {
 		resAddUser.Meta = &models.ResUsersMeta{"添加执行出错", 400}
@@ -252,14 +284,6 @@ func (this *UsersController) AddUser() {
 // 根据id获取该用户信息
 func (this *UsersController) GetUserInfo() {
 	var resGetUser models.ResGetUser
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 136)
-	if !hasRight {
-		resGetUser.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resGetUser
-		this.ServeJSON()
-		return
-	}
 
 	// 获取数据
 	id, err := this.GetInt(":id")
@@ -271,12 +295,10 @@ func (this *UsersController) GetUserInfo() {
 	}
 
 	// 查询数据
-	user := models.SpManager{MgId: id}
-	o := orm.NewOrm()
-	err = o.Read(&user, "MgId")
+	user, err := models.ReadUndeletedManager(id)
 	if err != nil {
-		// 没有查到该用户，返回错误信息
-		resGetUser.Meta = &models.ResUsersMeta{"用户不存在", 400}
+		// 没有查到该用户或已删除，返回错误信息
+		resGetUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户不存在"), 400}
 		this.Data["json"] = resGetUser
 		this.ServeJSON()
 		return
@@ -294,14 +316,6 @@ func (this *UsersController) GetUserInfo() {
 // 修改用户信息
 func (this *UsersController) UpdateUserInfo() {
 	var resGetUser models.ResGetUser
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 133)
-	if !hasRight {
-		resGetUser.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resGetUser
-		this.ServeJSON()
-		return
-	}
 	// 获取数据
 	// 获取用户id
 	id, err := this.GetInt(":id")
@@ -347,25 +361,24 @@ func (this *UsersController) UpdateUserInfo() {
 		return
 	}
 
-	// 校验通过，修改用户信息
-	manager := models.SpManager{MgId: id, MgEmail: updateUser.Email, MgMobile: updateUser.Mobile}
-	o := orm.NewOrm()
-	_, err = o.Update(&manager, "MgEmail", "MgMobile")
+	// 校验通过，修改用户信息，已删除的管理员需要先恢复
+	manager, err := models.ReadUndeletedManager(id)
 	if err != nil {
-		resGetUser.Meta = &models.ResUsersMeta{"修改用户信息失败", 400}
+		resGetUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户不存在"), 400}
 		this.Data["json"] = resGetUser
 		this.ServeJSON()
 		return
 	}
-
-	// 返回成功信息
-	err = o.Read(&manager, "MgId")
-	if err != nil {
-		resGetUser.Meta = &models.ResUsersMeta{"查询用户信息失败", 400}
+	o := orm.NewOrm()
+	num, err := o.QueryTable("sp_manager").Filter("mg_id", id).Filter("deleted_at", 0).
+		Update(orm.Params{"mg_email": updateUser.Email, "mg_mobile": updateUser.Mobile})
+	if err != nil || num == 0 {
+		resGetUser.Meta = &models.ResUsersMeta{"修改用户信息失败", 400}
 		this.Data["json"] = resGetUser
 		this.ServeJSON()
 		return
 	}
+	manager.MgEmail, manager.MgMobile = updateUser.Email, updateUser.Mobile
 
 	resGetUser.Data = &models.ResUserData{
 		manager.MgId, manager.RoleId, manager.MgName,
@@ -378,14 +391,6 @@ func (this *UsersController) UpdateUserInfo() {
 // 删除用户
 func (this *UsersController) DeleteUser() {
 	var resGetUser models.ResGetUser
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 132)
-	if !hasRight {
-		resGetUser.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resGetUser
-		this.ServeJSON()
-		return
-	}
 	// 获取数据
 	// 获取用户id
 	id, err := this.GetInt(":id")
@@ -397,56 +402,104 @@ func (this *UsersController) DeleteUser() {
 	}
 
 	// 删除用户
-	manager := models.SpManager{MgId: id}
-	o := orm.NewOrm()
-	num, err := o.Delete(&manager, "MgId")
+	// 先查出用户名，删除后用于吊销该用户的token
+	manager, err := models.ReadUndeletedManager(id)
 	if err != nil {
-		resGetUser.Meta = &models.ResUsersMeta{"删除执行错误", 400}
+		resGetUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户id不存在"), 400}
 		this.Data["json"] = resGetUser
 		this.ServeJSON()
 		return
 	}
-	if num == 0 {
-		resGetUser.Meta = &models.ResUsersMeta{"用户id不存在", 400}
+	// 开启审批时提交删除申请，批准后才删除
+	if models.ApprovalRequired(models.ActionManagerDelete) {
+		serveChangeRequest(&this.Controller, models.ActionManagerDelete, id, &models.ManagerDeleteChange{manager.MgName})
+		return
+	}
+	// 只标记删除时间，保留期内可以恢复
+	err = models.DeleteManager(id)
+	if err != nil {
+		resGetUser.Meta = &models.ResUsersMeta{err.Error(), 400}
 		this.Data["json"] = resGetUser
 		this.ServeJSON()
 		return
 	}
 
-	// 删除成功
+	// 删除成功，吊销该用户已签发的所有token
+	models.RevokeManagerTokens(manager.MgId, manager.MgName)
 	resGetUser.Meta = &models.ResUsersMeta{"删除成功", 200}
 	this.Data["json"] = resGetUser
 	this.ServeJSON()
 }
 
-// 分配用户角色
-func (this *UsersController) UpdateRole() {
-	var resUser models.ResGetUser
-	// 权限验证
-	hasRight := models.ValidateRight(this.Ctx, 134)
-	if !hasRight {
-		resUser.Meta = &models.ResUsersMeta{"权限不足", 403}
-		this.Data["json"] = resUser
+// 恢复已删除的管理员 【接口：users/:id/restore 请求方式：post】
+func (this *UsersController) RestoreUser() {
+	var resGetUser models.ResGetUser
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resGetUser.Meta = &models.ResUsersMeta{"请检查id参数", 400}
+		this.Data["json"] = resGetUser
+		this.ServeJSON()
+		return
+	}
+
+	manager, err := models.RestoreManager(id)
+	if err != nil {
+		resGetUser.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resGetUser
 		this.ServeJSON()
 		return
 	}
+	resGetUser.Data = &models.ResUserData{
+		manager.MgId, manager.RoleId, manager.MgName,
+		manager.MgMobile, manager.MgEmail}
+	resGetUser.Meta = &models.ResUsersMeta{"恢复成功", 200}
+	this.Data["json"] = resGetUser
+	this.ServeJSON()
+}
+
+// 彻底删除已删除超过保留期的管理员 【接口：users/:id/purge 请求方式：delete】
+func (this *UsersController) PurgeUser() {
+	var resGetUser models.ResGetUser
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resGetUser.Meta = &models.ResUsersMeta{"请检查id参数", 400}
+		this.Data["json"] = resGetUser
+		this.ServeJSON()
+		return
+	}
+
+	err = models.PurgeManager(id)
+	if err != nil {
+		resGetUser.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resGetUser
+		this.ServeJSON()
+		return
+	}
+	resGetUser.Meta = &models.ResUsersMeta{"彻底删除成功", 200}
+	this.Data["json"] = resGetUser
+	this.ServeJSON()
+}
+
+// 为管理员添加一个角色 【接口：users/:id/roles 请求方式：post】
+func (this *UsersController) AddUserRole() {
+	var resUserRoles models.ResUserRoles
 
 	// 获取数据
 	userId, err := this.GetInt(":id")
 	if err != nil {
-		resUser.Meta = &models.ResUsersMeta{"管理员id格式错误", 400}
-		this.Data["json"] = resUser
+		resUserRoles.Meta = &models.ResUsersMeta{"管理员id格式错误", 400}
+		this.Data["json"] = resUserRoles
 		this.ServeJSON()
 		return
 	}
 
-	// 检验用户id是否存在于数据库
-	manager := models.SpManager{MgId: userId}
-	o := orm.NewOrm()
-	err = o.Read(&manager)
+	// 检验用户id是否存在于数据库，已删除的管理员需要先恢复
+	manager, err := models.ReadUndeletedManager(userId)
 	if err != nil {
-		resUser.Meta = &models.ResUsersMeta{"管理员id不存在", 400}
-		this.Data["json"] = resUser
+		resUserRoles.Meta = &models.ResUsersMeta{managerReadMsg(err, "管理员id不存在"), 400}
+		this.Data["json"] = resUserRoles
 		this.ServeJSON()
 		return
 	}
@@ -455,8 +508,8 @@ func (this *UsersController) UpdateRole() {
 	var roleId models.RoleId
 	err = json.Unmarshal(this.Ctx.Input.RequestBody, &roleId)
 	if err != nil {
-		resUser.Meta = &models.ResUsersMeta{"请求体中不包含角色id或角色id不是整数", 400}
-		this.Data["json"] = resUser
+		resUserRoles.Meta = &models.ResUsersMeta{"请求体中不包含角色id或角色id不是整数", 400}
+		this.Data["json"] = resUserRoles
 		this.ServeJSON()
 		return
 	}
@@ -466,19 +519,250 @@ func (this *UsersController) UpdateRole() {
 	b, err := valid.Valid(&roleId)
 	if err != nil {
 		// handle error
+		resUserRoles.Meta = &models.ResUsersMeta{"数据校验时发生内部错误", 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+	if !b {
+		msg := ""
+		// 出错就退出循环，不必再继续遍历
+		for _, err := range valid.Errors {
+			// err.Field是出错字段，err.Name是校验方法，err.Key是出错字段+校验方法，err.Message是错误信息
+			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
+			break
+		}
+		resUserRoles.Meta = &models.ResUsersMeta{msg, 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+
+	// 校验成功，添加角色
+	err = models.AddManagerRole(manager, roleId.Rid)
+	if err != nil {
+		resUserRoles.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+	this.serveUserRoles(manager, "添加角色成功")
+}
+
+// 移除管理员的一个角色 【接口：users/:id/roles/:roleId 请求方式：delete】
+func (this *UsersController) RemoveUserRole() {
+	var resUserRoles models.ResUserRoles
+
+	// 获取数据
+	userId, err := this.GetInt(":id")
+	if err != nil {
+		resUserRoles.Meta = &models.ResUsersMeta{"管理员id格式错误", 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+	roleId, err := this.GetInt(":roleId")
+	if err != nil {
+		resUserRoles.Meta = &models.ResUsersMeta{"角色id格式错误", 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+
+	// 检验用户id是否存在于数据库，已删除的管理员需要先恢复
+	manager, err := models.ReadUndeletedManager(userId)
+	if err != nil {
+		resUserRoles.Meta = &models.ResUsersMeta{managerReadMsg(err, "管理员id不存在"), 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+
+	err = models.RemoveManagerRole(manager, roleId)
+	if err != nil {
+		resUserRoles.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+	this.serveUserRoles(manager, "移除角色成功")
+}
+
+// 返回管理员当前拥有的所有角色
+func (this *UsersController) serveUserRoles(manager *models.SpManager, msg string) {
+	var resUserRoles models.ResUserRoles
+	roles, err := models.GetManagerRoles(manager.MgId)
+	if err != nil {
+		logs.Error("查询管理员角色出错", err)
+		resUserRoles.Meta = &models.ResUsersMeta{"查询管理员角色出错", 400}
+		this.Data["json"] = resUserRoles
+		this.ServeJSON()
+		return
+	}
+	resUserRoles.Data = &models.ResUserRolesData{manager.MgId, manager.MgName, roles}
+	resUserRoles.Meta = &models.ResUsersMeta{msg, 200}
+	this.Data["json"] = resUserRoles
+	this.ServeJSON()
+}
+
+// 解除用户的登录锁定 【接口：users/:id/unlock 请求方式：put】
+func (this *UsersController) UnlockUser() {
+	var resUser models.ResGetUser
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{"请检查id参数", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+	manager, err := models.ReadUndeletedManager(id)
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户不存在"), 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	// 清除该用户的登录失败次数
+	err = models.UnlockManager(manager.MgName)
+	if err != nil {
+		logs.Error("解除登录锁定出错", err)
+		resUser.Meta = &models.ResUsersMeta{"解除锁定执行出错", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	resUser.Data = &models.ResUserData{manager.MgId, manager.RoleId,
+		manager.MgName, manager.MgMobile, manager.MgEmail}
+	resUser.Meta = &models.ResUsersMeta{"解除锁定成功", 200}
+	this.Data["json"] = resUser
+	this.ServeJSON()
+}
+
+// 当前登录的管理员开始绑定两步验证，返回密钥和二维码地址 【接口：mfa 请求方式：post】
+func (this *UsersController) StartMfa() {
+	var resMfa models.ResMfa
+	manager, err := models.CurrentManager(this.Ctx)
+	if err != nil {
+		resMfa.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	resMfa.Data, err = models.StartMfaEnroll(manager)
+	if err != nil {
+		resMfa.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	resMfa.Meta = &models.ResMeta{"请使用验证器扫描二维码，并输入动态验证码完成绑定", 200}
+	this.Data["json"] = resMfa
+	this.ServeJSON()
+}
+
+// 当前登录的管理员输入动态验证码完成绑定，返回恢复码 【接口：mfa 请求方式：put】
+func (this *UsersController) ConfirmMfa() {
+	var resMfa models.ResMfa
+	manager, err := models.CurrentManager(this.Ctx)
+	if err != nil {
+		resMfa.Meta = &models.ResMeta{"无效token", 401}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	var params models.MfaParams
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil || params.Code == "" {
+		resMfa.Meta = &models.ResMeta{"验证码不能为空", 400}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	recoveryCodes, err := models.ConfirmMfaEnroll(manager.MgId, params.Code)
+	if err != nil {
+		resMfa.Meta = &models.ResMeta{err.Error(), 400}
+		this.Data["json"] = resMfa
+		this.ServeJSON()
+		return
+	}
+	resMfa.Data = &models.ResMfaData{RecoveryCodes: recoveryCodes}
+	resMfa.Meta = &models.ResMeta{"两步验证已启用，请妥善保存恢复码", 200}
+	this.Data["json"] = resMfa
+	this.ServeJSON()
+}
+
+// 重置管理员的两步验证 【接口：users/:id/mfa 请求方式：delete】
+func (this *UsersController) ResetMfa() {
+	var resUser models.ResGetUser
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{"请检查id参数", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+	manager, err := models.ReadUndeletedManager(id)
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户不存在"), 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	err = models.ResetMfa(manager.MgId)
+	if err != nil {
+		logs.Error("重置两步验证出错", err)
+		resUser.Meta = &models.ResUsersMeta{"重置两步验证执行出错", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	resUser.Data = &models.ResUserData{manager.MgId, manager.RoleId,
+		manager.MgName, manager.MgMobile, manager.MgEmail}
+	resUser.Meta = &models.ResUsersMeta{"重置两步验证成功", 200}
+	this.Data["json"] = resUser
+	this.ServeJSON()
+}
+
+// 修改管理员的密码 【接口：users/:id/password 请求方式：put】
+// 修改自己的密码时必须填写旧密码；修改其他管理员的密码需要拥有该接口的权限
+func (this *UsersController) UpdatePassword() {
+	var resUser models.ResGetUser
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{"请检查id参数", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+	var params models.PasswordParams
+	err = json.Unmarshal(this.Ctx.Input.RequestBody, &params)
+	if err != nil {
+		logs.Info("json.Unmarshal is err:", err)
+	}
+
+	// 数据校验
+	valid := validation.Validation{}
+	b, err := valid.Valid(&params)
+	if err != nil {
 		resUser.Meta = &models.ResUsersMeta{"数据校验时发生内部错误", 400}
 		this.Data["json"] = resUser
 		this.ServeJSON()
 		return
 	}
 	if !b {
-		// validation does not pass
-		// blabla...
 		msg := ""
 		// 出错就退出循环，不必再继续遍历
 		for _, err := range valid.Errors {
-			//logs.Error(err.Field, err.Name, err.Key, err.Message)
-			// err.Field是出错字段，err.Name是校验方法，err.Key是出错字段+校验方法，err.Message是错误信息
 			msg = fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)
 			break
 		}
@@ -488,20 +772,262 @@ func (this *UsersController) UpdateRole() {
 		return
 	}
 
-	// 校验成功，更新用户的角色
-	manager.RoleId = int8(roleId.Rid)
-	_, err = o.Update(&manager, "RoleId")
+	current, err := models.CurrentManager(this.Ctx)
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{"无效token", 401}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+	manager, err := models.ReadUndeletedManager(id)
 	if err != nil {
-		resUser.Meta = &models.ResUsersMeta{"更新执行错误", 400}
+		resUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户不存在"), 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+	if manager.MgId == current.MgId && !utils.ComparePasswords(manager.MgPwd, []byte(params.OldPassword)) {
+		resUser.Meta = &models.ResUsersMeta{"旧密码错误", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	// 修改成功后，该管理员已签发的token全部失效，需要重新登录
+	err = models.SetPassword(manager, params.NewPassword)
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	resUser.Data = &models.ResUserData{manager.MgId, manager.RoleId,
+		manager.MgName, manager.MgMobile, manager.MgEmail}
+	resUser.Meta = &models.ResUsersMeta{"修改密码成功，请重新登录", 200}
+	this.Data["json"] = resUser
+	this.ServeJSON()
+}
+
+// 为管理员生成重置密码的令牌，并通过配置的通知方式发送给该管理员 【接口：users/:id/password/reset 请求方式：post】
+func (this *UsersController) SendPasswordReset() {
+	var resUser models.ResGetUser
+
+	// 获取数据
+	id, err := this.GetInt(":id")
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{"请检查id参数", 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+	manager, err := models.ReadUndeletedManager(id)
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{managerReadMsg(err, "用户不存在"), 400}
+		this.Data["json"] = resUser
+		this.ServeJSON()
+		return
+	}
+
+	err = notifyPasswordReset(manager, "")
+	if err != nil {
+		resUser.Meta = &models.ResUsersMeta{err.Error(), 400}
 		this.Data["json"] = resUser
 		this.ServeJSON()
 		return
 	}
 
-	// 返回成功信息
 	resUser.Data = &models.ResUserData{manager.MgId, manager.RoleId,
 		manager.MgName, manager.MgMobile, manager.MgEmail}
-	resUser.Meta = &models.ResUsersMeta{"设置角色成功", 200}
+	resUser.Meta = &models.ResUsersMeta{"重置密码通知已发送", 200}
 	this.Data["json"] = resUser
 	this.ServeJSON()
 }
+
+// 查询管理员出错时返回的提示信息，管理员已删除时提示先恢复，其他错误使用notFoundMsg
+func managerReadMsg(err error, notFoundMsg string) string {
+	if err == models.ErrManagerDeleted {
+		return "管理员已被删除，请先恢复"
+	}
+	return notFoundMsg
+}
+
+// 生成重置密码令牌并通知管理员，prefix为通知内容的开头。令牌只通过通知发送，不在响应中返回
+func notifyPasswordReset(manager *models.SpManager, prefix string) error {
+	token, err := models.CreatePasswordReset(manager)
+	if err != nil {
+		logs.Error("生成重置密码令牌出错", err)
+		return errors.New("生成重置密码令牌出错")
+	}
+	to := manager.MgEmail
+	if to == "" {
+		to = manager.MgName
+	}
+	content := prefix + fmt.Sprintf("您的重置密码令牌为：%s，%d分钟内有效，只能使用一次",
+		token, beego.AppConfig.DefaultInt("PwdResetExpire", 30))
+	err = utils.Notify(to, "重置密码", content)
+	if err != nil {
+		logs.Error("发送重置密码通知出错", err)
+		return errors.New("发送重置密码通知出错")
+	}
+	return nil
+}
+
+// 从csv或xlsx文件批量导入管理员，dry_run为true时只校验不导入。
+// 所有行都校验通过才导入，导入的管理员通过重置密码通知设置自己的密码
+func (this *UsersController) ImportUsers() {
+	var resImport models.ResImportUsers
+
+	// 获取数据
+	dryRun, _ := this.GetBool("dry_run")
+	file, head, err := this.GetFile("file")
+	if err != nil {
+		resImport.Meta = &models.ResUsersMeta{"请上传文件", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	defer file.Close()
+	maxSize := models.ManagerImportMaxSize()
+	if head.Size > maxSize {
+		resImport.Meta = &models.ResUsersMeta{"文件太大，请重新上传", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxSize))
+	if err != nil {
+		logs.Error("读取文件时出错", err)
+		resImport.Meta = &models.ResUsersMeta{"读取文件时出错", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	format, err := utils.TableFormat(head.Filename, data)
+	if err != nil {
+		resImport.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	// 加上表头的一行
+	table, err := utils.ReadTable(data, format, models.ManagerImportMaxRows()+1)
+	if err == utils.ErrTableTooLong {
+		resImport.Meta = &models.ResUsersMeta{fmt.Sprintf("最多只能导入%d个管理员", models.ManagerImportMaxRows()), 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	if err != nil {
+		resImport.Meta = &models.ResUsersMeta{err.Error(), 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+
+	// 数据校验
+	pwd, err := models.RandomInitialPassword()
+	if err != nil {
+		resImport.Meta = &models.ResUsersMeta{"生成初始密码出错", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	rows, errs, err := models.ParseManagerImport(table, pwd)
+	if err != nil {
+		logs.Error("校验导入数据出错", err)
+		resImport.Meta = &models.ResUsersMeta{"数据校验时发生内部错误", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	resImport.Data = &models.ResImportUsersData{len(rows) + len(errs), dryRun, errs, rows}
+	if len(errs) > 0 {
+		// 有错误时不导入任何一行
+		resImport.Meta = &models.ResUsersMeta{"导入数据有误，未导入任何管理员", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	if len(rows) == 0 {
+		resImport.Meta = &models.ResUsersMeta{"文件中没有管理员数据", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	if dryRun {
+		resImport.Meta = &models.ResUsersMeta{"校验通过", 200}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+
+	err = models.ImportManagers(rows, pwd)
+	if err != nil {
+		logs.Error("导入管理员出错", err)
+		resImport.Meta = &models.ResUsersMeta{"导入管理员出错", 400}
+		this.Data["json"] = resImport
+		this.ServeJSON()
+		return
+	}
+	// 通知管理员设置密码，发送失败的管理员可以通过users/:id/password/reset重新发送
+	for _, row := range rows {
+		manager := models.SpManager{MgId: row.Id, MgName: row.UserName, MgEmail: row.Email}
+		err = notifyPasswordReset(&manager, fmt.Sprintf("您的管理员账号%s已创建，请使用重置密码令牌设置密码。", row.UserName))
+		row.Notified = err == nil
+	}
+
+	resImport.Meta = &models.ResUsersMeta{"导入成功", 201}
+	this.Data["json"] = resImport
+	this.ServeJSON()
+}
+
+// 导出管理员列表，查询条件和排序与获取管理员列表相同，format为csv（默认）或xlsx。
+// 导出的文件可以直接导入（按role_ids列确定角色），超级管理员的行不能导入
+func (this *UsersController) ExportUsers() {
+	var resUsers models.ResUsers
+
+	// 获取数据
+	format := this.GetString("format", utils.TableCSV)
+	if format != utils.TableCSV && format != utils.TableXLSX {
+		resUsers.Meta = models.ResUsersMeta{"format 参数错误", 400}
+		this.Data["json"] = resUsers
+		this.ServeJSON()
+		return
+	}
+	search, msg := this.searchParams()
+	if msg != "" {
+		resUsers.Meta = models.ResUsersMeta{msg, 400}
+		this.Data["json"] = resUsers
+		this.ServeJSON()
+		return
+	}
+
+	// 分页查询并逐行写入响应，不需要把所有管理员保存在内存中
+	output := this.Ctx.Output
+	output.Header("Content-Type", utils.TableContentType(format))
+	output.Header("Content-Disposition",
+		fmt.Sprintf("attachment; filename=users_%s.%s", time.Now().Format("20060102150405"), format))
+	writer, err := utils.NewTableWriter(this.Ctx.ResponseWriter, format)
+	if err == nil {
+		err = writer.WriteRow(models.ManagerExportColumns)
+	}
+	search.Pagesize = 500
+	for search.Pagenum = 1; err == nil; search.Pagenum++ {
+		var managers []models.ResUser
+		_, managers, err = models.SearchManagers(search)
+		for i := 0; err == nil && i < len(managers); i++ {
+			err = writer.WriteRow(models.ManagerExportRow(&managers[i]))
+		}
+		if len(managers) < search.Pagesize {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	// 响应已经开始发送，出错时只能记录日志
+	if err != nil {
+		logs.Error("导出管理员出错", err)
+	}
+}
@@ -0,0 +1,55 @@
+-- 接口权限改为由路由拦截器统一校验：
+-- sp_permission_api.ps_api_action 保存请求方式（get/post/put/delete），
+-- sp_permission_api.ps_api_path   保存去掉基准URL之后的路由（与routers/router.go中的写法一致，如 users/:id）。
+-- 没有配置的接口只有超级管理员可以访问。
+
+-- 原先写死在控制器中的权限id
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'users'                              WHERE ps_id = 110;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'users'                              WHERE ps_id = 131;
+UPDATE sp_permission_api SET ps_api_action = 'put',    ps_api_path = 'users/:uId/state/:type'             WHERE ps_id = 159;
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'users/:id'                          WHERE ps_id = 136;
+UPDATE sp_permission_api SET ps_api_action = 'put',    ps_api_path = 'users/:id'                          WHERE ps_id = 133;
+UPDATE sp_permission_api SET ps_api_action = 'delete', ps_api_path = 'users/:id'                          WHERE ps_id = 132;
+UPDATE sp_permission_api SET ps_api_action = 'put',    ps_api_path = 'users/:id/role'                     WHERE ps_id = 134;
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'roles'                              WHERE ps_id = 138;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'roles'                              WHERE ps_id = 129;
+UPDATE sp_permission_api SET ps_api_action = 'put',    ps_api_path = 'roles/:id'                          WHERE ps_id = 140;
+UPDATE sp_permission_api SET ps_api_action = 'delete', ps_api_path = 'roles/:id'                          WHERE ps_id = 130;
+UPDATE sp_permission_api SET ps_api_action = 'delete', ps_api_path = 'roles/:roleId/rights/:rightId'      WHERE ps_id = 135;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'roles/:roleId/rights'               WHERE ps_id = 141;
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'categories'                         WHERE ps_id = 149;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'categories'                         WHERE ps_id = 122;
+UPDATE sp_permission_api SET ps_api_action = 'delete', ps_api_path = 'categories/:id'                     WHERE ps_id = 123;
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'categories/:id/attributes'          WHERE ps_id = 142;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'categories/:id/attributes'          WHERE ps_id = 156;
+UPDATE sp_permission_api SET ps_api_action = 'delete', ps_api_path = 'categories/:id/attributes/:attrId'  WHERE ps_id = 157;
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'goods'                              WHERE ps_id = 153;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'goods'                              WHERE ps_id = 105;
+UPDATE sp_permission_api SET ps_api_action = 'put',    ps_api_path = 'goods/:id'                          WHERE ps_id = 116;
+UPDATE sp_permission_api SET ps_api_action = 'delete', ps_api_path = 'goods/:id'                          WHERE ps_id = 117;
+UPDATE sp_permission_api SET ps_api_action = 'post',   ps_api_path = 'upload'                             WHERE ps_id = 150;
+UPDATE sp_permission_api SET ps_api_action = 'get',    ps_api_path = 'orders'                             WHERE ps_id = 107;
+UPDATE sp_permission_api SET ps_api_action = 'put',    ps_api_path = 'orders/:order_id'                   WHERE ps_id = 154;
+
+-- 112（权限列表）和146（数据报表）是二级菜单，ps_api_path需要保留菜单路径，
+-- 因此为它们对应的接口各新建一个三级权限，并授予已拥有该菜单的角色
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('获取权限列表', 112, 'rights', 'list', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'get', 'rights/:type');
+UPDATE sp_role SET ps_ids = CONCAT(ps_ids, ',', @ps_id) WHERE FIND_IN_SET('112', ps_ids);
+
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看数据报表', 146, 'reports', 'get', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'get', 'reports/type/1');
+UPDATE sp_role SET ps_ids = CONCAT(ps_ids, ',', @ps_id) WHERE FIND_IN_SET('146', ps_ids);
+
+-- 修改分类名称、修改分类参数原先没有任何权限校验，这里补上，并授予已拥有相应菜单的角色
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('修改分类', 121, 'categories', 'update', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'put', 'categories/:id');
+UPDATE sp_role SET ps_ids = CONCAT(ps_ids, ',', @ps_id) WHERE FIND_IN_SET('121', ps_ids);
+
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('修改参数', 115, 'attributes', 'update', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'put', 'categories/:id/attributes/:attrId');
+UPDATE sp_role SET ps_ids = CONCAT(ps_ids, ',', @ps_id) WHERE FIND_IN_SET('115', ps_ids);
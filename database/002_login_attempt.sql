@@ -0,0 +1,16 @@
+-- 登录尝试记录，用于登录失败锁定以及安全审查
+CREATE TABLE IF NOT EXISTS sp_login_attempt (
+  id           INT(11)     NOT NULL AUTO_INCREMENT,
+  mg_name      VARCHAR(32) NOT NULL DEFAULT '' COMMENT '登录时填写的用户名（可能不存在）',
+  ip           VARCHAR(64) NOT NULL DEFAULT '' COMMENT '客户端IP',
+  result       VARCHAR(16) NOT NULL DEFAULT '' COMMENT 'success、failure、blocked',
+  cleared      TINYINT(1)  NOT NULL DEFAULT 0 COMMENT '1:登录成功或管理员解锁后，之前的失败记录不再计入锁定次数',
+  attempt_time INT(11)     NOT NULL DEFAULT 0 COMMENT '尝试时间',
+  PRIMARY KEY (id),
+  KEY idx_mg_name_time (mg_name, attempt_time),
+  KEY idx_ip_time (ip, attempt_time)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '登录尝试记录';
+
+-- 解除用户登录锁定的接口权限，默认只有超级管理员拥有，需要时再分配给其他角色
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('解除登录锁定', 110, 'user', 'unlock', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'users/:id/unlock');
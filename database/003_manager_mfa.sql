@@ -0,0 +1,19 @@
+-- 管理员两步验证（TOTP）
+CREATE TABLE IF NOT EXISTS sp_manager_mfa (
+  mg_id          INT(11)       NOT NULL COMMENT '管理员id',
+  secret         VARCHAR(64)   NOT NULL DEFAULT '' COMMENT 'TOTP密钥',
+  enabled        TINYINT(1)    NOT NULL DEFAULT 0 COMMENT '1:已启用 0:已生成密钥但尚未验证',
+  recovery_codes VARCHAR(1024) NULL COMMENT '恢复码的hash，逗号分隔',
+  last_step      BIGINT(20)    NOT NULL DEFAULT 0 COMMENT '上一次验证通过的时间步长',
+  update_time    INT(11)       NOT NULL DEFAULT 0 COMMENT '更新时间',
+  PRIMARY KEY (mg_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '管理员两步验证';
+
+-- 角色是否必须启用两步验证
+ALTER TABLE sp_role ADD COLUMN mfa_required TINYINT(1) NULL DEFAULT 0 COMMENT '1:必须启用两步验证';
+
+-- 重置管理员两步验证、设置角色两步验证的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('重置两步验证', 110, 'user', 'resetMfa', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'users/:id/mfa');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('设置角色两步验证', 111, 'role', 'mfa', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'roles/:id/mfa');
@@ -0,0 +1,29 @@
+-- 管理员用过的密码，修改密码时不能与最近几次相同
+CREATE TABLE IF NOT EXISTS sp_password_history (
+  id          INT(11)  NOT NULL AUTO_INCREMENT,
+  mg_id       INT(11)  NOT NULL COMMENT '管理员id',
+  mg_pwd      CHAR(64) NOT NULL DEFAULT '' COMMENT '用过的密码hash',
+  create_time INT(11)  NOT NULL DEFAULT 0 COMMENT '修改时间',
+  PRIMARY KEY (id),
+  KEY idx_mg_id (mg_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '管理员密码历史';
+
+-- 重置密码令牌，只保存令牌的sha256值
+CREATE TABLE IF NOT EXISTS sp_password_reset (
+  id          INT(11)     NOT NULL AUTO_INCREMENT,
+  mg_id       INT(11)     NOT NULL COMMENT '管理员id',
+  token_hash  VARCHAR(64) NOT NULL DEFAULT '' COMMENT '令牌的sha256值',
+  expire_time INT(11)     NOT NULL DEFAULT 0 COMMENT '过期时间',
+  used        TINYINT(1)  NOT NULL DEFAULT 0 COMMENT '1:已使用或已作废',
+  create_time INT(11)     NOT NULL DEFAULT 0 COMMENT '创建时间',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_token_hash (token_hash),
+  KEY idx_mg_id (mg_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '重置密码令牌';
+
+-- 修改其他管理员密码、发送重置密码令牌的接口权限，默认只有超级管理员拥有。
+-- 修改自己的密码不需要权限
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('修改管理员密码', 110, 'user', 'password', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'users/:id/password');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('重置管理员密码', 110, 'user', 'resetPassword', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'post', 'users/:id/password/reset');
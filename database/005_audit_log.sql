@@ -0,0 +1,23 @@
+-- 审计日志，记录管理员的每一次修改操作（post、put、delete请求）
+CREATE TABLE IF NOT EXISTS sp_audit_log (
+  id          INT(11)      NOT NULL AUTO_INCREMENT,
+  mg_id       INT(11)      NOT NULL DEFAULT 0 COMMENT '操作人id',
+  mg_name     VARCHAR(32)  NOT NULL DEFAULT '' COMMENT '操作人',
+  method      VARCHAR(8)   NOT NULL DEFAULT '' COMMENT '请求方式',
+  route       VARCHAR(255) NOT NULL DEFAULT '' COMMENT '请求路径（不含基准URL）',
+  entity_type VARCHAR(32)  NOT NULL DEFAULT '' COMMENT '操作对象的类型',
+  entity_id   VARCHAR(32)  NOT NULL DEFAULT '' COMMENT '操作对象的id',
+  before_data TEXT         NULL COMMENT '操作前的数据',
+  after_data  TEXT         NULL COMMENT '操作后的数据',
+  status      INT(11)      NOT NULL DEFAULT 0 COMMENT '响应中meta的status',
+  ip          VARCHAR(64)  NOT NULL DEFAULT '' COMMENT '客户端IP',
+  create_time INT(11)      NOT NULL DEFAULT 0 COMMENT '操作时间',
+  PRIMARY KEY (id),
+  KEY idx_mg_name (mg_name),
+  KEY idx_entity (entity_type, entity_id),
+  KEY idx_create_time (create_time)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '审计日志';
+
+-- 查看审计日志的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看审计日志', 112, 'audit', 'list', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'audit-logs');
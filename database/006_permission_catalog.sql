@@ -0,0 +1,9 @@
+-- 维护权限、菜单的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('添加权限', 112, 'rights', 'add', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'post', 'rights');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('修改权限', 112, 'rights', 'update', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'rights/:id');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('删除权限', 112, 'rights', 'delete', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'rights/:id');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('调整菜单顺序', 112, 'rights', 'order', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'rights/order');
@@ -0,0 +1,19 @@
+-- 角色的权限改为保存在关系表sp_role_permission中，不再使用sp_role.ps_ids（逗号分隔的字符串）
+CREATE TABLE IF NOT EXISTS sp_role_permission (
+  id      INT(11)     NOT NULL AUTO_INCREMENT,
+  role_id SMALLINT(6) UNSIGNED NOT NULL COMMENT '角色id',
+  ps_id   SMALLINT(6) UNSIGNED NOT NULL COMMENT '权限id',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_role_ps (role_id, ps_id),
+  KEY idx_ps_id (ps_id),
+  CONSTRAINT fk_role_permission_role FOREIGN KEY (role_id) REFERENCES sp_role (role_id) ON DELETE CASCADE,
+  CONSTRAINT fk_role_permission_ps FOREIGN KEY (ps_id) REFERENCES sp_permission (ps_id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '角色权限';
+
+-- 一次性转换已有的ps_ids。根权限0以及已不存在的权限id会被忽略
+INSERT IGNORE INTO sp_role_permission (role_id, ps_id)
+SELECT r.role_id, p.ps_id
+FROM sp_role r JOIN sp_permission p ON FIND_IN_SET(p.ps_id, r.ps_ids);
+
+-- 保留旧字段以便回滚，新增角色时不再写入
+ALTER TABLE sp_role MODIFY ps_ids VARCHAR(512) NULL DEFAULT NULL COMMENT '已废弃，角色权限见sp_role_permission';
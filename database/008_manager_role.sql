@@ -0,0 +1,26 @@
+-- 管理员可以拥有多个角色，保存在关系表sp_manager_role中，权限为所有角色权限的并集。
+-- sp_manager.role_id为0仍表示超级管理员，其他情况下只作为主角色保留
+CREATE TABLE IF NOT EXISTS sp_manager_role (
+  id      INT(11)     NOT NULL AUTO_INCREMENT,
+  mg_id   INT(11)     NOT NULL COMMENT '管理员id',
+  role_id SMALLINT(6) UNSIGNED NOT NULL COMMENT '角色id',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_mg_role (mg_id, role_id),
+  KEY idx_role_id (role_id),
+  CONSTRAINT fk_manager_role_mg FOREIGN KEY (mg_id) REFERENCES sp_manager (mg_id) ON DELETE CASCADE,
+  CONSTRAINT fk_manager_role_role FOREIGN KEY (role_id) REFERENCES sp_role (role_id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '管理员角色';
+
+-- 一次性转换已有的role_id，已不存在的角色会被忽略
+INSERT IGNORE INTO sp_manager_role (mg_id, role_id)
+SELECT m.mg_id, r.role_id
+FROM sp_manager m JOIN sp_role r ON m.role_id = r.role_id
+WHERE m.role_id > 0;
+
+-- 原来的“分配角色”接口改为添加角色，并新增移除角色接口，授予原来拥有分配角色权限的角色
+UPDATE sp_permission_api SET ps_api_action = 'post', ps_api_path = 'users/:id/roles' WHERE ps_id = 134;
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('移除角色', 110, 'users', 'removeRole', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'delete', 'users/:id/roles/:roleId');
+INSERT INTO sp_role_permission (role_id, ps_id)
+SELECT role_id, @ps_id FROM sp_role_permission WHERE ps_id = 134;
@@ -0,0 +1,17 @@
+-- 角色的数据范围规则。scope_type为cat_one时scope_value是允许访问的一级分类id（商品的cat_one_id），
+-- 角色没有某一类型的规则时该类型的数据不受限制；管理员的数据范围是所有角色数据范围的并集
+CREATE TABLE IF NOT EXISTS sp_role_scope (
+  id          INT(11)     NOT NULL AUTO_INCREMENT,
+  role_id     SMALLINT(6) UNSIGNED NOT NULL COMMENT '角色id',
+  scope_type  VARCHAR(32) NOT NULL COMMENT '规则类型',
+  scope_value INT(11)     NOT NULL COMMENT '允许访问的值',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_role_scope (role_id, scope_type, scope_value),
+  CONSTRAINT fk_role_scope_role FOREIGN KEY (role_id) REFERENCES sp_role (role_id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '角色数据范围';
+
+-- 查看、设置角色数据范围的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看角色数据范围', 111, 'role', 'scope', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'roles/:id/scope');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('设置角色数据范围', 111, 'role', 'setScope', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'roles/:id/scope');
@@ -0,0 +1,10 @@
+-- 角色继承：角色拥有父角色（以及所有祖先角色）的权限，role_pid为0表示没有父角色
+ALTER TABLE sp_role ADD COLUMN role_pid SMALLINT(6) UNSIGNED NOT NULL DEFAULT 0 COMMENT '父角色id';
+ALTER TABLE sp_role ADD KEY idx_role_pid (role_pid);
+
+-- 查看角色有效权限的接口权限，授予已拥有角色列表菜单的角色
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看角色有效权限', 111, 'role', 'effectiveRights', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'get', 'roles/:id/effective-rights');
+INSERT INTO sp_role_permission (role_id, ps_id)
+SELECT role_id, @ps_id FROM sp_role_permission WHERE ps_id = 111;
@@ -0,0 +1,26 @@
+-- 需要审批的修改申请（maker-checker），配置文件ApprovalActions中的操作提交后保存在这里，批准后才执行
+CREATE TABLE IF NOT EXISTS sp_change_request (
+  id             INT(11)      NOT NULL AUTO_INCREMENT,
+  action         VARCHAR(32)  NOT NULL COMMENT '操作类型',
+  entity_id      INT(11)      NOT NULL COMMENT '操作对象的id',
+  payload        TEXT         NOT NULL COMMENT '修改内容（json）',
+  status         VARCHAR(16)  NOT NULL DEFAULT 'pending' COMMENT 'pending:待审批 approved:已批准 rejected:已拒绝',
+  requester_id   INT(11)      NOT NULL COMMENT '申请人id',
+  requester_name VARCHAR(32)  NOT NULL COMMENT '申请人',
+  approver_id    INT(11)      NOT NULL DEFAULT 0 COMMENT '审批人id',
+  approver_name  VARCHAR(32)  NOT NULL DEFAULT '' COMMENT '审批人',
+  comment        VARCHAR(255) NULL COMMENT '审批意见',
+  create_time    INT(11)      NOT NULL COMMENT '申请时间',
+  decide_time    INT(11)      NOT NULL DEFAULT 0 COMMENT '审批时间',
+  PRIMARY KEY (id),
+  KEY idx_status (status),
+  KEY idx_action_entity (action, entity_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '修改申请';
+
+-- 查看、批准、拒绝修改申请的接口权限（审批权限），默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看修改申请', 112, 'approval', 'list', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'change-requests');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('批准修改申请', 112, 'approval', 'approve', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'change-requests/:id/approve');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('拒绝修改申请', 112, 'approval', 'reject', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'put', 'change-requests/:id/reject');
@@ -0,0 +1,26 @@
+-- 临时授予管理员的角色或权限，到期后失效。定时任务把到期的授权标记为expired，记录保留用于查询授权历史
+CREATE TABLE IF NOT EXISTS sp_manager_grant (
+  id          INT(11)     NOT NULL AUTO_INCREMENT,
+  mg_id       INT(11)     NOT NULL COMMENT '管理员id',
+  grant_type  VARCHAR(16) NOT NULL COMMENT 'role:角色 permission:权限',
+  target_id   INT(11)     NOT NULL COMMENT '角色id或权限id',
+  status      VARCHAR(16) NOT NULL DEFAULT 'active' COMMENT 'active:生效中 expired:已过期 revoked:已撤销',
+  granted_by  VARCHAR(32) NOT NULL DEFAULT '' COMMENT '授权人',
+  grant_time  INT(11)     NOT NULL COMMENT '授权时间',
+  expire_time INT(11)     NOT NULL COMMENT '到期时间',
+  end_time    INT(11)     NOT NULL DEFAULT 0 COMMENT '实际失效（过期或撤销）的时间',
+  PRIMARY KEY (id),
+  KEY idx_mg_status (mg_id, status),
+  KEY idx_status_expire (status, expire_time),
+  CONSTRAINT fk_manager_grant_mg FOREIGN KEY (mg_id) REFERENCES sp_manager (mg_id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '管理员临时授权';
+
+-- 管理临时授权的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看临时授权', 110, 'user', 'grants', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'users/:id/grants');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('添加临时授权', 110, 'user', 'addGrant', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'post', 'users/:id/grants');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('撤销临时授权', 110, 'user', 'revokeGrant', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'users/:id/grants/:grantId');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看即将到期的临时授权', 110, 'user', 'expiringGrants', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'grants/expiring');
@@ -0,0 +1,40 @@
+-- 服务账号：供仓库、ERP等系统调用接口的账号，保存在sp_manager中，不能登录，只能使用API Key访问
+ALTER TABLE sp_manager ADD COLUMN mg_type TINYINT(2) NOT NULL DEFAULT 0 COMMENT '0:管理员 1:服务账号';
+
+-- 服务账号的API Key，只保存前缀和完整Key的sha256值
+CREATE TABLE IF NOT EXISTS sp_api_key (
+  id             INT(11)     NOT NULL AUTO_INCREMENT,
+  mg_id          INT(11)     NOT NULL COMMENT '服务账号id',
+  name           VARCHAR(32) NOT NULL COMMENT '名称',
+  prefix         VARCHAR(16) NOT NULL COMMENT '前缀，用于查找和识别Key',
+  key_hash       CHAR(64)    NOT NULL COMMENT '完整Key的sha256值',
+  create_time    INT(11)     NOT NULL COMMENT '创建时间',
+  last_used_time INT(11)     NOT NULL DEFAULT 0 COMMENT '最后使用时间',
+  revoke_time    INT(11)     NOT NULL DEFAULT 0 COMMENT '吊销时间，0表示未吊销',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_prefix (prefix),
+  KEY idx_mg_id (mg_id),
+  CONSTRAINT fk_api_key_mg FOREIGN KEY (mg_id) REFERENCES sp_manager (mg_id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '服务账号API Key';
+
+-- API Key限定的权限范围，没有记录表示可以使用服务账号的所有权限
+CREATE TABLE IF NOT EXISTS sp_api_key_permission (
+  id     INT(11) NOT NULL AUTO_INCREMENT,
+  key_id INT(11) NOT NULL COMMENT 'API Key id',
+  ps_id  INT(11) NOT NULL COMMENT '权限id',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_key_ps (key_id, ps_id),
+  CONSTRAINT fk_api_key_permission_key FOREIGN KEY (key_id) REFERENCES sp_api_key (id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = 'API Key权限范围';
+
+-- 管理服务账号的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看服务账号', 110, 'user', 'serviceAccounts', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'service-accounts');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('创建服务账号', 110, 'user', 'addServiceAccount', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'post', 'service-accounts');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('删除服务账号', 110, 'user', 'deleteServiceAccount', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'service-accounts/:id');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('创建API Key', 110, 'user', 'addApiKey', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'post', 'service-accounts/:id/keys');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('吊销API Key', 110, 'user', 'revokeApiKey', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'service-accounts/:id/keys/:keyId');
@@ -0,0 +1,22 @@
+-- 管理员的登录会话，每次登录生成一条，刷新token时延续同一个会话
+CREATE TABLE IF NOT EXISTS sp_manager_session (
+  id             INT(11)      NOT NULL AUTO_INCREMENT,
+  sid            VARCHAR(32)  NOT NULL COMMENT '会话id，保存在token的sid中',
+  mg_id          INT(11)      NOT NULL COMMENT '管理员id',
+  user_agent     VARCHAR(255) NOT NULL DEFAULT '' COMMENT '登录设备（User-Agent）',
+  ip             VARCHAR(64)  NOT NULL DEFAULT '' COMMENT '登录ip',
+  issue_time     INT(11)      NOT NULL COMMENT '登录时间',
+  last_seen_time INT(11)      NOT NULL COMMENT '最后访问时间',
+  expire_time    INT(11)      NOT NULL COMMENT '到期时间，每次刷新token时延长',
+  revoke_time    INT(11)      NOT NULL DEFAULT 0 COMMENT '退出时间，0表示未退出',
+  PRIMARY KEY (id),
+  UNIQUE KEY uk_sid (sid),
+  KEY idx_mg_id (mg_id),
+  CONSTRAINT fk_manager_session_mg FOREIGN KEY (mg_id) REFERENCES sp_manager (mg_id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8 COMMENT = '管理员登录会话';
+
+-- 查看、退出其他管理员会话的接口权限，默认只有超级管理员拥有（管理员查看、退出自己的会话不需要权限）
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看登录会话', 110, 'user', 'sessions', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'users/:id/sessions');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('退出登录会话', 110, 'user', 'revokeSession', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'users/:id/sessions/:sid');
@@ -0,0 +1,5 @@
+-- 管理员账号的来源：local为本地账号，ldap、oidc为外部身份提供方首次登录时自动创建的账号
+ALTER TABLE sp_manager
+  ADD COLUMN mg_source  VARCHAR(16)  NOT NULL DEFAULT 'local' COMMENT '账号来源：local、ldap、oidc',
+  ADD COLUMN mg_subject VARCHAR(255) NULL COMMENT '外部身份提供方中的用户标识（LDAP的DN、OIDC的sub），本地账号为NULL',
+  ADD UNIQUE KEY uk_mg_source_subject (mg_source, mg_subject);
@@ -0,0 +1,5 @@
+-- 权限版本号：access token中保存签发时管理员及其角色的版本号之和，版本号变化后token失效，需要刷新
+ALTER TABLE sp_role
+  ADD COLUMN perm_version INT(11) NOT NULL DEFAULT 0 COMMENT '权限版本号，角色的权限或父角色变化时加1';
+ALTER TABLE sp_manager
+  ADD COLUMN perm_version INT(11) NOT NULL DEFAULT 0 COMMENT '权限版本号，管理员的角色或临时授权变化时加1';
@@ -0,0 +1,14 @@
+-- 删除管理员改为软删除：只记录删除时间，保留期内可以恢复，超过保留期后才能彻底删除
+ALTER TABLE sp_manager
+  ADD COLUMN deleted_at INT(11) NOT NULL DEFAULT 0 COMMENT '删除时间，0表示未删除',
+  ADD KEY idx_deleted_at (deleted_at);
+
+-- 禁用的管理员（mg_state = 0）不再能够登录。之前mg_state不影响登录，而且users接口新建的管理员mg_state为0（未设置），
+-- 无法区分哪些是有意禁用的。为了不影响已有管理员登录，已有的管理员全部启用，需要禁用的管理员在上线后重新禁用
+UPDATE sp_manager SET mg_state = 1 WHERE mg_state = 0;
+
+-- 恢复、彻底删除管理员的接口权限，默认只有超级管理员拥有
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('恢复管理员', 110, 'user', 'restore', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'post', 'users/:id/restore');
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('彻底删除管理员', 110, 'user', 'purge', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'delete', 'users/:id/purge');
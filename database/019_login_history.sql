@@ -0,0 +1,26 @@
+-- 登录记录增加管理员id和User-Agent，用于按管理员查看登录历史
+ALTER TABLE sp_login_attempt
+  ADD COLUMN mg_id INT(11) NOT NULL DEFAULT 0 COMMENT '用户名对应的管理员id，用户名不存在时为0' AFTER id,
+  ADD COLUMN user_agent VARCHAR(255) NOT NULL DEFAULT '' COMMENT '登录设备（User-Agent）' AFTER ip,
+  ADD KEY idx_mg_id (mg_id);
+
+-- 已有的登录记录按用户名补上管理员id
+UPDATE sp_login_attempt AS t1 INNER JOIN sp_manager AS t2 ON t1.mg_name = t2.mg_name SET t1.mg_id = t2.mg_id;
+
+-- 管理员的最后登录时间、IP，以及定时任务标记的长期未登录时间
+ALTER TABLE sp_manager
+  ADD COLUMN last_login_at INT(11) NOT NULL DEFAULT 0 COMMENT '最后登录时间，0表示从未登录',
+  ADD COLUMN last_login_ip VARCHAR(64) NOT NULL DEFAULT '' COMMENT '最后登录IP',
+  ADD COLUMN inactive_at INT(11) NOT NULL DEFAULT 0 COMMENT '标记为长期未登录的时间，0表示未标记';
+
+-- 按已有的登录成功记录补上最后登录时间和IP
+UPDATE sp_manager AS t1 INNER JOIN (
+  SELECT a.mg_id, a.attempt_time, a.ip FROM sp_login_attempt AS a INNER JOIN (
+    SELECT mg_id, MAX(id) AS id FROM sp_login_attempt WHERE result = 'success' AND mg_id > 0 GROUP BY mg_id
+  ) AS b ON a.id = b.id
+) AS t2 ON t1.mg_id = t2.mg_id
+SET t1.last_login_at = t2.attempt_time, t1.last_login_ip = t2.ip;
+
+-- 查看登录记录的接口权限，默认只有超级管理员拥有（管理员查看自己的登录记录不需要权限）
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('查看登录记录', 110, 'user', 'logins', '2');
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (LAST_INSERT_ID(), 'get', 'users/:id/logins');
@@ -0,0 +1,7 @@
+-- 获取商品详情的接口权限，授予已拥有获取商品列表权限（ps_id 153）的角色
+INSERT INTO sp_permission (ps_name, ps_pid, ps_c, ps_a, ps_level) VALUES ('获取商品详情', 104, 'goods', 'get', '2');
+SET @ps_id = LAST_INSERT_ID();
+INSERT INTO sp_permission_api (ps_id, ps_api_action, ps_api_path) VALUES (@ps_id, 'get', 'goods/:id');
+INSERT IGNORE INTO sp_role_permission (role_id, ps_id) SELECT role_id, @ps_id FROM sp_role_permission WHERE ps_id = 153;
+-- 这些角色的权限发生了变化，已签发的token需要刷新
+UPDATE sp_role SET perm_version = perm_version + 1 WHERE role_id IN (SELECT role_id FROM sp_role_permission WHERE ps_id = @ps_id);
@@ -0,0 +1,6 @@
+-- 同一对象的同一操作只能有一个待审批的修改申请。pending_entity_id只在待审批时等于entity_id，
+-- 审批后为NULL（唯一索引不限制NULL），由数据库保证并发提交时只有一个申请能保存成功。
+-- 执行前需确认没有同一对象的多个待审批申请，否则先拒绝多余的申请
+ALTER TABLE sp_change_request
+  ADD COLUMN pending_entity_id INT(11) AS (IF(status = 'pending', entity_id, NULL)) STORED COMMENT '待审批申请的对象id',
+  ADD UNIQUE KEY uk_action_pending (action, pending_entity_id);
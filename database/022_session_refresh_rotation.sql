@@ -0,0 +1,4 @@
+-- 登录会话记录当前有效的refresh token的jti，每次刷新token时轮换。
+-- 已经换取过新token的refresh token再次使用时（可能已泄露）退出整个会话
+ALTER TABLE sp_manager_session
+  ADD COLUMN refresh_jti VARCHAR(32) NOT NULL DEFAULT '' COMMENT '当前有效的refresh token的jti，每次刷新时轮换' AFTER revoke_time;
@@ -0,0 +1,5 @@
+-- 订单来源，用于按来源限制角色的数据范围（sp_role_scope中scope_type为order_source的规则）。
+-- 来源的取值由业务方约定（例如1:PC商城 2:小程序），已有订单的来源为0
+ALTER TABLE sp_order
+  ADD COLUMN order_source INT(11) NOT NULL DEFAULT 0 COMMENT '订单来源' AFTER pay_status,
+  ADD KEY idx_order_source (order_source);
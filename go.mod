@@ -6,6 +6,8 @@ require (
 	github.com/astaxie/beego v1.12.2
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/disintegration/imaging v1.6.2
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.3.0
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/rs/xid v1.2.1
 	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"JDStore/models"
 	_ "JDStore/routers"
 	"JDStore/utils"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/toolbox"
 	_ "github.com/go-sql-driver/mysql"
+	"os"
 )
 
 func init() {
@@ -32,8 +35,22 @@ func init() {
 }
 
 func main() {
+	// 带参数运行时执行命令行工具，见command.go
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// 重新定义表单校验的错误信息
 	utils.ErrorMessage()
 
+	// 定时把已到期的临时授权标记为已过期（spec格式：秒 分 时 日 月 周）
+	sweepSpec := beego.AppConfig.DefaultString("GrantSweepSpec", "0 */5 * * * *")
+	toolbox.AddTask("grantSweeper", toolbox.NewTask("grantSweeper", sweepSpec, models.SweepExpiredGrants))
+	// 定时标记（并按配置禁用）长期未登录的管理员
+	inactiveSpec := beego.AppConfig.DefaultString("ManagerInactiveSpec", "0 0 3 * * *")
+	toolbox.AddTask("inactiveManagers", toolbox.NewTask("inactiveManagers", inactiveSpec, models.SweepInactiveManagers))
+	toolbox.StartTask()
+	defer toolbox.StopTask()
+
 	beego.Run()
 }
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"github.com/go-sql-driver/mysql"
+	"time"
+)
+
+// 数据库中sp_change_request表的模型，保存需要审批的修改申请（maker-checker）。
+// 开启审批的操作不直接修改数据，而是生成修改申请，由另一名有审批权限的管理员批准后才执行
+type SpChangeRequest struct {
+	Id            int    `orm:"pk;auto"`
+	Action        string `orm:"size(32)" description:"操作类型"`
+	EntityId      int    `description:"操作对象的id"`
+	Payload       string `orm:"type(text)" description:"修改内容（json）"`
+	Status        string `orm:"size(16)" description:"pending:待审批 approved:已批准 rejected:已拒绝"`
+	RequesterId   int    `description:"申请人id"`
+	RequesterName string `orm:"size(32)" description:"申请人"`
+	ApproverId    int    `description:"审批人id"`
+	ApproverName  string `orm:"size(32)" description:"审批人"`
+	Comment       string `orm:"size(255);null" description:"审批意见"`
+	CreateTime    int    `description:"申请时间"`
+	DecideTime    int    `description:"审批时间"`
+}
+
+// 需要审批的操作类型，在配置文件的ApprovalActions中开启（多个用分号分隔）
+const (
+	ActionGoodsPrice    = "goods.price"
+	ActionRoleRights    = "role.rights"
+	ActionManagerDelete = "manager.delete"
+)
+
+// 修改申请的状态
+const (
+	ChangePending  = "pending"
+	ChangeApproved = "approved"
+	ChangeRejected = "rejected"
+)
+
+var (
+	// 审批自己提交的修改申请时返回的错误
+	ErrSelfApproval = errors.New("不能审批自己提交的修改申请")
+	// 同一对象的同一操作已有待审批的申请
+	ErrChangePending = errors.New("该对象已有待审批的修改申请")
+)
+
+/* 修改商品价格的申请内容（同时包含一起修改的名称、重量） */
+type GoodInfoChange struct {
+	GoodsName   string  `json:"goods_name"`
+	GoodsPrice  float64 `json:"goods_price"`
+	GoodsWeight float64 `json:"goods_weight"`
+	OldPrice    float64 `json:"old_price"`
+}
+
+/* 修改角色权限的申请内容 */
+type RoleRightsChange struct {
+	PsIds []int `json:"ps_ids"`
+}
+
+/* 删除管理员的申请内容 */
+type ManagerDeleteChange struct {
+	UserName string `json:"username"`
+}
+
+/* 审批时body中参数的结构 接口：change-requests/:id/approve、change-requests/:id/reject 请求方式：put */
+type DecideChangeParams struct {
+	Comment string `json:"comment"`
+}
+
+/* 修改申请返回的结构 */
+type ResChangeRequestData struct {
+	Id            int             `json:"id"`
+	Action        string          `json:"action"`
+	EntityId      int             `json:"entity_id"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	RequesterId   int             `json:"requester_id"`
+	RequesterName string          `json:"requester"`
+	ApproverId    int             `json:"approver_id"`
+	ApproverName  string          `json:"approver"`
+	Comment       string          `json:"comment"`
+	CreateTime    int             `json:"create_time"`
+	DecideTime    int             `json:"decide_time"`
+}
+
+/* 提交、审批修改申请时返回数据的结构 */
+type ResChangeRequest struct {
+	Data *ResChangeRequestData `json:"data"`
+	Meta *ResMeta              `json:"meta"`
+}
+
+/* 获取修改申请列表时data的结构 接口：change-requests 请求方式：get */
+type ResChangeRequestsData struct {
+	Total    int64                   `json:"total"`
+	PageNum  int                     `json:"pagenum"`
+	Requests []*ResChangeRequestData `json:"requests"`
+}
+
+/* 获取修改申请列表时返回数据的结构 */
+type ResChangeRequests struct {
+	Data *ResChangeRequestsData `json:"data"`
+	Meta *ResMeta               `json:"meta"`
+}
+
+// 判断操作是否需要审批
+func ApprovalRequired(action string) bool {
+	for _, a := range beego.AppConfig.Strings("ApprovalActions") {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断是否是违反唯一索引的错误（MySQL错误码1062）
+func isDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+/* 提交修改申请。同一对象的同一操作只能有一个待审批的申请，由sp_change_request的唯一索引保证，
+*  并发提交时只有一个能成功，其他返回ErrChangePending */
+func CreateChangeRequest(action string, entityId int, payload interface{}, requester *SpManager) (*ResChangeRequestData, error) {
+	return createChangeRequest(orm.NewOrm(), action, entityId, payload, requester)
+}
+
+// 使用o提交修改申请，可以在调用方的事务中执行
+func createChangeRequest(o orm.Ormer, action string, entityId int, payload interface{}, requester *SpManager) (*ResChangeRequestData, error) {
+	if o.QueryTable("sp_change_request").Filter("action", action).Filter("entity_id", entityId).Filter("status", ChangePending).Exist() {
+		return nil, ErrChangePending
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req := SpChangeRequest{Action: action, EntityId: entityId, Payload: string(data), Status: ChangePending,
+		RequesterId: requester.MgId, RequesterName: requester.MgName, CreateTime: int(time.Now().Unix())}
+	_, err = o.Insert(&req)
+	if isDuplicateKey(err) {
+		return nil, ErrChangePending
+	}
+	if err != nil {
+		return nil, err
+	}
+	return changeRequestData(&req), nil
+}
+
+/* 批准或拒绝修改申请。批准时在同一个事务中修改申请状态并执行修改，修改失败则申请保持待审批 */
+func DecideChangeRequest(id int, approver *SpManager, approve bool, comment string) (*ResChangeRequestData, error) {
+	o := orm.NewOrm()
+	req := SpChangeRequest{Id: id}
+	err := o.Read(&req)
+	if err != nil {
+		return nil, errors.New("修改申请不存在")
+	}
+	if req.Status != ChangePending {
+		return nil, errors.New("该修改申请已处理")
+	}
+	if req.RequesterId == approver.MgId {
+		return nil, ErrSelfApproval
+	}
+
+	req.Status = ChangeRejected
+	if approve {
+		req.Status = ChangeApproved
+	}
+	req.ApproverId = approver.MgId
+	req.ApproverName = approver.MgName
+	req.Comment = comment
+	req.DecideTime = int(time.Now().Unix())
+
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// 只更新仍处于待审批状态的申请，防止同一申请被并发处理两次
+	num, err := o.QueryTable("sp_change_request").Filter("id", id).Filter("status", ChangePending).Update(orm.Params{
+		"status": req.Status, "approver_id": req.ApproverId, "approver_name": req.ApproverName,
+		"comment": req.Comment, "decide_time": req.DecideTime})
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	if num == 0 {
+		o.Rollback()
+		return nil, errors.New("该修改申请已处理")
+	}
+	if approve {
+		err = applyChange(o, &req, approver)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	err = o.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	// 角色的权限已修改，新的权限版本号立即生效
+	if approve && req.Action == ActionRoleRights {
+		forgetRoleVersions(req.EntityId)
+	}
+	// 删除管理员后吊销该管理员已签发的所有token
+	if approve && req.Action == ActionManagerDelete {
+		var change ManagerDeleteChange
+		if json.Unmarshal([]byte(req.Payload), &change) == nil {
+			RevokeManagerTokens(req.EntityId, change.UserName)
+		}
+	}
+	return changeRequestData(&req), nil
+}
+
+/* 在审批事务中执行修改申请 */
+func applyChange(o orm.Ormer, req *SpChangeRequest, approver *SpManager) error {
+	switch req.Action {
+	case ActionGoodsPrice:
+		var change GoodInfoChange
+		err := json.Unmarshal([]byte(req.Payload), &change)
+		if err != nil {
+			return err
+		}
+		// 审批人也只能批准自己数据范围内的商品
+		scope, err := GetDataScope(approver)
+		if err != nil {
+			return err
+		}
+		err = checkGoodScope(o, req.EntityId, scope)
+		if err != nil {
+			return err
+		}
+		// 商品的其他字段在提交申请时已经修改，这里只修改价格
+		good := SpGoods{GoodsId: req.EntityId, GoodsPrice: change.GoodsPrice, UpdTime: int(time.Now().Unix())}
+		num, err := o.Update(&good, "goods_price", "upd_time")
+		if err == nil && num == 0 && !o.QueryTable("sp_goods").Filter("goods_id", req.EntityId).Exist() {
+			return errors.New("商品不存在")
+		}
+		return err
+	case ActionRoleRights:
+		var change RoleRightsChange
+		err := json.Unmarshal([]byte(req.Payload), &change)
+		if err != nil {
+			return err
+		}
+		if !o.QueryTable("sp_role").Filter("role_id", req.EntityId).Exist() {
+			return errors.New("角色不存在")
+		}
+		return replaceRolePermissions(o, req.EntityId, change.PsIds)
+	case ActionManagerDelete:
+		return softDeleteManager(o, req.EntityId)
+	}
+	logs.Error("未知的修改申请类型", req.Action)
+	return errors.New("未知的修改申请类型")
+}
+
+/* 按状态分页查询修改申请，status为空时查询全部，按时间倒序 */
+func QueryChangeRequests(status string, pagenum, pagesize int) (int64, []*ResChangeRequestData, error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable("sp_change_request")
+	if status != "" {
+		qs = qs.Filter("status", status)
+	}
+	total, err := qs.Count()
+	if err != nil {
+		return 0, nil, err
+	}
+	var reqs []*SpChangeRequest
+	_, err = qs.OrderBy("-id").Limit(pagesize, (pagenum-1)*pagesize).All(&reqs)
+	if err != nil {
+		return 0, nil, err
+	}
+	resReqs := make([]*ResChangeRequestData, 0, len(reqs))
+	for _, req := range reqs {
+		resReqs = append(resReqs, changeRequestData(req))
+	}
+	return total, resReqs, nil
+}
+
+func changeRequestData(req *SpChangeRequest) *ResChangeRequestData {
+	return &ResChangeRequestData{req.Id, req.Action, req.EntityId, auditJson(req.Payload), req.Status,
+		req.RequesterId, req.RequesterName, req.ApproverId, req.ApproverName, req.Comment, req.CreateTime, req.DecideTime}
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpChangeRequest))
+}
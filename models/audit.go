@@ -0,0 +1,310 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 数据库中sp_audit_log表的模型，记录管理员的每一次修改操作（post、put、delete请求）
+type SpAuditLog struct {
+	Id         int    `orm:"pk;auto"`
+	MgId       int    `description:"操作人id"`
+	MgName     string `orm:"size(32)" description:"操作人"`
+	Method     string `orm:"size(8)" description:"请求方式"`
+	Route      string `orm:"size(255)" description:"请求路径（不含基准URL）"`
+	EntityType string `orm:"size(32)" description:"操作对象的类型"`
+	EntityId   string `orm:"size(32)" description:"操作对象的id"`
+	BeforeData string `orm:"type(text);null" description:"操作前的数据"`
+	AfterData  string `orm:"type(text);null" description:"操作后的数据"`
+	Status     int    `description:"响应中meta的status"`
+	Ip         string `orm:"size(64)" description:"客户端IP"`
+	CreateTime int    `description:"操作时间"`
+}
+
+/* 获取审计日志列表时每条日志返回的结构 接口：audit-logs 请求方式：get */
+type ResAuditLog struct {
+	Id         int             `json:"id"`
+	MgId       int             `json:"mg_id"`
+	MgName     string          `json:"username"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	EntityType string          `json:"entity_type"`
+	EntityId   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Status     int             `json:"status"`
+	Ip         string          `json:"ip"`
+	CreateTime int             `json:"create_time"`
+}
+
+/* 获取审计日志列表时data的结构 */
+type ResAuditLogsData struct {
+	Total   int64          `json:"total"`
+	PageNum int            `json:"pagenum"`
+	Logs    []*ResAuditLog `json:"logs"`
+}
+
+/* 获取审计日志列表时返回数据的结构 */
+type ResAuditLogs struct {
+	Data *ResAuditLogsData `json:"data"`
+	Meta *ResMeta          `json:"meta"`
+}
+
+/* 获取审计日志列表时的查询参数，除分页外都是可选的 */
+type AuditLogParams struct {
+	Pagenum    int
+	Pagesize   int
+	Actor      string
+	EntityType string
+	EntityId   string
+	StartTime  int
+	EndTime    int
+}
+
+/* 操作对象的定义：路由对应的对象类型、数据表及主键。
+*  IdParam为路由中表示对象id的参数；新增对象的路由没有id参数，从响应data的ResIdKey字段中取新对象的id */
+type auditEntity struct {
+	Route      string
+	EntityType string
+	Table      string
+	PkColumn   string
+	IdParam    string
+	ResIdKey   string
+}
+
+var auditEntities = []auditEntity{
+	{"users", "manager", "sp_manager", "mg_id", "", "id"},
+	{"users/:id", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/import", "manager", "sp_manager", "mg_id", "", ""},
+	{"users/:uId/state/:type", "manager", "sp_manager", "mg_id", ":uId", ""},
+	{"users/:id/roles", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/roles/:roleId", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/grants", "grant", "sp_manager_grant", "id", "", "id"},
+	{"users/:id/grants/:grantId", "grant", "sp_manager_grant", "id", ":grantId", ""},
+	{"users/:id/sessions/:sid", "session", "sp_manager_session", "sid", ":sid", ""},
+	{"service-accounts", "manager", "sp_manager", "mg_id", "", "id"},
+	{"service-accounts/:id", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"service-accounts/:id/keys", "api_key", "sp_api_key", "id", "", "id"},
+	{"service-accounts/:id/keys/:keyId", "api_key", "sp_api_key", "id", ":keyId", ""},
+	{"users/:id/restore", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/purge", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/unlock", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/mfa", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/password", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"users/:id/password/reset", "manager", "sp_manager", "mg_id", ":id", ""},
+	{"rights", "permission", "sp_permission", "ps_id", "", "id"},
+	{"rights/:id", "permission", "sp_permission", "ps_id", ":id", ""},
+	{"roles", "role", "sp_role", "role_id", "", "roleId"},
+	{"roles/:id", "role", "sp_role", "role_id", ":id", ""},
+	{"roles/:id/mfa", "role", "sp_role", "role_id", ":id", ""},
+	{"roles/:id/scope", "role", "sp_role", "role_id", ":id", ""},
+	{"roles/:roleId/rights", "role", "sp_role", "role_id", ":roleId", ""},
+	{"roles/:roleId/rights/:rightId", "role", "sp_role", "role_id", ":roleId", ""},
+	{"categories", "category", "sp_category", "cat_id", "", "cat_id"},
+	{"categories/:id", "category", "sp_category", "cat_id", ":id", ""},
+	{"categories/:id/attributes", "attribute", "sp_attribute", "attr_id", "", "attr_id"},
+	{"categories/:id/attributes/:attrId", "attribute", "sp_attribute", "attr_id", ":attrId", ""},
+	{"goods", "goods", "sp_goods", "goods_id", "", "goods_id"},
+	{"goods/:id", "goods", "sp_goods", "goods_id", ":id", ""},
+	{"orders/:order_id", "order", "sp_order", "order_id", ":order_id", ""},
+	{"change-requests/:id/approve", "change_request", "sp_change_request", "id", ":id", ""},
+	{"change-requests/:id/reject", "change_request", "sp_change_request", "id", ":id", ""},
+}
+
+// 快照中不能记录的敏感字段
+var auditSensitiveColumns = []string{"mg_pwd", "key_hash"}
+
+// 保存在请求上下文中的审计信息的key
+const auditDataKey = "_auditLog"
+
+/* 找出路由对应的操作对象，同时匹配多个时取固定片段最多的那一个 */
+func matchAuditEntity(path string) (*auditEntity, map[string]string) {
+	pathSegments := strings.Split(path, "/")
+	var best *auditEntity
+	bestScore := -1
+	for i := range auditEntities {
+		score, ok := matchRoute(auditEntities[i].Route, pathSegments)
+		if ok && score > bestScore {
+			best, bestScore = &auditEntities[i], score
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	params, _ := RouteParams(best.Route, path)
+	return best, params
+}
+
+/* 查询操作对象当前的数据，返回json字符串，对象不存在时返回空字符串 */
+func auditSnapshot(entity *auditEntity, id string) string {
+	var rows []orm.Params
+	o := orm.NewOrm()
+	sqlStr := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", entity.Table, entity.PkColumn)
+	_, err := o.Raw(sqlStr, id).Values(&rows)
+	if err != nil {
+		logs.Error("查询审计快照出错", err)
+		return ""
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+	for _, column := range auditSensitiveColumns {
+		delete(rows[0], column)
+	}
+	// 管理员的角色保存在sp_manager_role中，一并记录到快照
+	if entity.EntityType == "manager" {
+		mgId, _ := strconv.Atoi(id)
+		roles, err := GetManagerRoles(mgId)
+		if err == nil {
+			rows[0]["roles"] = roles
+		}
+	}
+	// 角色的权限保存在sp_role_permission中，一并记录到快照
+	if entity.EntityType == "role" {
+		roleId, _ := strconv.Atoi(id)
+		psIds, err := RolePermissionIds(roleId)
+		if err == nil {
+			ids := make([]int, 0, len(psIds))
+			for psId := range psIds {
+				ids = append(ids, psId)
+			}
+			sort.Ints(ids)
+			rows[0]["ps_ids"] = ids
+		}
+		// 角色的数据范围保存在sp_role_scope中
+		scope, err := GetRoleScope(roleId)
+		if err == nil {
+			rows[0]["cat_one_ids"] = scope.CatOneIds
+			rows[0]["order_sources"] = scope.OrderSources
+		}
+	}
+	data, _ := json.Marshal(rows[0])
+	return string(data)
+}
+
+/* 请求执行前调用：记录操作人、请求信息以及操作对象修改前的数据 */
+func StartAudit(ctx *context.Context) {
+	method := ctx.Input.Method()
+	if method != "POST" && method != "PUT" && method != "DELETE" {
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(ctx.Request.URL.Path, beego.AppConfig.String("baseURL")), "/")
+	// 登录相关的接口还没有操作人，登录尝试另有记录
+	if path == "login" || strings.HasPrefix(path, "login/") {
+		return
+	}
+	auditLog := SpAuditLog{Method: method, Route: path, Ip: ctx.Input.IP(), CreateTime: int(time.Now().Unix())}
+	if identity := CurrentIdentity(ctx); identity != nil {
+		auditLog.MgId = identity.MgId
+		auditLog.MgName = identity.UserName
+	} else if manager, err := CurrentManager(ctx); err == nil {
+		// 使用API Key访问时操作人是服务账号
+		auditLog.MgId = manager.MgId
+		auditLog.MgName = manager.MgName
+	}
+	entity, params := matchAuditEntity(path)
+	if entity != nil {
+		auditLog.EntityType = entity.EntityType
+		if entity.IdParam != "" {
+			auditLog.EntityId = params[entity.IdParam]
+			auditLog.BeforeData = auditSnapshot(entity, auditLog.EntityId)
+		}
+	}
+	ctx.Input.SetData(auditDataKey, &auditLog)
+}
+
+/* 请求执行后调用：记录响应状态以及操作对象修改后的数据，并保存审计日志 */
+func FinishAudit(ctx *context.Context) {
+	auditLog, ok := ctx.Input.GetData(auditDataKey).(*SpAuditLog)
+	if !ok {
+		return
+	}
+	// 控制器中this.Data与ctx.Input.Data是同一个map，从中取出响应数据
+	var res struct {
+		Data json.RawMessage `json:"data"`
+		Meta *struct {
+			Status int `json:"status"`
+		} `json:"meta"`
+	}
+	body, _ := json.Marshal(ctx.Input.GetData("json"))
+	_ = json.Unmarshal(body, &res)
+	if res.Meta != nil {
+		auditLog.Status = res.Meta.Status
+	}
+
+	entity, _ := matchAuditEntity(auditLog.Route)
+	if entity != nil {
+		if auditLog.EntityId == "" && entity.ResIdKey != "" {
+			var data map[string]interface{}
+			if json.Unmarshal(res.Data, &data) == nil && data[entity.ResIdKey] != nil {
+				auditLog.EntityId = fmt.Sprint(data[entity.ResIdKey])
+			}
+		}
+		if auditLog.EntityId != "" {
+			auditLog.AfterData = auditSnapshot(entity, auditLog.EntityId)
+		}
+	}
+
+	o := orm.NewOrm()
+	_, err := o.Insert(auditLog)
+	if err != nil {
+		logs.Error("保存审计日志出错", err)
+	}
+}
+
+/* 按条件分页查询审计日志，按时间倒序 */
+func QueryAuditLogs(params *AuditLogParams) (int64, []*ResAuditLog, error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable("sp_audit_log")
+	if params.Actor != "" {
+		qs = qs.Filter("mg_name", params.Actor)
+	}
+	if params.EntityType != "" {
+		qs = qs.Filter("entity_type", params.EntityType)
+	}
+	if params.EntityId != "" {
+		qs = qs.Filter("entity_id", params.EntityId)
+	}
+	if params.StartTime > 0 {
+		qs = qs.Filter("create_time__gte", params.StartTime)
+	}
+	if params.EndTime > 0 {
+		qs = qs.Filter("create_time__lte", params.EndTime)
+	}
+	total, err := qs.Count()
+	if err != nil {
+		return 0, nil, err
+	}
+	var auditLogs []*SpAuditLog
+	_, err = qs.OrderBy("-id").Limit(params.Pagesize, (params.Pagenum-1)*params.Pagesize).All(&auditLogs)
+	if err != nil {
+		return 0, nil, err
+	}
+	resLogs := make([]*ResAuditLog, 0, len(auditLogs))
+	for _, l := range auditLogs {
+		resLogs = append(resLogs, &ResAuditLog{l.Id, l.MgId, l.MgName, l.Method, l.Route,
+			l.EntityType, l.EntityId, auditJson(l.BeforeData), auditJson(l.AfterData), l.Status, l.Ip, l.CreateTime})
+	}
+	return total, resLogs, nil
+}
+
+/* 快照为空时返回null */
+func auditJson(data string) json.RawMessage {
+	if data == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(data)
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpAuditLog))
+}
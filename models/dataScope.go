@@ -0,0 +1,285 @@
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/orm"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 数据库中sp_role_scope表的模型，保存角色的数据范围规则。
+// 同一类型的规则可以有多条，取值的并集；角色没有某一类型的规则时，该类型的数据不受限制
+type SpRoleScope struct {
+	Id         int    `orm:"pk;auto"`
+	RoleId     int    `description:"角色id"`
+	ScopeType  string `orm:"size(32)" description:"规则类型"`
+	ScopeValue int    `description:"允许访问的值"`
+}
+
+// 同一角色的同一规则只保存一条
+func (this *SpRoleScope) TableUnique() [][]string {
+	return [][]string{{"RoleId", "ScopeType", "ScopeValue"}}
+}
+
+// 规则类型
+const (
+	// 允许访问的一级分类id（商品的cat_one_id）
+	ScopeCatOne = "cat_one"
+	// 允许访问的订单来源（订单的order_source）
+	ScopeOrderSource = "order_source"
+)
+
+// 访问超出数据范围的数据时返回的错误，控制器中需要返回403
+var ErrOutOfScope = errors.New("超出数据范围，无权操作该数据")
+
+/* put请求中参数的结构 接口：roles/:id/scope 请求方式：put
+*  cat_one_ids为空表示不限制分类；order_sources不传表示不修改，为空表示不限制订单来源 */
+type RoleScopeParams struct {
+	CatOneIds    []int  `json:"cat_one_ids"`
+	OrderSources *[]int `json:"order_sources"`
+}
+
+/* 获取、设置角色数据范围时返回数据中data的结构 接口：roles/:id/scope 请求方式：get、put */
+type ResRoleScopeData struct {
+	RoleId       int   `json:"roleId"`
+	CatOneIds    []int `json:"cat_one_ids"`
+	OrderSources []int `json:"order_sources"`
+}
+
+/* 获取、设置角色数据范围时返回数据的结构 */
+type ResRoleScope struct {
+	Data *ResRoleScopeData `json:"data"`
+	Meta *ResMeta          `json:"meta"`
+}
+
+/* 管理员的数据范围，是所有角色数据范围的并集。角色与接口权限相同，包括未到期的临时角色和继承的祖先角色。
+*  catOneIds为nil表示不限制分类，orderSources为nil表示不限制订单来源；只要有一个角色不限制某一类型，管理员就不限制该类型 */
+type DataScope struct {
+	catOneIds    map[int]bool
+	orderSources map[int]bool
+}
+
+// 查询管理员的数据范围，超级管理员返回nil（不受限制）
+func GetDataScope(manager *SpManager) (*DataScope, error) {
+	if manager.RoleId == 0 {
+		return nil, nil
+	}
+	// 与鉴权使用相同的角色：临时授予的角色、从祖先角色继承的同样带有数据范围
+	roleIds, err := managerEffectiveRoleIds(manager)
+	if err != nil {
+		return nil, err
+	}
+	scope := &DataScope{catOneIds: map[int]bool{}, orderSources: map[int]bool{}}
+	if len(roleIds) == 0 {
+		// 没有任何角色的管理员不能访问任何数据
+		return scope, nil
+	}
+	var rules []SpRoleScope
+	o := orm.NewOrm()
+	_, err = o.QueryTable("sp_role_scope").
+		Filter("role_id__in", roleIds).
+		Filter("scope_type__in", ScopeCatOne, ScopeOrderSource).
+		All(&rules)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]map[int]bool{ScopeCatOne: scope.catOneIds, ScopeOrderSource: scope.orderSources}
+	restricted := map[string]map[int]bool{ScopeCatOne: {}, ScopeOrderSource: {}}
+	for _, rule := range rules {
+		restricted[rule.ScopeType][rule.RoleId] = true
+		values[rule.ScopeType][rule.ScopeValue] = true
+	}
+	for _, roleId := range roleIds {
+		if !restricted[ScopeCatOne][roleId] {
+			scope.catOneIds = nil
+		}
+		if !restricted[ScopeOrderSource][roleId] {
+			scope.orderSources = nil
+		}
+	}
+	return scope, nil
+}
+
+// 查询当前登录的管理员的数据范围
+func CurrentDataScope(ctx *context.Context) (*DataScope, error) {
+	manager, err := CurrentManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return GetDataScope(manager)
+}
+
+// 是否限制分类
+func (this *DataScope) LimitsCategory() bool {
+	return this != nil && this.catOneIds != nil
+}
+
+// 判断一级分类是否在数据范围内
+func (this *DataScope) AllowCatOne(catOneId int) bool {
+	return !this.LimitsCategory() || this.catOneIds[catOneId]
+}
+
+// 数据范围内的一级分类id，按id排序
+func (this *DataScope) CatOneIds() []int {
+	ids := make([]int, 0, len(this.catOneIds))
+	for id := range this.catOneIds {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// 是否限制订单来源
+func (this *DataScope) LimitsOrderSource() bool {
+	return this != nil && this.orderSources != nil
+}
+
+// 判断订单来源是否在数据范围内
+func (this *DataScope) AllowOrderSource(source int) bool {
+	return !this.LimitsOrderSource() || this.orderSources[source]
+}
+
+// 数据范围内的订单来源，按值排序
+func (this *DataScope) OrderSources() []int {
+	sources := make([]int, 0, len(this.orderSources))
+	for source := range this.orderSources {
+		sources = append(sources, source)
+	}
+	sort.Ints(sources)
+	return sources
+}
+
+/* 校验分类（任意一级）是否在数据范围内。catId为0表示新建一级分类，限制了分类的管理员不能新建一级分类 */
+func (this *DataScope) CheckCategory(catId int) error {
+	if !this.LimitsCategory() {
+		return nil
+	}
+	if catId == 0 {
+		return ErrOutOfScope
+	}
+	rootId, err := CategoryRoot(catId)
+	if err != nil {
+		return err
+	}
+	if !this.catOneIds[rootId] {
+		return ErrOutOfScope
+	}
+	return nil
+}
+
+// 查询分类所属的一级分类id
+func CategoryRoot(catId int) (int, error) {
+	o := orm.NewOrm()
+	// 分类最多有三级，防止数据异常时死循环
+	for i := 0; i < 3; i++ {
+		cate := SpCategory{CatId: catId}
+		err := o.Read(&cate)
+		if err != nil {
+			return 0, err
+		}
+		if cate.CatPid == 0 {
+			return cate.CatId, nil
+		}
+		catId = cate.CatPid
+	}
+	return 0, errors.New("分类层级错误")
+}
+
+// 生成与ids个数相同的sql占位符，如“?,?,?”
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// 查询角色的数据范围
+func GetRoleScope(roleId int) (*ResRoleScopeData, error) {
+	var rules []SpRoleScope
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_role_scope").
+		Filter("role_id", roleId).
+		Filter("scope_type__in", ScopeCatOne, ScopeOrderSource).
+		OrderBy("scope_value").
+		All(&rules)
+	if err != nil {
+		return nil, err
+	}
+	data := &ResRoleScopeData{RoleId: roleId, CatOneIds: []int{}, OrderSources: []int{}}
+	for _, rule := range rules {
+		if rule.ScopeType == ScopeCatOne {
+			data.CatOneIds = append(data.CatOneIds, rule.ScopeValue)
+		} else {
+			data.OrderSources = append(data.OrderSources, rule.ScopeValue)
+		}
+	}
+	return data, nil
+}
+
+/* 用catOneIds替换角色原有的分类范围，catOneIds中的id必须都是一级分类；
+*  orderSources不为nil时同时替换订单来源范围，来源不能小于0 */
+func SetRoleScope(roleId int, catOneIds []int, orderSources *[]int) error {
+	o := orm.NewOrm()
+	catRules := roleScopeRules(roleId, ScopeCatOne, catOneIds)
+	for _, rule := range catRules {
+		if !o.QueryTable("sp_category").Filter("cat_id", rule.ScopeValue).Filter("cat_pid", 0).Exist() {
+			return errors.New("一级分类id不存在：" + strconv.Itoa(rule.ScopeValue))
+		}
+	}
+	var sourceRules []SpRoleScope
+	if orderSources != nil {
+		sourceRules = roleScopeRules(roleId, ScopeOrderSource, *orderSources)
+		for _, rule := range sourceRules {
+			if rule.ScopeValue < 0 {
+				return errors.New("订单来源错误：" + strconv.Itoa(rule.ScopeValue))
+			}
+		}
+	}
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	err = replaceRoleScope(o, roleId, ScopeCatOne, catRules)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if orderSources != nil {
+		err = replaceRoleScope(o, roleId, ScopeOrderSource, sourceRules)
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+// 生成角色某一类型的规则，去掉重复的值
+func roleScopeRules(roleId int, scopeType string, values []int) []SpRoleScope {
+	seen := make(map[int]bool)
+	rules := make([]SpRoleScope, 0, len(values))
+	for _, value := range values {
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		rules = append(rules, SpRoleScope{RoleId: roleId, ScopeType: scopeType, ScopeValue: value})
+	}
+	return rules
+}
+
+// 在调用方的事务中用rules替换角色某一类型的规则
+func replaceRoleScope(o orm.Ormer, roleId int, scopeType string, rules []SpRoleScope) error {
+	_, err := o.QueryTable("sp_role_scope").Filter("role_id", roleId).Filter("scope_type", scopeType).Delete()
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		_, err = o.InsertMulti(len(rules), rules)
+	}
+	return err
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpRoleScope))
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/astaxie/beego/validation"
 	"github.com/disintegration/imaging"
 	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -67,7 +68,9 @@ type SpGoods struct {
 }
 
 type PicBody struct {
-	Pic string
+	/* 修改商品时保留的已有图片的id，新上传的图片为0 */
+	Pics_id int
+	Pic     string
 }
 
 type AttrBody struct {
@@ -89,6 +92,20 @@ type AddGoodBody struct {
 	Pics            []*PicBody
 	Attrs           []*AttrBody
 }
+
+// put请求中参数的结构，与添加商品的参数相同 接口：goods/:id 请求方式：put
+// 为兼容只修改名称、价格、重量的请求，没有传入的字段保持不变：价格、重量、分类、数量、介绍为nil时不修改，
+// Pics、Attrs为nil时不修改（传入空数组表示删除全部图片或参数）
+type UpdateGoodBody struct {
+	Goods_name      string
+	Goods_cate      *string
+	Goods_price     *float64
+	Goods_number    *int
+	Goods_weight    *float64
+	Goods_introduce *string
+	Pics            []*PicBody
+	Attrs           []*AttrBody
+}
 type ResAddGoodAttrData struct {
 	GoodsId   int     `json:"goods_id"`
 	AttrId    int     `json:"attr_id"`
@@ -264,16 +281,77 @@ type ResGoodInfo struct {
 	Meta *ResMeta         `json:"meta"`
 }
 
+// 商品详情中的图片 接口：goods/:id 请求方式：get
+type ResGoodPic struct {
+	PicsId  int    `json:"pics_id"`
+	GoodsId int    `json:"goods_id"`
+	PicsBig string `json:"pics_big"`
+	PicsMid string `json:"pics_mid"`
+	PicsSma string `json:"pics_sma"`
+}
+
+// 获取商品详情时返回结果中Data字段的数据，包含编辑商品时需要的所有字段 接口：goods/:id 请求方式：get
+type ResGoodDetailData struct {
+	GoodsId        int     `json:"goods_id"`
+	GoodsName      string  `json:"goods_name"`
+	GoodsPrice     float64 `json:"goods_price"`
+	GoodsNumber    int     `json:"goods_number"`
+	GoodsWeight    float64 `json:"goods_weight"`
+	GoodsIntroduce string  `json:"goods_introduce"`
+	GoodsBigLogo   string  `json:"goods_big_logo"`
+	GoodsSmallLogo string  `json:"goods_small_logo"`
+	GoodsState     int     `json:"goods_state"`
+	AddTime        int     `json:"add_time"`
+	UpdTime        int     `json:"upd_time"`
+	HotNumber      int     `json:"hot_number"`
+	IsPromote      bool    `json:"is_promote"`
+	CatId          int     `json:"cat_id"`
+	CatOneId       int     `json:"cat_one_id"`
+	CatTwoId       int     `json:"cat_two_id"`
+	CatThreeId     int     `json:"cat_three_id"`
+	/* 三级分类id用逗号连接，格式与添加商品时的goods_cate相同 */
+	GoodsCate string                `json:"goods_cate"`
+	Cats      []*GoodsCate          `json:"cats"`
+	Pics      []*ResGoodPic         `json:"pics"`
+	Attrs     []*ResAddGoodAttrData `json:"attrs"`
+}
+
+// 获取商品详情时返回的数据 接口：goods/:id 请求方式：get
+type ResGoodDetail struct {
+	Data *ResGoodDetailData `json:"data"`
+	Meta *ResMeta           `json:"meta"`
+}
+
 // 根据商品id验证商品是否存在
 func GoodExists(id int) bool {
 	o := orm.NewOrm()
 	return o.QueryTable("sp_goods").Filter("goods_id", id).Exist()
 }
 
-// 删除商品
-func DeleteGood(id int) error {
+// 校验商品是否在数据范围内
+func checkGoodScope(o orm.Ormer, id int, scope *DataScope) error {
+	if !scope.LimitsCategory() {
+		return nil
+	}
+	good := SpGoods{GoodsId: id}
+	err := o.Read(&good)
+	if err != nil {
+		return err
+	}
+	if !scope.AllowCatOne(good.CatOneId) {
+		return ErrOutOfScope
+	}
+	return nil
+}
+
+// 删除商品，商品不在数据范围内时返回ErrOutOfScope
+func DeleteGood(id int, scope *DataScope) error {
 	o := orm.NewOrm()
-	err := o.Begin()
+	err := checkGoodScope(o, id, scope)
+	if err != nil {
+		return err
+	}
+	err = o.Begin()
 	if err != nil {
 		return err
 	}
@@ -287,21 +365,280 @@ func DeleteGood(id int) error {
 	return nil
 }
 
-// 修改商品
-func UpdateGood(good *SpGoods, id int) (*SpGoods, error) {
-	good.GoodsId = id
+// 解析商品分类参数（一级、二级、三级分类id用逗号连接），并校验三个分类的层级关系
+func parseGoodsCate(goodsCate string) ([]int, error) {
+	goodsCateArr := strings.Split(goodsCate, ",")
+	if len(goodsCateArr) != 3 {
+		return nil, errors.New("分类参数错误")
+	}
+	catIds := make([]int, 0, 3)
+	for _, v := range goodsCateArr {
+		catId, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errors.New("分类参数错误")
+		}
+		catIds = append(catIds, catId)
+	}
 	o := orm.NewOrm()
-	err := o.Begin()
+	pid := 0
+	for level, catId := range catIds {
+		cate := SpCategory{CatId: catId}
+		err := o.Read(&cate)
+		if err != nil || cate.CatPid != pid || cate.CatLevel != level || cate.CatDeleted == 1 {
+			return nil, errors.New("分类参数错误")
+		}
+		pid = catId
+	}
+	return catIds, nil
+}
+
+// 商品图片地址的前缀，图片保存在本地，地址去掉前缀后就是文件的路径
+const goodPicBaseUrl = "http://127.0.0.1:8700"
+
+// 根据上传的图片生成大、中、小三张图片，返回商品图片记录（未保存）
+func newGoodPic(goodsId int, pic string) (*SpGoodsPics, error) {
+	resizePathArr, err := ResizeImg(pic)
 	if err != nil {
 		return nil, err
 	}
-	_, err = o.Update(good, "goods_name", "goods_price", "goods_weight")
+	return &SpGoodsPics{
+		GoodsId: goodsId,
+		PicsBig: goodPicBaseUrl + resizePathArr[0],
+		PicsMid: goodPicBaseUrl + resizePathArr[1],
+		PicsSma: goodPicBaseUrl + resizePathArr[2],
+	}, nil
+}
+
+// 删除商品图片的大、中、小三个文件，不是本地的图片和已不存在的文件忽略
+func removeGoodPicFiles(pics []*SpGoodsPics) {
+	for _, pic := range pics {
+		for _, url := range []string{pic.PicsBig, pic.PicsMid, pic.PicsSma} {
+			if !strings.HasPrefix(url, goodPicBaseUrl+"/") {
+				continue
+			}
+			err := os.Remove("." + strings.TrimPrefix(url, goodPicBaseUrl))
+			if err != nil && !os.IsNotExist(err) {
+				logs.Error("删除商品图片文件出错", err)
+			}
+		}
+	}
+}
+
+/* 修改商品的图片：删除没有保留的已有图片，保存新上传的图片（只为新图片生成缩略图）。
+*  返回新生成的图片added和删除的图片removed，由调用方在事务回滚后删除added的文件、提交后删除removed的文件。
+*  出错时added中同样是已经生成的图片 */
+func updateGoodPics(o orm.Ormer, goodsId int, pics []*PicBody) (added, removed []*SpGoodsPics, err error) {
+	var current []*SpGoodsPics
+	_, err = o.QueryTable("sp_goods_pics").Filter("goods_id", goodsId).All(&current)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := make(map[int]bool)
+	for _, pic := range pics {
+		if pic.Pics_id != 0 {
+			keep[pic.Pics_id] = true
+		}
+	}
+	for _, pic := range current {
+		if keep[pic.PicsId] {
+			delete(keep, pic.PicsId)
+			continue
+		}
+		_, err = o.Delete(pic)
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, pic)
+	}
+	if len(keep) > 0 {
+		return nil, nil, errors.New("图片不属于该商品")
+	}
+	for _, pic := range pics {
+		if pic.Pics_id != 0 {
+			continue
+		}
+		if pic.Pic == "" {
+			return added, nil, errors.New("图片参数错误")
+		}
+		goodPic, err := newGoodPic(goodsId, pic.Pic)
+		if err != nil {
+			return added, nil, err
+		}
+		added = append(added, goodPic)
+		_, err = o.Insert(goodPic)
+		if err != nil {
+			return added, nil, err
+		}
+	}
+	return added, removed, nil
+}
+
+// 修改商品的参数：删除请求中没有的参数，修改值有变化的参数，添加新的参数。
+// 每个参数只保存一条记录，参数必须属于商品的三级分类且未删除
+func updateGoodAttrs(o orm.Ormer, goodsId, catId int, attrs []*AttrBody) error {
+	values := make(map[int]string, len(attrs))
+	attrIds := make([]int, 0, len(attrs))
+	for _, attr := range attrs {
+		if _, ok := values[attr.Attr_id]; ok {
+			return errors.New("参数重复")
+		}
+		values[attr.Attr_id] = attr.Attr_value
+		attrIds = append(attrIds, attr.Attr_id)
+	}
+	if len(attrIds) > 0 {
+		num, err := o.QueryTable("sp_attribute").Filter("attr_id__in", attrIds).Filter("cat_id", catId).
+			Filter("delete_time__isnull", true).Count()
+		if err != nil {
+			return err
+		}
+		if int(num) != len(attrIds) {
+			return errors.New("参数不存在或不属于商品分类")
+		}
+	}
+
+	var current []*SpGoodsAttr
+	_, err := o.QueryTable("sp_goods_attr").Filter("goods_id", goodsId).All(&current)
+	if err != nil {
+		return err
+	}
+	for _, goodAttr := range current {
+		value, ok := values[goodAttr.AttrId]
+		if !ok {
+			_, err = o.Delete(goodAttr)
+		} else if value != goodAttr.AttrValue {
+			goodAttr.AttrValue = value
+			_, err = o.Update(goodAttr, "AttrValue")
+		}
+		if err != nil {
+			return err
+		}
+		delete(values, goodAttr.AttrId)
+	}
+	// 按请求中的顺序添加新的参数
+	for _, attrId := range attrIds {
+		value, ok := values[attrId]
+		if !ok {
+			continue
+		}
+		_, err = o.Insert(&SpGoodsAttr{GoodsId: goodsId, AttrId: attrId, AttrValue: value})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 修改商品，图片和参数与已有的比较后增删改，所有修改在一个事务中完成。商品或新的分类不在数据范围内时返回ErrOutOfScope。
+// 开启了价格审批且价格有变化时不修改价格，而是在同一个事务中提交修改申请并返回该申请，不需要审批时返回nil。
+// 已有待审批的价格修改申请时返回ErrChangePending，其他修改也不会保存
+func UpdateGood(body *UpdateGoodBody, id int, scope *DataScope, requester *SpManager) (*ResChangeRequestData, error) {
+	o := orm.NewOrm()
+	err := checkGoodScope(o, id, scope)
+	if err != nil {
+		return nil, err
+	}
+	good := SpGoods{GoodsId: id}
+	err = o.Read(&good)
+	if err != nil {
+		return nil, err
+	}
+
+	if body.Goods_price != nil && *body.Goods_price < 0 {
+		return nil, errors.New("商品价格不能小于0")
+	}
+	if body.Goods_weight != nil && *body.Goods_weight < 0 {
+		return nil, errors.New("商品重量不能小于0")
+	}
+	columns := []string{"goods_name", "upd_time"}
+	good.GoodsName = body.Goods_name
+	good.UpdTime = int(time.Now().Unix())
+	// 传入了价格且价格有变化时，开启了价格审批则提交修改申请，批准后才修改价格
+	requestPrice := false
+	if body.Goods_price != nil && *body.Goods_price != good.GoodsPrice {
+		if ApprovalRequired(ActionGoodsPrice) {
+			requestPrice = true
+		} else {
+			good.GoodsPrice = *body.Goods_price
+			columns = append(columns, "goods_price")
+		}
+	}
+	if body.Goods_weight != nil {
+		good.GoodsWeight = *body.Goods_weight
+		columns = append(columns, "goods_weight")
+	}
+	if body.Goods_number != nil {
+		if *body.Goods_number < 0 {
+			return nil, errors.New("商品数量不能小于0")
+		}
+		good.GoodsNumber = *body.Goods_number
+		columns = append(columns, "goods_number")
+	}
+	if body.Goods_introduce != nil {
+		good.GoodsIntroduce = *body.Goods_introduce
+		columns = append(columns, "goods_introduce")
+	}
+	if body.Goods_cate != nil {
+		catIds, err := parseGoodsCate(*body.Goods_cate)
+		if err != nil {
+			return nil, err
+		}
+		// 新的分类同样必须在数据范围内
+		if !scope.AllowCatOne(catIds[0]) {
+			return nil, ErrOutOfScope
+		}
+		good.CatOneId, good.CatTwoId, good.CatThreeId, good.CatId = catIds[0], catIds[1], catIds[2], catIds[2]
+		columns = append(columns, "cat_one_id", "cat_two_id", "cat_three_id", "cat_id")
+	}
+
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// 先提交价格修改申请，已有待审批的申请时不保存任何修改
+	var change *ResChangeRequestData
+	if requestPrice {
+		priceChange := GoodInfoChange{good.GoodsName, *body.Goods_price, good.GoodsWeight, good.GoodsPrice}
+		change, err = createChangeRequest(o, ActionGoodsPrice, id, &priceChange, requester)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	_, err = o.Update(&good, columns...)
 	if err != nil {
 		o.Rollback()
 		return nil, err
 	}
-	o.Commit()
-	return good, nil
+	// 图片文件不在事务中：回滚后删除新生成的文件，提交后才删除不再使用的文件
+	var added, removed []*SpGoodsPics
+	if body.Pics != nil {
+		added, removed, err = updateGoodPics(o, id, body.Pics)
+		if err != nil {
+			o.Rollback()
+			removeGoodPicFiles(added)
+			return nil, err
+		}
+	}
+	// 修改了分类但没有传入参数时，已有的参数都不属于新分类，全部删除
+	attrs := body.Attrs
+	if attrs == nil && body.Goods_cate != nil {
+		attrs = []*AttrBody{}
+	}
+	if attrs != nil {
+		err = updateGoodAttrs(o, id, good.CatId, attrs)
+		if err != nil {
+			o.Rollback()
+			removeGoodPicFiles(added)
+			return nil, err
+		}
+	}
+	err = o.Commit()
+	if err != nil {
+		removeGoodPicFiles(added)
+		return nil, err
+	}
+	removeGoodPicFiles(removed)
+	return change, nil
 }
 
 // 根据图片路径，将该图在相同目录下生成大、中、小三张图片，并返回这三张图片的路径
@@ -348,8 +685,8 @@ func ResizeImg(oriPicPath string) ([]string, error) {
 	return []string{largePicPath, midPicPath, smallPicPath}, nil
 }
 
-// 添加商品
-func AddGood(addGoodBody AddGoodBody) (*ResAddGoodData, error) {
+// 添加商品，商品的一级分类不在数据范围内时返回ErrOutOfScope
+func AddGood(addGoodBody AddGoodBody, scope *DataScope) (*ResAddGoodData, error) {
 	o := orm.NewOrm()
 	err := o.Begin()
 	if err != nil {
@@ -369,6 +706,16 @@ func AddGood(addGoodBody AddGoodBody) (*ResAddGoodData, error) {
 		}
 		catIds = append(catIds, catId)
 	}
+	// 一级分类以三级分类实际所属的分类为准，防止传入伪造的一级分类id
+	if !scope.AllowCatOne(catIds[0]) {
+		o.Rollback()
+		return nil, ErrOutOfScope
+	}
+	err = scope.CheckCategory(catIds[2])
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
 
 	// 当前时间的时间戳
 	current := int(time.Now().Unix())
@@ -396,18 +743,11 @@ func AddGood(addGoodBody AddGoodBody) (*ResAddGoodData, error) {
 
 	var resPics []*SpGoodsPics
 	for _, v := range addGoodBody.Pics {
-		resizePathArr, err := ResizeImg(v.Pic)
+		goodPic, err := newGoodPic(good.GoodsId, v.Pic)
 		if err != nil {
 			o.Rollback()
 			return nil, err
 		}
-		baseUrl := "http://127.0.0.1:8700"
-		goodPic := &SpGoodsPics{
-			GoodsId: good.GoodsId,
-			PicsBig: baseUrl + resizePathArr[0],
-			PicsMid: baseUrl + resizePathArr[1],
-			PicsSma: baseUrl + resizePathArr[2],
-		}
 		_, err = o.Insert(goodPic)
 		if err != nil {
 			o.Rollback()
@@ -481,21 +821,44 @@ func (this *AddCateParams) Valid(v *validation.Validation) {
 	}
 }
 
-// 获取商品分类列表，不包含分页参数
-func GetCateList(level int) ([]*ResGoodsCate, error) {
-	return GetCate(0, level)
+// 获取商品分类列表，不包含分页参数。只返回数据范围内的一级分类及其子分类
+func GetCateList(level int, scope *DataScope) ([]*ResGoodsCate, error) {
+	cates, err := GetCate(0, level)
+	if err != nil || !scope.LimitsCategory() {
+		return cates, err
+	}
+	resCates := []*ResGoodsCate{}
+	for _, cate := range cates {
+		if scope.AllowCatOne(cate.CatId) {
+			resCates = append(resCates, cate)
+		}
+	}
+	return resCates, nil
 }
 
-// 获取商品分类列表，包含分页参数
-func GetCatePage(level int, pageNum int, pageSize int) (*ResCatePageData, error) {
+// 获取商品分类列表，包含分页参数。只返回数据范围内的一级分类及其子分类
+func GetCatePage(level int, pageNum int, pageSize int, scope *DataScope) (*ResCatePageData, error) {
 	o := orm.NewOrm()
 	// 过滤掉被假删的分类
 	//total, err := o.QueryTable("sp_category").
 	//	Filter("CatPid", 0).Exclude("CatDeleted", 1).Count()
 
 	// 保留被假删的分类
-	total, err := o.QueryTable("sp_category").
-		Filter("CatPid", 0).Count()
+	qs := o.QueryTable("sp_category").Filter("CatPid", 0)
+	scopeSql := ""
+	var args []interface{}
+	if scope.LimitsCategory() {
+		catOneIds := scope.CatOneIds()
+		if len(catOneIds) == 0 {
+			return &ResCatePageData{Total: 0, PageNum: pageNum, PageSize: pageSize, Result: []*ResGoodsCate{}}, nil
+		}
+		qs = qs.Filter("CatId__in", catOneIds)
+		scopeSql = fmt.Sprintf(" AND cat_id IN (%s)", sqlPlaceholders(len(catOneIds)))
+		for _, id := range catOneIds {
+			args = append(args, id)
+		}
+	}
+	total, err := qs.Count()
 	if err != nil {
 		return nil, err
 	}
@@ -508,13 +871,13 @@ func GetCatePage(level int, pageNum int, pageSize int) (*ResCatePageData, error)
 		FROM
 			sp_category
 		WHERE
-			cat_pid = 0
+			cat_pid = 0%s
 		LIMIT
-			%d,%d`, start, pageSize)
+			%d,%d`, scopeSql, start, pageSize)
 	/* 存储从数据库中查询到的结果集 */
 	var goodsCate []GoodsCate
 	/* 从数据库中查询出所有pid为id的记录，例如id为0，查询结果就是所有一级权限 */
-	_, err = o.Raw(sqlStr).QueryRows(&goodsCate)
+	_, err = o.Raw(sqlStr, args...).QueryRows(&goodsCate)
 	if err != nil {
 		return nil, err
 	}
@@ -674,14 +1037,22 @@ func DeleteAttr(attrId int) error {
 	return nil
 }
 
-// 获取商品数据列表 【接口：goods 请求方式：get】
-func GetGoodsList(query string, pagenum, pagesize int) (int, []*SpGoods, error) {
+// 获取商品数据列表，只返回一级分类在数据范围内的商品 【接口：goods 请求方式：get】
+func GetGoodsList(query string, pagenum, pagesize int, scope *DataScope) (int, []*SpGoods, error) {
 	o := orm.NewOrm()
 	goodsList := make([]*SpGoods, 0)
 	offset := (pagenum - 1) * pagesize
 	qs1 := o.QueryTable("sp_goods").Filter("is_del", "0")
 	// OrderBy的参数前使用减号“-”意味着倒叙排列
 	qs2 := o.QueryTable("sp_goods").Filter("is_del", "0")
+	if scope.LimitsCategory() {
+		catOneIds := scope.CatOneIds()
+		if len(catOneIds) == 0 {
+			return 0, goodsList, nil
+		}
+		qs1 = qs1.Filter("cat_one_id__in", catOneIds)
+		qs2 = qs2.Filter("cat_one_id__in", catOneIds)
+	}
 	if strings.Trim(query, " ") != "" {
 		// 按条件模糊查询
 		qs1 = qs1.Filter("goods_name__icontains", query)
@@ -698,6 +1069,88 @@ func GetGoodsList(query string, pagenum, pagesize int) (int, []*SpGoods, error)
 	return int(total), goodsList, nil
 }
 
+// 获取商品详情，包括图片、参数以及从一级到三级的分类。商品不存在或已删除时返回orm.ErrNoRows，
+// 不在数据范围内时返回ErrOutOfScope 【接口：goods/:id 请求方式：get】
+func GetGoodDetail(id int, scope *DataScope) (*ResGoodDetailData, error) {
+	o := orm.NewOrm()
+	good := SpGoods{GoodsId: id}
+	err := o.Read(&good)
+	if err != nil {
+		return nil, err
+	}
+	if good.IsDel == "1" {
+		return nil, orm.ErrNoRows
+	}
+	if !scope.AllowCatOne(good.CatOneId) {
+		return nil, ErrOutOfScope
+	}
+	detail := &ResGoodDetailData{
+		GoodsId:        good.GoodsId,
+		GoodsName:      good.GoodsName,
+		GoodsPrice:     good.GoodsPrice,
+		GoodsNumber:    good.GoodsNumber,
+		GoodsWeight:    good.GoodsWeight,
+		GoodsIntroduce: good.GoodsIntroduce,
+		GoodsBigLogo:   good.GoodsBigLogo,
+		GoodsSmallLogo: good.GoodsSmallLogo,
+		GoodsState:     good.GoodsState,
+		AddTime:        good.AddTime,
+		UpdTime:        good.UpdTime,
+		HotNumber:      good.HotNumber,
+		IsPromote:      good.IsPromote,
+		CatId:          good.CatId,
+		CatOneId:       good.CatOneId,
+		CatTwoId:       good.CatTwoId,
+		CatThreeId:     good.CatThreeId,
+		GoodsCate:      fmt.Sprintf("%d,%d,%d", good.CatOneId, good.CatTwoId, good.CatThreeId),
+	}
+
+	// 分类路径按分类表中的父分类逐级向上查找，从一级分类开始排列
+	detail.Cats = []*GoodsCate{}
+	for catId := good.CatId; catId != 0 && len(detail.Cats) < 3; {
+		cate := SpCategory{CatId: catId}
+		err = o.Read(&cate)
+		if err == orm.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		detail.Cats = append([]*GoodsCate{{cate.CatId, cate.CatName, cate.CatPid, cate.CatLevel, cate.CatDeleted == 1}},
+			detail.Cats...)
+		catId = cate.CatPid
+	}
+
+	var pics []*SpGoodsPics
+	_, err = o.QueryTable("sp_goods_pics").Filter("goods_id", id).OrderBy("pics_id").All(&pics)
+	if err != nil {
+		return nil, err
+	}
+	detail.Pics = make([]*ResGoodPic, 0, len(pics))
+	for _, pic := range pics {
+		detail.Pics = append(detail.Pics, &ResGoodPic{pic.PicsId, pic.GoodsId, pic.PicsBig, pic.PicsMid, pic.PicsSma})
+	}
+
+	// 商品的参数值以及参数的名称、类型（动态参数或静态属性），已删除的参数不再返回
+	detail.Attrs = []*ResAddGoodAttrData{}
+	_, err = o.Raw(`
+		SELECT
+			t1.goods_id, t1.attr_id, t1.attr_value, t1.add_price,
+			t2.attr_name, t2.attr_sel, t2.attr_write, t2.attr_vals
+		FROM
+			sp_goods_attr AS t1 INNER JOIN sp_attribute AS t2
+		ON
+			t1.attr_id = t2.attr_id
+		WHERE
+			t1.goods_id = ? AND t2.delete_time IS NULL
+		ORDER BY
+			t1.id`, id).QueryRows(&detail.Attrs)
+	if err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
 //初始化模型
 func init() {
 	// 需要在init中注册定义的model
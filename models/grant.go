@@ -0,0 +1,300 @@
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"time"
+)
+
+// 数据库中sp_manager_grant表的模型，保存临时授予管理员的角色或权限。
+// 授权到期后立即失效（校验权限时按expire_time判断），定时任务再把它标记为已过期，记录保留用于查询授权历史
+type SpManagerGrant struct {
+	Id         int    `orm:"pk;auto"`
+	MgId       int    `description:"管理员id"`
+	GrantType  string `orm:"size(16)" description:"role:角色 permission:权限"`
+	TargetId   int    `description:"角色id或权限id"`
+	Status     string `orm:"size(16)" description:"active:生效中 expired:已过期 revoked:已撤销"`
+	GrantedBy  string `orm:"size(32)" description:"授权人"`
+	GrantTime  int    `description:"授权时间"`
+	ExpireTime int    `description:"到期时间"`
+	EndTime    int    `description:"实际失效（过期或撤销）的时间"`
+}
+
+// 临时授权的类型
+const (
+	GrantRole       = "role"
+	GrantPermission = "permission"
+)
+
+// 临时授权的状态
+const (
+	GrantActive  = "active"
+	GrantExpired = "expired"
+	GrantRevoked = "revoked"
+)
+
+/* 添加临时授权时body中参数的结构 接口：users/:id/grants 请求方式：post */
+type GrantParams struct {
+	GrantType  string `json:"type" valid:"Required"`
+	TargetId   int    `json:"target_id" valid:"Required"`
+	ExpireTime int    `json:"expire_time" valid:"Required"`
+}
+
+func (this *GrantParams) Valid(v *validation.Validation) {
+	if this.GrantType != GrantRole && this.GrantType != GrantPermission {
+		v.SetError("GrantType", "授权类型必须是role或permission")
+	}
+	if this.ExpireTime <= int(time.Now().Unix()) {
+		v.SetError("ExpireTime", "到期时间必须晚于当前时间")
+	}
+}
+
+/* 临时授权返回的结构 */
+type ResGrant struct {
+	Id         int    `json:"id"`
+	MgId       int    `json:"mg_id"`
+	UserName   string `json:"username"`
+	GrantType  string `json:"type"`
+	TargetId   int    `json:"target_id"`
+	TargetName string `json:"target_name"`
+	Status     string `json:"status"`
+	GrantedBy  string `json:"granted_by"`
+	GrantTime  int    `json:"grant_time"`
+	ExpireTime int    `json:"expire_time"`
+	EndTime    int    `json:"end_time"`
+	/* 距离到期还有多少秒，已失效的授权为0 */
+	ExpiresIn int `json:"expires_in"`
+}
+
+/* 添加、撤销临时授权时返回数据的结构 */
+type ResGrantInfo struct {
+	Data *ResGrant `json:"data"`
+	Meta *ResMeta  `json:"meta"`
+}
+
+/* 获取临时授权列表时返回数据的结构 接口：users/:id/grants、grants/expiring 请求方式：get */
+type ResGrantList struct {
+	Data []*ResGrant `json:"data"`
+	Meta *ResMeta    `json:"meta"`
+}
+
+// 生效中且未到期的授权
+func activeGrants(o orm.Ormer) orm.QuerySeter {
+	return o.QueryTable("sp_manager_grant").
+		Filter("status", GrantActive).
+		Filter("expire_time__gt", time.Now().Unix())
+}
+
+// 查询管理员所有角色的id，包括未到期的临时角色
+func managerRoleIds(mgId int) ([]int, error) {
+	var managerRoles []SpManagerRole
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_manager_role").Filter("mg_id", mgId).All(&managerRoles, "RoleId")
+	if err != nil {
+		return nil, err
+	}
+	var grants []SpManagerGrant
+	_, err = activeGrants(o).Filter("mg_id", mgId).Filter("grant_type", GrantRole).All(&grants, "TargetId")
+	if err != nil {
+		return nil, err
+	}
+	roleIds := make([]int, 0, len(managerRoles)+len(grants))
+	for _, mr := range managerRoles {
+		roleIds = append(roleIds, mr.RoleId)
+	}
+	for _, g := range grants {
+		roleIds = append(roleIds, g.TargetId)
+	}
+	return roleIds, nil
+}
+
+// 查询临时授予管理员且未到期的权限id
+func grantedPermissionIds(mgId int) ([]int, error) {
+	var grants []SpManagerGrant
+	o := orm.NewOrm()
+	_, err := activeGrants(o).Filter("mg_id", mgId).Filter("grant_type", GrantPermission).All(&grants, "TargetId")
+	if err != nil {
+		return nil, err
+	}
+	psIds := make([]int, 0, len(grants))
+	for _, g := range grants {
+		psIds = append(psIds, g.TargetId)
+	}
+	return psIds, nil
+}
+
+/* 临时授予管理员一个角色或权限 */
+func AddGrant(manager *SpManager, params *GrantParams, grantedBy string) (*ResGrant, error) {
+	o := orm.NewOrm()
+	if params.GrantType == GrantRole {
+		if !o.QueryTable("sp_role").Filter("role_id", params.TargetId).Exist() {
+			return nil, errors.New("角色不存在")
+		}
+	} else if !o.QueryTable("sp_permission").Filter("ps_id", params.TargetId).Exist() {
+		return nil, errors.New("权限不存在")
+	}
+	if activeGrants(o).Filter("mg_id", manager.MgId).Filter("grant_type", params.GrantType).Filter("target_id", params.TargetId).Exist() {
+		return nil, errors.New("管理员已有该临时授权，请先撤销")
+	}
+	grant := SpManagerGrant{MgId: manager.MgId, GrantType: params.GrantType, TargetId: params.TargetId, Status: GrantActive,
+		GrantedBy: grantedBy, GrantTime: int(time.Now().Unix()), ExpireTime: params.ExpireTime}
+	_, err := o.Insert(&grant)
+	if err != nil {
+		return nil, err
+	}
+	// 管理员已签发的token中没有这个授权，需要刷新
+	err = BumpManagerVersion(manager.MgId)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := resGrants([]*SpManagerGrant{&grant})
+	if err != nil {
+		return nil, err
+	}
+	return grants[0], nil
+}
+
+/* 撤销管理员的一个临时授权，已失效的授权不能撤销 */
+func RevokeGrant(mgId, grantId int) (*ResGrant, error) {
+	o := orm.NewOrm()
+	grant := SpManagerGrant{Id: grantId}
+	err := o.Read(&grant)
+	if err != nil || grant.MgId != mgId {
+		return nil, errors.New("临时授权不存在")
+	}
+	if grant.Status != GrantActive {
+		return nil, errors.New("临时授权已失效")
+	}
+	grant.Status = GrantRevoked
+	grant.EndTime = int(time.Now().Unix())
+	_, err = o.Update(&grant, "Status", "EndTime")
+	if err != nil {
+		return nil, err
+	}
+	// 管理员已签发的token中仍带着这个授权，使其失效
+	err = BumpManagerVersion(mgId)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := resGrants([]*SpManagerGrant{&grant})
+	if err != nil {
+		return nil, err
+	}
+	return grants[0], nil
+}
+
+/* 查询管理员的临时授权，按到期时间排列。history为false时只返回生效中的授权 */
+func GetManagerGrants(mgId int, history bool) ([]*ResGrant, error) {
+	var grants []*SpManagerGrant
+	o := orm.NewOrm()
+	qs := o.QueryTable("sp_manager_grant").Filter("mg_id", mgId)
+	if history {
+		qs = qs.OrderBy("-id")
+	} else {
+		qs = activeGrants(o).Filter("mg_id", mgId).OrderBy("expire_time")
+	}
+	_, err := qs.All(&grants)
+	if err != nil {
+		return nil, err
+	}
+	return resGrants(grants)
+}
+
+/* 查询所有管理员在within秒内到期的临时授权，按到期时间排列 */
+func GetExpiringGrants(within int) ([]*ResGrant, error) {
+	var grants []*SpManagerGrant
+	o := orm.NewOrm()
+	_, err := activeGrants(o).
+		Filter("expire_time__lte", time.Now().Unix()+int64(within)).
+		OrderBy("expire_time").
+		All(&grants)
+	if err != nil {
+		return nil, err
+	}
+	return resGrants(grants)
+}
+
+/* 定时任务：把已到期的临时授权标记为已过期 */
+func SweepExpiredGrants() error {
+	// 失效时间就是到期时间
+	sqlStr := `
+		UPDATE
+			sp_manager_grant
+		SET
+			status = ?, end_time = expire_time
+		WHERE
+			status = ? AND expire_time <= ?`
+	o := orm.NewOrm()
+	result, err := o.Raw(sqlStr, GrantExpired, GrantActive, time.Now().Unix()).Exec()
+	if err != nil {
+		logs.Error("标记过期的临时授权出错", err)
+		return err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		logs.Error("标记过期的临时授权出错", err)
+		return err
+	}
+	if num > 0 {
+		logs.Info("已标记", num, "条过期的临时授权")
+	}
+	return nil
+}
+
+// 补充管理员名称、角色或权限名称，计算距离到期的时间
+func resGrants(grants []*SpManagerGrant) ([]*ResGrant, error) {
+	resList := make([]*ResGrant, 0, len(grants))
+	if len(grants) == 0 {
+		return resList, nil
+	}
+	o := orm.NewOrm()
+	var managers []SpManager
+	_, err := o.QueryTable("sp_manager").All(&managers, "MgId", "MgName")
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(managers))
+	for _, m := range managers {
+		names[m.MgId] = m.MgName
+	}
+	roles, err := loadRoles()
+	if err != nil {
+		return nil, err
+	}
+	var permissions []SpPermission
+	_, err = o.QueryTable("sp_permission").All(&permissions, "PsId", "PsName")
+	if err != nil {
+		return nil, err
+	}
+	psNames := make(map[int]string, len(permissions))
+	for _, p := range permissions {
+		psNames[p.PsId] = p.PsName
+	}
+
+	now := int(time.Now().Unix())
+	for _, g := range grants {
+		res := ResGrant{Id: g.Id, MgId: g.MgId, UserName: names[g.MgId], GrantType: g.GrantType, TargetId: g.TargetId,
+			Status: g.Status, GrantedBy: g.GrantedBy, GrantTime: g.GrantTime, ExpireTime: g.ExpireTime, EndTime: g.EndTime}
+		if g.GrantType == GrantRole {
+			res.TargetName = roles[g.TargetId].RoleName
+		} else {
+			res.TargetName = psNames[g.TargetId]
+		}
+		// 已到期但定时任务还没有处理的授权同样显示为已过期
+		if res.Status == GrantActive && res.ExpireTime <= now {
+			res.Status = GrantExpired
+		}
+		if res.Status == GrantActive {
+			res.ExpiresIn = res.ExpireTime - now
+		}
+		resList = append(resList, &res)
+	}
+	return resList, nil
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpManagerGrant))
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"JDStore/utils"
+	"errors"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"time"
+)
+
+// sp_manager.mg_source：管理员账号的来源。本地账号使用sp_manager中的密码登录（空字符串同样表示本地账号），
+// 其他来源的账号在第一次通过外部身份提供方登录时自动创建，不能使用本地密码登录
+const SourceLocal = "local"
+
+var (
+	// 外部身份的用户名已被其他来源的账号使用
+	ErrManagerNameTaken = errors.New("用户名已被其他账号使用")
+	// 外部身份的用户组没有对应任何角色
+	ErrNoMappedRole = errors.New("没有为该用户分配角色")
+)
+
+/* 定义获取post参数的结构体（login/oidc接口），code和state是身份提供方跳转回前端时带回的参数 */
+type OidcLoginParams struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+/* 获取OpenID Connect授权地址时返回数据中data的结构 接口：login/oidc 请求方式：get */
+type ResOidcLoginData struct {
+	Url   string `json:"url"`
+	State string `json:"state"`
+}
+
+/* 获取OpenID Connect授权地址时返回数据的结构 */
+type ResOidcLogin struct {
+	Data *ResOidcLoginData `json:"data"`
+	Meta *ResMeta          `json:"meta"`
+}
+
+// 判断管理员是否是本地账号
+func (this *SpManager) IsLocal() bool {
+	return this.MgSource == "" || this.MgSource == SourceLocal
+}
+
+/* 外部身份登录时创建或更新对应的管理员（just-in-time provisioning）。
+*  管理员按来源和外部标识对应，角色每次登录时与身份提供方的用户组同步。roleIds不能为空：
+*  没有角色的管理员role_id为0，会被当作超级管理员 */
+func ProvisionExternalManager(source, subject, userName, email, mobile string, roleIds []int) (*SpManager, error) {
+	o := orm.NewOrm()
+	// 忽略配置中已不存在的角色
+	var roles []SpRole
+	if len(roleIds) > 0 {
+		_, err := o.QueryTable("sp_role").Filter("role_id__in", roleIds).All(&roles, "RoleId")
+		if err != nil {
+			return nil, err
+		}
+	}
+	exists := make(map[int]bool, len(roles))
+	for _, role := range roles {
+		exists[role.RoleId] = true
+	}
+	var validIds []int
+	for _, roleId := range uniqueInts(roleIds) {
+		if exists[roleId] {
+			validIds = append(validIds, roleId)
+		} else {
+			logs.Warn("用户组对应的角色不存在", roleId)
+		}
+	}
+	if len(validIds) == 0 {
+		return nil, ErrNoMappedRole
+	}
+
+	manager := SpManager{MgSource: source, MgSubject: subject}
+	err := o.Read(&manager, "MgSource", "MgSubject")
+	if err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+	isNew := err == orm.ErrNoRows
+	// 已删除的外部账号不能通过再次登录重新创建
+	if !isNew && manager.IsDeleted() {
+		return nil, ErrManagerDeleted
+	}
+	if isNew && o.QueryTable("sp_manager").Filter("mg_name", userName).Exist() {
+		return nil, ErrManagerNameTaken
+	}
+	// 角色没有变化时不修改权限版本号，否则每次登录都会使该管理员其他会话的token失效
+	rolesChanged := true
+	if !isNew {
+		var current []SpManagerRole
+		_, err = o.QueryTable("sp_manager_role").Filter("mg_id", manager.MgId).OrderBy("id").All(&current, "RoleId")
+		if err != nil {
+			return nil, err
+		}
+		rolesChanged = manager.RoleId != validIds[0] || len(current) != len(validIds)
+		for i := 0; !rolesChanged && i < len(current); i++ {
+			rolesChanged = current[i].RoleId != validIds[i]
+		}
+	}
+
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if isNew {
+		// 外部账号不使用本地密码，保存一个随机密码的hash
+		pwd, err := utils.RandomToken()
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		manager = SpManager{MgName: userName, MgPwd: utils.HashAndSalt(pwd), MgTime: int(time.Now().Unix()),
+			RoleId: validIds[0], MgMobile: mobile, MgEmail: email, MgState: 1, MgType: ManagerHuman,
+			MgSource: source, MgSubject: subject}
+		_, err = o.Insert(&manager)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	} else {
+		// 联系方式以身份提供方为准，提供方没有返回时保留原来的值
+		if email != "" {
+			manager.MgEmail = email
+		}
+		if mobile != "" {
+			manager.MgMobile = mobile
+		}
+		manager.RoleId = validIds[0]
+		_, err = o.Update(&manager, "MgEmail", "MgMobile", "RoleId")
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	if !rolesChanged {
+		err = o.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return &manager, nil
+	}
+	if !isNew {
+		// 用户组变化后角色随之变化，之前签发的token需要刷新
+		err = bumpManagerVersion(o, manager.MgId)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		_, err = o.QueryTable("sp_manager_role").Filter("mg_id", manager.MgId).Delete()
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	managerRoles := make([]SpManagerRole, 0, len(validIds))
+	for _, roleId := range validIds {
+		managerRoles = append(managerRoles, SpManagerRole{MgId: manager.MgId, RoleId: roleId})
+	}
+	_, err = o.InsertMulti(len(managerRoles), managerRoles)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	err = o.Commit()
+	if err != nil {
+		return nil, err
+	}
+	if !isNew {
+		forgetManagerVersion(manager.MgId)
+	}
+	return &manager, nil
+}
@@ -7,31 +7,52 @@ import (
 
 type SpManager struct {
 	/*pk以为当前字段为主键,auto意为自动增长,数据库表默认为 NOT NULL，设置 null 代表 ALLOW NULL*/
-	MgId     int    `json:"mg_id" orm:"column(mg_id);pk;auto;size(11)" description:"主键id"`
-	MgName   string `json:"mg_name" orm:"column(mg_name);size(32)" description:"管理员名称"`
-	MgPwd    string `json:"mg_pwd" orm:"column(mg_pwd);size(64);type(char)" description:"管理员密码"`
-	MgTime   int    `json:"mg_time" orm:"column(mg_time);size(10)" description:"注册时间"`
-	RoleId   int8   `json:"role_id" orm:"column(role_id);size(11)" description:"角色id"`
-	MgMobile string `json:"mg_mobile" orm:"column(mg_mobile);size(32);null" description:"管理员手机号"`
-	MgEmail  string `json:"mg_email" orm:"column(mg_email);size(64);null" description:"管理员邮箱地址"`
-	MgState  int8   `json:"mg_state" orm:"column(mg_state);size(2);null" description:"1:表示启用 0:表示禁用"`
+	MgId      int    `json:"mg_id" orm:"column(mg_id);pk;auto;size(11)" description:"主键id"`
+	MgName    string `json:"mg_name" orm:"column(mg_name);size(32)" description:"管理员名称"`
+	MgPwd     string `json:"mg_pwd" orm:"column(mg_pwd);size(64);type(char)" description:"管理员密码"`
+	MgTime    int    `json:"mg_time" orm:"column(mg_time);size(10)" description:"注册时间"`
+	RoleId    int    `json:"role_id" orm:"column(role_id);size(11)" description:"角色id"`
+	MgMobile  string `json:"mg_mobile" orm:"column(mg_mobile);size(32);null" description:"管理员手机号"`
+	MgEmail   string `json:"mg_email" orm:"column(mg_email);size(64);null" description:"管理员邮箱地址"`
+	MgState   int8   `json:"mg_state" orm:"column(mg_state);size(2);null" description:"1:表示启用 0:表示禁用"`
+	MgType    int8   `json:"mg_type" orm:"column(mg_type);size(2);default(0)" description:"0:管理员 1:服务账号"`
+	MgSource  string `json:"mg_source" orm:"column(mg_source);size(16);default(local)" description:"账号来源：local、ldap、oidc"`
+	MgSubject string `json:"-" orm:"column(mg_subject);size(255);null" description:"外部身份提供方中的用户标识"`
+	/* 角色或临时授权变化时加1，该管理员已签发的access token随之失效 */
+	PermVersion int `json:"-" orm:"column(perm_version);default(0)" description:"权限版本号"`
+	/* 删除管理员时只记录删除时间，见managerDelete.go */
+	DeletedAt int `json:"deleted_at" orm:"column(deleted_at);default(0)" description:"删除时间，0表示未删除"`
+	/* 最后一次登录成功的时间和IP，见loginAttempt.go */
+	LastLoginAt int    `json:"last_login_at" orm:"column(last_login_at);default(0)" description:"最后登录时间，0表示从未登录"`
+	LastLoginIp string `json:"last_login_ip" orm:"column(last_login_ip);size(64)" description:"最后登录IP"`
+	/* 长期未登录时由定时任务标记，再次登录后清除，见managerInactive.go */
+	InactiveAt int `json:"inactive_at" orm:"column(inactive_at);default(0)" description:"标记为长期未登录的时间，0表示未标记"`
 }
 
 /*定义login接口返回响应数据中的data结构*/
 type ResData struct {
 	Id       int    `json:"id"`
-	Rid      int8   `json:"rid"`
+	Rid      int    `json:"rid"`
 	Username string `json:"username"`
 	Mobile   string `json:"mobile"`
 	Email    string `json:"email"`
 	Token    string `json:"token"`
+	/* 用于在token过期后换取新token（接口：login/refresh） */
+	RefreshToken string `json:"refresh_token"`
+	/* 登录时完成两步验证绑定的情况下返回恢复码，只返回这一次 */
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 /* 定义获取post参数的结构体（login接口） */
 type GetParams struct {
 	/* 这里使用StructTag进行表单验证。详细介绍参见：https://www.jianshu.com/p/37abab5808bb */
 	UserName string `valid:"Required;MinSize(3);MaxSize(10)"`
-	PassWord string `valid:"Required;MinSize(6);MaxSize(15)"`
+	PassWord string `valid:"Required;MinSize(6);MaxSize(32)"`
+}
+
+/* 定义获取post参数的结构体（login/refresh接口、logout接口） */
+type RefreshParams struct {
+	RefreshToken string `json:"refresh_token" valid:"Required"`
 }
 
 /*定义响应数据中的meta结构*/
@@ -69,6 +90,7 @@ type SpPermissionApi struct {
 /*定义菜单接口menus的返回数据data中的结构*/
 type Menus struct {
 	PsId      int     `json:"id" orm:"column(ps_id)"`
+	PsPid     int     `json:"-" orm:"column(ps_pid)"`
 	PsName    string  `json:"authName" orm:"column(ps_name)"`
 	PsApiPath string  `json:"path" orm:"column(ps_api_path)"`
 	Children  []Menus `json:"children"`
@@ -82,50 +104,54 @@ type ResMenus struct {
 	Meta *ResMeta `json:"meta"`
 }
 
-/* 查询出所有的一级菜单和二级菜单，query是查询参数 */
-func TestOneToOne(level int, query int) ([]Menus, error) {
+/* 一次查询出所有的一级菜单和二级菜单，只保留管理员所属角色拥有的菜单（超级管理员拥有全部菜单），按ps_api_order排序 */
+func GetMenus(manager *SpManager) ([]Menus, error) {
 	o := orm.NewOrm()
-	/* sql语句1：找出所有的一级菜单 */
-	sqlStr1 := `
-		SELECT
-			t1.ps_id, t1.ps_name, t2.ps_api_path, t2.ps_api_order
-		FROM
-			sp_permission t1 JOIN sp_permission_api t2 
-		ON 
-			t1.ps_id = t2.ps_id 
-		WHERE
-			t1.ps_pid = ?
-		ORDER BY
-			t2.ps_api_order`
-
-	/* sql语句2：根据一级菜单的ps_id找出相应的二级菜单（只需更改一下查询条件即可） */
-	sqlStr2 := `
+	/* 一级菜单的ps_pid为0，二级菜单的ps_level为1 */
+	sqlStr := `
 		SELECT
-			t1.ps_id, t1.ps_name, t2.ps_api_path, t2.ps_api_order
+			t1.ps_id, t1.ps_pid, t1.ps_name, t2.ps_api_path, t2.ps_api_order
 		FROM
 			sp_permission t1 JOIN sp_permission_api t2 
 		ON 
 			t1.ps_id = t2.ps_id 
 		WHERE
-			t1.ps_pid = ? AND t1.ps_level = "1"
+			t1.ps_pid = 0 OR t1.ps_level = "1"
 		ORDER BY
 			t2.ps_api_order`
+	var rows []Menus
+	_, err := o.Raw(sqlStr).QueryRows(&rows)
+	if err != nil {
+		logs.Error(err)
+		return nil, err
+	}
 
-	sqlMap := map[int]string{1: sqlStr1, 2: sqlStr2}
-	var menu []Menus
-	/* 根据菜单等级选择最终执行的相应sql语句 */
-	_, err := o.Raw(sqlMap[level], query).QueryRows(&menu)
+	/* 超级管理员不需要过滤 */
+	rights, err := ManagerPermissionIds(manager)
 	if err != nil {
 		logs.Error(err)
 		return nil, err
 	}
-	/* children字段为空时，返回给前台空切片 */
-	for i := range menu {
-		if menu[i].Children == nil {
-			menu[i].Children = []Menus{}
+
+	/* 先找出一级菜单，再把二级菜单挂到对应的一级菜单下，两者都保持查询结果中的顺序 */
+	menus := []Menus{}
+	index := make(map[int]int)
+	for _, menu := range rows {
+		if menu.PsPid == 0 && (rights == nil || rights[menu.PsId]) {
+			/* children字段为空时，返回给前台空切片 */
+			menu.Children = []Menus{}
+			index[menu.PsId] = len(menus)
+			menus = append(menus, menu)
+		}
+	}
+	for _, menu := range rows {
+		i, ok := index[menu.PsPid]
+		if menu.PsPid != 0 && ok && (rights == nil || rights[menu.PsId]) {
+			menu.Children = []Menus{}
+			menus[i].Children = append(menus[i].Children, menu)
 		}
 	}
-	return menu, nil
+	return menus, nil
 }
 
 //初始化模型
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"time"
+)
+
+// 登录尝试的结果
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	// 因失败次数过多被拒绝的尝试，只做记录，不计入失败次数
+	LoginBlocked = "blocked"
+)
+
+// 数据库中sp_login_attempt表的模型，记录每一次登录尝试，供安全审查使用
+type SpLoginAttempt struct {
+	Id          int    `json:"id" orm:"pk;auto"`
+	MgId        int    `json:"mg_id" description:"用户名对应的管理员id，用户名不存在时为0"`
+	MgName      string `json:"username" orm:"size(32)" description:"登录时填写的用户名（可能不存在）"`
+	Ip          string `json:"ip" orm:"size(64)" description:"客户端IP"`
+	UserAgent   string `json:"user_agent" orm:"size(255)" description:"登录设备（User-Agent）"`
+	Result      string `json:"result" orm:"size(16)" description:"success、failure、blocked"`
+	Cleared     int8   `json:"-" description:"1:登录成功或管理员解锁后，之前的失败记录不再计入锁定次数"`
+	AttemptTime int    `json:"attempt_time" description:"尝试时间"`
+}
+
+/* 获取管理员登录记录时返回数据的data结构 */
+type ResLoginAttemptsData struct {
+	Total   int64             `json:"total"`
+	PageNum int               `json:"pagenum"`
+	Logins  []*SpLoginAttempt `json:"logins"`
+}
+
+/* 获取管理员登录记录时返回数据的结构 */
+type ResLoginAttempts struct {
+	Data *ResLoginAttemptsData `json:"data"`
+	Meta *ResMeta              `json:"meta"`
+}
+
+// 记录一次登录尝试。登录成功时，清除该用户名之前的失败次数，并记录管理员的最后登录时间和IP
+func RecordLoginAttempt(userName, ip, userAgent, result string) error {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := int(time.Now().Unix())
+	attempt := SpLoginAttempt{MgName: userName, Ip: ip, UserAgent: userAgent, Result: result, AttemptTime: now}
+	o := orm.NewOrm()
+	var manager SpManager
+	err := o.QueryTable("sp_manager").Filter("mg_name", userName).One(&manager, "MgId")
+	if err == nil {
+		attempt.MgId = manager.MgId
+	} else if err != orm.ErrNoRows {
+		return err
+	}
+	_, err = o.Insert(&attempt)
+	if err != nil {
+		return err
+	}
+	if result != LoginSuccess {
+		return nil
+	}
+	// 登录后不再是长期未登录的管理员
+	_, err = o.QueryTable("sp_manager").Filter("mg_id", attempt.MgId).
+		Update(orm.Params{"last_login_at": now, "last_login_ip": ip, "inactive_at": 0})
+	if err != nil {
+		return err
+	}
+	return UnlockManager(userName)
+}
+
+/* 分页查询管理员的登录记录（包括失败和被拒绝的尝试），按时间倒序 */
+func GetManagerLoginAttempts(mgId, pageNum, pageSize int) (*ResLoginAttemptsData, error) {
+	qs := orm.NewOrm().QueryTable("sp_login_attempt").Filter("mg_id", mgId)
+	total, err := qs.Count()
+	if err != nil {
+		return nil, err
+	}
+	attempts := []*SpLoginAttempt{}
+	_, err = qs.OrderBy("-id").Limit(pageSize, (pageNum-1)*pageSize).All(&attempts)
+	if err != nil {
+		return nil, err
+	}
+	return &ResLoginAttemptsData{total, pageNum, attempts}, nil
+}
+
+// 解除用户名的登录锁定（清除该用户名的失败次数），不影响按IP的限制
+func UnlockManager(userName string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_login_attempt").
+		Filter("mg_name", userName).
+		Filter("cleared", 0).
+		Update(orm.Params{"cleared": 1})
+	return err
+}
+
+// 检查用户名和IP当前是否允许登录，返回还需等待的秒数，0表示允许登录
+func CheckLoginAllowed(userName, ip string) (int, error) {
+	windowStart := int(time.Now().Unix()) - beego.AppConfig.DefaultInt("LoginFailWindow", 30)*60
+
+	o := orm.NewOrm()
+	userQs := o.QueryTable("sp_login_attempt").
+		Filter("mg_name", userName).
+		Filter("result", LoginFailure).
+		Filter("cleared", 0).
+		Filter("attempt_time__gt", windowStart)
+	wait, err := loginWaitSeconds(userQs, beego.AppConfig.DefaultInt("LoginMaxFailures", 5))
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	ipQs := o.QueryTable("sp_login_attempt").
+		Filter("ip", ip).
+		Filter("result", LoginFailure).
+		Filter("attempt_time__gt", windowStart)
+	return loginWaitSeconds(ipQs, beego.AppConfig.DefaultInt("LoginIpMaxFailures", 20))
+}
+
+// 根据时间窗口内的失败记录计算需要等待的秒数：
+// 失败次数超过LoginDelayAfter后，每次失败的等待时间翻倍（最多60秒）；达到maxFailures后锁定LoginLockMinutes分钟
+func loginWaitSeconds(qs orm.QuerySeter, maxFailures int) (int, error) {
+	failures, err := qs.Count()
+	if err != nil {
+		return 0, err
+	}
+	delay := loginDelaySeconds(failures, maxFailures)
+	if delay == 0 {
+		return 0, nil
+	}
+	var last SpLoginAttempt
+	err = qs.OrderBy("-attempt_time").Limit(1).One(&last)
+	if err != nil {
+		return 0, err
+	}
+	wait := last.AttemptTime + delay - int(time.Now().Unix())
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, nil
+}
+
+// 失败failures次后，从最后一次失败开始需要等待的秒数，0表示不需要等待
+func loginDelaySeconds(failures int64, maxFailures int) int {
+	delayAfter := int64(beego.AppConfig.DefaultInt("LoginDelayAfter", 2))
+	if failures <= delayAfter {
+		return 0
+	}
+	if failures >= int64(maxFailures) {
+		return beego.AppConfig.DefaultInt("LoginLockMinutes", 15) * 60
+	}
+	delay := 1 << uint(failures-delayAfter)
+	if delay > 60 {
+		delay = 60
+	}
+	return delay
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpLoginAttempt))
+}
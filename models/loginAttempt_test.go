@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/astaxie/beego"
+	"strconv"
+	"testing"
+)
+
+func TestLoginDelaySeconds(t *testing.T) {
+	cases := []struct {
+		delayAfter  int
+		lockMinutes int
+		failures    int64
+		maxFailures int
+		want        int
+	}{
+		// 前LoginDelayAfter次失败不需要等待
+		{2, 15, 0, 5, 0},
+		{2, 15, 2, 5, 0},
+		// 之后每次失败等待时间翻倍
+		{2, 15, 3, 5, 2},
+		{2, 15, 4, 5, 4},
+		// 达到maxFailures后锁定
+		{2, 15, 5, 5, 900},
+		{2, 15, 9, 5, 900},
+		{2, 1, 20, 20, 60},
+		// 翻倍后最多等待60秒
+		{2, 15, 8, 20, 60},
+		{2, 15, 19, 20, 60},
+		{0, 15, 1, 20, 2},
+		{0, 15, 5, 20, 32},
+		{0, 15, 6, 20, 60},
+		// LoginDelayAfter不小于maxFailures时，超过LoginDelayAfter次才锁定
+		{5, 15, 5, 5, 0},
+		{5, 15, 6, 5, 900},
+	}
+	for _, c := range cases {
+		beego.AppConfig.Set("LoginDelayAfter", strconv.Itoa(c.delayAfter))
+		beego.AppConfig.Set("LoginLockMinutes", strconv.Itoa(c.lockMinutes))
+		if got := loginDelaySeconds(c.failures, c.maxFailures); got != c.want {
+			t.Errorf("LoginDelayAfter=%d LoginLockMinutes=%d loginDelaySeconds(%d, %d) = %d, want %d",
+				c.delayAfter, c.lockMinutes, c.failures, c.maxFailures, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"time"
+)
+
+// 删除管理员只是标记deleted_at（软删除），操作记录等引用管理员id的数据仍能查到是谁；
+// 删除后可以恢复，超过保留期（配置项ManagerRetentionDays）后才能彻底删除
+
+var (
+	ErrManagerDisabled = errors.New("账号已被禁用")
+	ErrManagerDeleted  = errors.New("账号已被删除")
+)
+
+// 判断管理员是否已被删除
+func (this *SpManager) IsDeleted() bool {
+	return this.DeletedAt > 0
+}
+
+// 校验管理员是否可以登录：已删除或已禁用的管理员不能登录
+func (this *SpManager) CheckActive() error {
+	if this.IsDeleted() {
+		return ErrManagerDeleted
+	}
+	if this.MgState != 1 {
+		return ErrManagerDisabled
+	}
+	return nil
+}
+
+/* 读取未删除的管理员，管理员不存在时返回orm.ErrNoRows，已删除时返回ErrManagerDeleted。
+*  已删除的管理员只能恢复或彻底删除，不能修改状态、信息、角色等 */
+func ReadUndeletedManager(mgId int) (*SpManager, error) {
+	manager := SpManager{MgId: mgId}
+	err := orm.NewOrm().Read(&manager)
+	if err != nil {
+		return nil, err
+	}
+	if manager.IsDeleted() {
+		return nil, ErrManagerDeleted
+	}
+	return &manager, nil
+}
+
+// 已删除的管理员保留的天数，超过后才能彻底删除
+func ManagerRetentionDays() int {
+	return beego.AppConfig.DefaultInt("ManagerRetentionDays", 30)
+}
+
+// 在调用方的事务中删除管理员（软删除）
+func softDeleteManager(o orm.Ormer, mgId int) error {
+	num, err := o.QueryTable("sp_manager").Filter("mg_id", mgId).Filter("deleted_at", 0).
+		Update(orm.Params{"deleted_at": time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		if o.QueryTable("sp_manager").Filter("mg_id", mgId).Exist() {
+			return errors.New("管理员已被删除")
+		}
+		return errors.New("管理员不存在")
+	}
+	return nil
+}
+
+/* 删除管理员（软删除） */
+func DeleteManager(mgId int) error {
+	return softDeleteManager(orm.NewOrm(), mgId)
+}
+
+/* 恢复已删除的管理员，恢复后账号的启用状态与删除前相同 */
+func RestoreManager(mgId int) (*SpManager, error) {
+	o := orm.NewOrm()
+	manager := SpManager{MgId: mgId}
+	err := o.Read(&manager)
+	if err != nil {
+		return nil, errors.New("管理员不存在")
+	}
+	if !manager.IsDeleted() {
+		return nil, errors.New("管理员未被删除")
+	}
+	manager.DeletedAt = 0
+	_, err = o.Update(&manager, "DeletedAt")
+	if err != nil {
+		return nil, err
+	}
+	return &manager, nil
+}
+
+/* 彻底删除管理员，只能删除已删除超过保留期的管理员。管理员的角色、临时授权、会话等随之删除（外键级联） */
+func PurgeManager(mgId int) error {
+	o := orm.NewOrm()
+	manager := SpManager{MgId: mgId}
+	err := o.Read(&manager)
+	if err != nil {
+		return errors.New("管理员不存在")
+	}
+	if !manager.IsDeleted() {
+		return errors.New("管理员未被删除，不能彻底删除")
+	}
+	purgeTime := time.Unix(int64(manager.DeletedAt), 0).AddDate(0, 0, ManagerRetentionDays())
+	if time.Now().Before(purgeTime) {
+		return errors.New("管理员删除后需要保留到" + purgeTime.Format("2006-01-02 15:04") + "才能彻底删除")
+	}
+	// 只删除仍处于已删除状态的管理员，防止与恢复操作并发时删除了刚恢复的管理员
+	num, err := o.QueryTable("sp_manager").Filter("mg_id", mgId).Filter("deleted_at", manager.DeletedAt).Delete()
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		return errors.New("管理员已被恢复或彻底删除")
+	}
+	return nil
+}
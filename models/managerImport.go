@@ -0,0 +1,404 @@
+package models
+
+import (
+	"JDStore/utils"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 批量导入管理员：文件第一行是表头，列的顺序不限，不认识的列忽略。
+// 所有行都校验通过才导入，导入在一个事务中完成，有任何一行出错都不会导入。
+// 角色可以用角色id（role_ids列）或角色名称（role列）指定，两列都有值时以角色id为准。导出的文件可以直接导入，
+// 但超级管理员（没有角色）不能通过导入创建，导出文件中的超级管理员需要删除后再导入
+
+// 导入文件的列名，英文列名与导出文件相同，也可以使用中文列名
+var managerImportColumns = map[string]string{
+	"username": "username", "用户名": "username",
+	"email": "email", "邮箱": "email",
+	"mobile": "mobile", "手机号": "mobile",
+	"role": "role", "角色": "role",
+	"role_ids": "role_ids", "角色id": "role_ids",
+	"state": "state", "mg_state": "state", "状态": "state",
+}
+
+// 必须有的列，state可以省略，默认启用；role和role_ids至少要有一列
+var managerImportRequired = []string{"username", "email", "mobile"}
+
+// 导出文件的列。role是用逗号连接的角色名称（便于阅读，角色名称中可能有逗号），role_ids是用分号连接的角色id
+var ManagerExportColumns = []string{"id", "username", "email", "mobile", "role", "role_ids", "state", "create_time",
+	"deleted_at", "last_login_at", "last_login_ip"}
+
+// 超级管理员在导出文件中的角色名称
+const superAdminRoleName = "超级管理员"
+
+/* 导入文件中的一行管理员数据 */
+type ManagerImportRow struct {
+	Row       int      `json:"row"`
+	Id        int      `json:"id"`
+	UserName  string   `json:"username"`
+	Email     string   `json:"email"`
+	Mobile    string   `json:"mobile"`
+	RoleNames []string `json:"role_names"`
+	MgState   int8     `json:"mg_state"`
+	/* 是否已发送重置密码通知，发送失败时需要为该管理员重新发送 */
+	Notified bool `json:"notified"`
+	roleIds  []int
+}
+
+/* 导入文件中某一行的错误，Row为文件中的行号（从1开始，第1行是表头） */
+type ManagerImportError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+/* 导入管理员（users/import接口，post请求）返回的数据中data格式 */
+type ResImportUsersData struct {
+	Total  int                  `json:"total"`
+	DryRun bool                 `json:"dry_run"`
+	Errors []ManagerImportError `json:"errors"`
+	Users  []*ManagerImportRow  `json:"users"`
+}
+
+/* 导入管理员返回的数据格式 */
+type ResImportUsers struct {
+	Data *ResImportUsersData `json:"data"`
+	Meta *ResUsersMeta       `json:"meta"`
+}
+
+// 导入文件的最大行数（不含表头）
+func ManagerImportMaxRows() int {
+	return beego.AppConfig.DefaultInt("ManagerImportMaxRows", 500)
+}
+
+// 导入文件的最大字节数
+func ManagerImportMaxSize() int64 {
+	return beego.AppConfig.DefaultInt64("ManagerImportMaxSize", 2<<20)
+}
+
+// 导入的管理员的初始密码：随机生成且不告知任何人，管理员通过重置密码通知设置自己的密码
+func RandomInitialPassword() (string, error) {
+	token, err := utils.RandomToken()
+	if err != nil {
+		return "", err
+	}
+	// 随机令牌只有小写字母和数字，加上大写字母和特殊字符使其符合密码策略
+	return "Jd#" + token[:24], nil
+}
+
+// 解析状态列，为空时默认启用
+func parseImportState(value string) (int8, bool) {
+	switch strings.ToLower(value) {
+	case "", "1", "true", "enabled", "启用":
+		return 1, true
+	case "0", "false", "disabled", "禁用":
+		return 0, true
+	}
+	return 0, false
+}
+
+// 拆分角色列，多个角色用逗号或分号分隔，第一个是主角色
+func splitImportRoles(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '，' || r == ';' || r == '；'
+	})
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if name := strings.TrimSpace(field); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 拆分角色id列，多个id用分号或逗号分隔，第一个是主角色。有不是数字的id时第二个返回值为false
+func splitImportRoleIds(value string) ([]int, bool) {
+	var ids []int
+	for _, field := range splitImportRoles(value) {
+		id, err := strconv.Atoi(field)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// 导入时使用的角色：按名称查找角色id（名称重复的角色无法通过名称确定，对应的id为0），按id查找角色名称
+type importRoles struct {
+	ids   map[string]int
+	names map[int]string
+}
+
+func newImportRoles(roles []SpRole) *importRoles {
+	result := &importRoles{make(map[string]int, len(roles)), make(map[int]string, len(roles))}
+	for _, role := range roles {
+		result.names[role.RoleId] = role.RoleName
+		if _, ok := result.ids[role.RoleName]; ok {
+			result.ids[role.RoleName] = 0
+		} else {
+			result.ids[role.RoleName] = role.RoleId
+		}
+	}
+	return result
+}
+
+// 查询所有角色
+func loadImportRoles() (*importRoles, error) {
+	var roles []SpRole
+	_, err := orm.NewOrm().QueryTable("sp_role").All(&roles, "RoleId", "RoleName")
+	if err != nil {
+		return nil, err
+	}
+	return newImportRoles(roles), nil
+}
+
+// 已存在的用户名（包括已删除的管理员和服务账号），用户名不区分大小写
+func existingManagerNames(names []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	// 分批查询，避免IN中的参数过多
+	for start := 0; start < len(names); start += 100 {
+		end := start + 100
+		if end > len(names) {
+			end = len(names)
+		}
+		var managers []SpManager
+		_, err := orm.NewOrm().QueryTable("sp_manager").Filter("mg_name__in", names[start:end]).All(&managers, "MgName")
+		if err != nil {
+			return nil, err
+		}
+		for _, manager := range managers {
+			existing[strings.ToLower(manager.MgName)] = true
+		}
+	}
+	return existing, nil
+}
+
+/* 解析并校验导入文件的内容，table为ReadTable读取的所有行（行数由ReadTable限制），pwd为初始密码。
+*  每一行使用与CreateUser相同的规则校验，另外校验用户名不能重复、角色必须存在。返回校验通过的行和所有错误 */
+func ParseManagerImport(table [][]string, pwd string) ([]*ManagerImportRow, []ManagerImportError, error) {
+	roles, err := loadImportRoles()
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, errs, err := parseManagerTable(table, pwd, roles)
+	if err != nil || len(rows) == 0 {
+		return rows, errs, err
+	}
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.UserName)
+	}
+	existing, err := existingManagerNames(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, errs = checkImportNames(rows, errs, existing)
+	return rows, errs, nil
+}
+
+// 逐行解析并校验导入文件的内容（不查询数据库），返回校验通过的行和所有错误
+func parseManagerTable(table [][]string, pwd string, roles *importRoles) ([]*ManagerImportRow, []ManagerImportError, error) {
+	errs := []ManagerImportError{}
+	if len(table) == 0 {
+		errs = append(errs, ManagerImportError{1, "", "文件内容为空"})
+		return nil, errs, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range table[0] {
+		if column, ok := managerImportColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[column] = i
+		}
+	}
+	for _, column := range managerImportRequired {
+		if _, ok := columns[column]; !ok {
+			errs = append(errs, ManagerImportError{1, column, "缺少" + column + "列"})
+		}
+	}
+	_, hasRole := columns["role"]
+	_, hasRoleIds := columns["role_ids"]
+	if !hasRole && !hasRoleIds {
+		errs = append(errs, ManagerImportError{1, "role", "缺少role或role_ids列"})
+	}
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	rows := []*ManagerImportRow{}
+	for i, record := range table[1:] {
+		cell := func(column string) string {
+			index, ok := columns[column]
+			if !ok || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+		row := &ManagerImportRow{Row: i + 2, UserName: cell("username"), Email: cell("email"),
+			Mobile: cell("mobile"), RoleNames: splitImportRoles(cell("role"))}
+		// 跳过空行
+		if row.UserName == "" && row.Email == "" && row.Mobile == "" && len(row.RoleNames) == 0 &&
+			cell("role_ids") == "" && cell("state") == "" {
+			continue
+		}
+		rowErrs := []ManagerImportError{}
+
+		// 与添加管理员（users接口post请求）使用相同的校验规则
+		user := CreateUser{UserName: row.UserName, PassWord: pwd, ConfirmPwd: pwd, Email: row.Email, Mobile: row.Mobile}
+		valid := validation.Validation{}
+		_, err := valid.Valid(&user)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, err := range valid.Errors {
+			rowErrs = append(rowErrs, ManagerImportError{row.Row, err.Field,
+				fmt.Sprintf("错误字段：%s，错误信息：%s", err.Field, err.Message)})
+		}
+
+		var ok bool
+		row.MgState, ok = parseImportState(cell("state"))
+		if !ok {
+			rowErrs = append(rowErrs, ManagerImportError{row.Row, "state", "状态只能是1（启用）或0（禁用）"})
+		}
+		rowErrs = append(rowErrs, row.resolveRoles(cell("role_ids"), roles)...)
+
+		if len(rowErrs) > 0 {
+			errs = append(errs, rowErrs...)
+		} else {
+			rows = append(rows, row)
+		}
+	}
+	return rows, errs, nil
+}
+
+// 确定一行的角色：role_ids有值时使用角色id，否则按名称查找。导入的管理员必须有角色，没有角色的管理员是超级管理员
+func (this *ManagerImportRow) resolveRoles(roleIds string, roles *importRoles) []ManagerImportError {
+	var errs []ManagerImportError
+	seen := make(map[int]bool)
+	addRole := func(roleId int) {
+		if !seen[roleId] {
+			seen[roleId] = true
+			this.roleIds = append(this.roleIds, roleId)
+		}
+	}
+	if roleIds != "" {
+		ids, ok := splitImportRoleIds(roleIds)
+		if !ok {
+			return append(errs, ManagerImportError{this.Row, "role_ids", "角色id格式错误"})
+		}
+		this.RoleNames = nil
+		for _, id := range ids {
+			name, exists := roles.names[id]
+			if !exists {
+				errs = append(errs, ManagerImportError{this.Row, "role_ids", "角色不存在：" + strconv.Itoa(id)})
+			} else if !seen[id] {
+				this.RoleNames = append(this.RoleNames, name)
+				addRole(id)
+			}
+		}
+		return errs
+	}
+
+	if len(this.RoleNames) == 0 {
+		return append(errs, ManagerImportError{this.Row, "role", "角色不能为空"})
+	}
+	for _, name := range this.RoleNames {
+		roleId, exists := roles.ids[name]
+		if !exists && name == superAdminRoleName {
+			errs = append(errs, ManagerImportError{this.Row, "role", "不能导入超级管理员，请删除该行"})
+		} else if !exists {
+			errs = append(errs, ManagerImportError{this.Row, "role", "角色不存在：" + name})
+		} else if roleId == 0 {
+			errs = append(errs, ManagerImportError{this.Row, "role", "有多个角色名称为" + name + "，请使用role_ids列指定角色id"})
+		} else {
+			addRole(roleId)
+		}
+	}
+	return errs
+}
+
+// 校验用户名不能与文件中的其他行以及已有的管理员（existing，小写的用户名）重复，返回没有重复的行，错误按行号排序
+func checkImportNames(rows []*ManagerImportRow, errs []ManagerImportError, existing map[string]bool) ([]*ManagerImportRow, []ManagerImportError) {
+	firstRows := make(map[string]int)
+	validRows := make([]*ManagerImportRow, 0, len(rows))
+	for _, row := range rows {
+		name := strings.ToLower(row.UserName)
+		if existing[name] {
+			errs = append(errs, ManagerImportError{row.Row, "username", "用户名已存在"})
+		} else if firstRow, ok := firstRows[name]; ok {
+			errs = append(errs, ManagerImportError{row.Row, "username", "用户名与第" + strconv.Itoa(firstRow) + "行重复"})
+		} else {
+			firstRows[name] = row.Row
+			validRows = append(validRows, row)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Row < errs[j].Row
+	})
+	return validRows, errs
+}
+
+/* 在一个事务中创建所有管理员及其角色，创建后的管理员id保存到row.Id。
+*  所有管理员的初始密码都是pwd，管理员需要通过重置密码设置自己的密码 */
+func ImportManagers(rows []*ManagerImportRow, pwd string) error {
+	if len(rows) == 0 {
+		return errors.New("没有需要导入的管理员")
+	}
+	// 密码hash的计算较慢，初始密码相同，只计算一次
+	pwdHash := utils.HashAndSalt(pwd)
+	now := int(time.Now().Unix())
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	var managerRoles []SpManagerRole
+	for _, row := range rows {
+		manager := SpManager{MgName: row.UserName, MgPwd: pwdHash, MgTime: now, RoleId: row.roleIds[0],
+			MgMobile: row.Mobile, MgEmail: row.Email, MgState: row.MgState, MgType: ManagerHuman}
+		_, err = o.Insert(&manager)
+		if err != nil {
+			o.Rollback()
+			return fmt.Errorf("第%d行导入出错：%s", row.Row, err.Error())
+		}
+		row.Id = manager.MgId
+		for _, roleId := range row.roleIds {
+			managerRoles = append(managerRoles, SpManagerRole{MgId: manager.MgId, RoleId: roleId})
+		}
+	}
+	_, err = o.InsertMulti(100, managerRoles)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	return o.Commit()
+}
+
+/* 导出时一行管理员数据，列与ManagerExportColumns对应 */
+func ManagerExportRow(user *ResUser) []string {
+	state := "0"
+	if user.MgState {
+		state = "1"
+	}
+	roleIds := make([]string, 0, len(user.RoleIds))
+	for _, roleId := range user.RoleIds {
+		roleIds = append(roleIds, strconv.Itoa(roleId))
+	}
+	return []string{strconv.Itoa(user.MgId), user.MgName, user.MgEmail, user.MgMobile, user.RoleName,
+		strings.Join(roleIds, ";"), state, exportTime(user.MgTime), exportTime(user.DeletedAt),
+		exportTime(user.LastLoginAt), user.LastLoginIp}
+}
+
+// 导出的时间，0（未删除、从未登录等）导出为空
+func exportTime(t int) string {
+	if t <= 0 {
+		return ""
+	}
+	return time.Unix(int64(t), 0).Format("2006-01-02 15:04:05")
+}
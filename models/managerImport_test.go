@@ -0,0 +1,210 @@
+package models
+
+import (
+	"JDStore/utils"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// 测试用的初始密码，符合密码策略
+const testImportPwd = "Init-Pass1"
+
+// 测试用的角色，"运营"有两个同名角色
+func testImportRoles() *importRoles {
+	return newImportRoles([]SpRole{
+		{RoleId: 30, RoleName: "主管"},
+		{RoleId: 31, RoleName: "测试角色"},
+		{RoleId: 40, RoleName: "运营"},
+		{RoleId: 41, RoleName: "运营"},
+		{RoleId: 50, RoleName: "财务,审计"},
+	})
+}
+
+// 导入结果中每一行的用户名和角色id
+type importResult struct {
+	row      int
+	userName string
+	roleIds  []int
+	state    int8
+}
+
+func importResults(rows []*ManagerImportRow) []importResult {
+	results := []importResult{}
+	for _, row := range rows {
+		results = append(results, importResult{row.Row, row.UserName, row.roleIds, row.MgState})
+	}
+	return results
+}
+
+func TestParseManagerTable(t *testing.T) {
+	cases := []struct {
+		name  string
+		table [][]string
+		rows  []importResult
+		errs  []ManagerImportError
+	}{
+		{
+			name: "英文列名",
+			table: [][]string{
+				{"username", "email", "mobile", "role", "state"},
+				{"zhangsan", "zs@example.com", "13800000001", "主管", "1"},
+				{"lisi", "ls@example.com", "13800000002", "测试角色；主管", "禁用"},
+			},
+			rows: []importResult{{2, "zhangsan", []int{30}, 1}, {3, "lisi", []int{31, 30}, 0}},
+			errs: []ManagerImportError{},
+		},
+		{
+			name: "中文列名、大小写和空格、列的顺序以及不认识的列",
+			table: [][]string{
+				{"备注", " 角色 ", "手机号", "用户名", "EMAIL", "MG_State"},
+				{"新员工", "主管", "13800000001", " zhangsan ", "zs@example.com", "false"},
+			},
+			rows: []importResult{{2, "zhangsan", []int{30}, 0}},
+			errs: []ManagerImportError{},
+		},
+		{
+			name: "缺少列",
+			table: [][]string{
+				{"username", "email", "状态"},
+			},
+			errs: []ManagerImportError{{1, "mobile", "缺少mobile列"}, {1, "role", "缺少role或role_ids列"}},
+		},
+		{
+			name:  "空文件",
+			table: [][]string{},
+			errs:  []ManagerImportError{{1, "", "文件内容为空"}},
+		},
+		{
+			name: "跳过空行，行号与文件一致",
+			table: [][]string{
+				{"username", "email", "mobile", "role"},
+				{},
+				{"", " ", "", ""},
+				{"zhangsan", "zs@example.com", "13800000001", "主管"},
+				{"lisi", "ls@example.com", "13800000002"},
+			},
+			rows: []importResult{{4, "zhangsan", []int{30}, 1}},
+			errs: []ManagerImportError{{5, "role", "角色不能为空"}},
+		},
+		{
+			name: "角色和状态错误",
+			table: [][]string{
+				{"username", "email", "mobile", "role", "state"},
+				{"zhangsan", "zs@example.com", "13800000001", "不存在", "2"},
+				{"lisi", "ls@example.com", "13800000002", "运营", ""},
+				{"wangwu", "ww@example.com", "13800000003", "超级管理员", ""},
+			},
+			rows: []importResult{},
+			errs: []ManagerImportError{
+				{2, "state", "状态只能是1（启用）或0（禁用）"},
+				{2, "role", "角色不存在：不存在"},
+				{3, "role", "有多个角色名称为运营，请使用role_ids列指定角色id"},
+				{4, "role", "不能导入超级管理员，请删除该行"},
+			},
+		},
+		{
+			name: "role_ids优先于角色名称",
+			table: [][]string{
+				{"username", "email", "mobile", "role", "role_ids"},
+				{"zhangsan", "zs@example.com", "13800000001", "运营,财务,审计", "41;50"},
+				{"lisi", "ls@example.com", "13800000002", "主管", ""},
+				{"wangwu", "ww@example.com", "13800000003", "", "30,30"},
+				{"zhaoliu", "zl@example.com", "13800000004", "主管", "30;99"},
+				{"sunqi", "sq@example.com", "13800000005", "主管", "abc"},
+			},
+			rows: []importResult{{2, "zhangsan", []int{41, 50}, 1}, {3, "lisi", []int{30}, 1},
+				{4, "wangwu", []int{30}, 1}},
+			errs: []ManagerImportError{
+				{5, "role_ids", "角色不存在：99"},
+				{6, "role_ids", "角色id格式错误"},
+			},
+		},
+	}
+	for _, c := range cases {
+		rows, errs, err := parseManagerTable(c.table, testImportPwd, testImportRoles())
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := importResults(rows); c.rows != nil && !reflect.DeepEqual(got, c.rows) {
+			t.Errorf("%s: rows = %+v, want %+v", c.name, got, c.rows)
+		}
+		if c.rows == nil && len(rows) != 0 {
+			t.Errorf("%s: rows = %+v, want none", c.name, importResults(rows))
+		}
+		if !reflect.DeepEqual(errs, c.errs) {
+			t.Errorf("%s: errs = %+v, want %+v", c.name, errs, c.errs)
+		}
+	}
+}
+
+func TestCheckImportNames(t *testing.T) {
+	rows := []*ManagerImportRow{
+		{Row: 2, UserName: "zhangsan"},
+		{Row: 3, UserName: "Admin"},
+		{Row: 5, UserName: "ZhangSan"},
+		{Row: 6, UserName: "lisi"},
+		{Row: 7, UserName: "zhangsan"},
+	}
+	errs := []ManagerImportError{{4, "role", "角色不存在：不存在"}}
+	valid, errs := checkImportNames(rows, errs, map[string]bool{"admin": true})
+	names := []string{}
+	for _, row := range valid {
+		names = append(names, row.UserName)
+	}
+	if want := []string{"zhangsan", "lisi"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("valid rows = %v, want %v", names, want)
+	}
+	want := []ManagerImportError{
+		{3, "username", "用户名已存在"},
+		{4, "role", "角色不存在：不存在"},
+		{5, "username", "用户名与第2行重复"},
+		{7, "username", "用户名与第2行重复"},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errs = %+v, want %+v", errs, want)
+	}
+}
+
+func TestManagerExportReimport(t *testing.T) {
+	users := []*ResUser{
+		{MgId: 1, MgName: "zhangsan", MgEmail: "zs@example.com", MgMobile: "13800000001", MgState: true,
+			RoleName: "运营,财务,审计", RoleIds: []int{41, 50}, MgTime: 1600000000},
+		{MgId: 2, MgName: "lisi", MgEmail: "ls@example.com", MgMobile: "13800000002", MgState: false,
+			RoleName: "主管", RoleIds: []int{30}, LastLoginAt: 1600000000, LastLoginIp: "10.0.0.1"},
+		{MgId: 500, MgName: "admin", MgEmail: "admin@example.com", MgMobile: "13800000000", MgState: true,
+			RoleName: superAdminRoleName},
+	}
+	for _, format := range []string{utils.TableCSV, utils.TableXLSX} {
+		var buf bytes.Buffer
+		writer, err := utils.NewTableWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.WriteRow(ManagerExportColumns)
+		for _, user := range users {
+			writer.WriteRow(ManagerExportRow(user))
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		table, err := utils.ReadTable(buf.Bytes(), format, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		rows, errs, err := parseManagerTable(table, testImportPwd, testImportRoles())
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		want := []importResult{{2, "zhangsan", []int{41, 50}, 1}, {3, "lisi", []int{30}, 0}}
+		if got := importResults(rows); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: rows = %+v, want %+v", format, got, want)
+		}
+		// 超级管理员没有角色id，按角色名称导入时提示不能导入
+		wantErrs := []ManagerImportError{{4, "role", "不能导入超级管理员，请删除该行"}}
+		if !reflect.DeepEqual(errs, wantErrs) {
+			t.Errorf("%s: errs = %+v, want %+v", format, errs, wantErrs)
+		}
+	}
+}
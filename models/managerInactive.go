@@ -0,0 +1,70 @@
+package models
+
+import (
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"time"
+)
+
+// 长期未登录的管理员：超过ManagerInactiveDays天没有登录（从未登录的从创建时间算起）时由定时任务标记，
+// ManagerInactiveDisable为true时同时禁用。管理员再次登录后清除标记，见RecordLoginAttempt
+
+// 多少天未登录算作长期未登录，0表示不检查
+func ManagerInactiveDays() int {
+	return beego.AppConfig.DefaultInt("ManagerInactiveDays", 90)
+}
+
+/* 定时任务：标记长期未登录的管理员，并按配置禁用。
+*  超级管理员只标记不禁用，避免所有超级管理员都被禁用后无人能够管理系统 */
+func SweepInactiveManagers() error {
+	days := ManagerInactiveDays()
+	if days <= 0 {
+		return nil
+	}
+	disable := beego.AppConfig.DefaultBool("ManagerInactiveDisable", false)
+	now := time.Now().Unix()
+	// 已标记的管理员不再处理，管理员被重新启用后在下次登录前不会再次被禁用
+	sqlStr := `
+		SELECT
+			mg_id, mg_name, role_id, last_login_at
+		FROM
+			sp_manager
+		WHERE
+			mg_type = ? AND mg_state = 1 AND deleted_at = 0 AND inactive_at = 0
+			AND GREATEST(last_login_at, mg_time) < ?`
+	o := orm.NewOrm()
+	var managers []SpManager
+	_, err := o.Raw(sqlStr, ManagerHuman, now-int64(days)*24*3600).QueryRows(&managers)
+	if err != nil {
+		logs.Error("查询长期未登录的管理员出错", err)
+		return err
+	}
+	var flagged, disabled int
+	for _, manager := range managers {
+		params := orm.Params{"inactive_at": now}
+		if disable && manager.RoleId != 0 {
+			params["mg_state"] = 0
+		}
+		// 查询后刚好登录的管理员不再标记
+		num, err := o.QueryTable("sp_manager").Filter("mg_id", manager.MgId).Filter("inactive_at", 0).
+			Filter("last_login_at", manager.LastLoginAt).Update(params)
+		if err != nil {
+			logs.Error("标记长期未登录的管理员出错", err)
+			return err
+		}
+		if num == 0 {
+			continue
+		}
+		flagged++
+		if _, ok := params["mg_state"]; ok {
+			// 禁用管理员时，吊销该管理员已签发的所有token
+			RevokeManagerTokens(manager.MgId, manager.MgName)
+			disabled++
+		}
+	}
+	if flagged > 0 {
+		logs.Info("已标记", flagged, "个长期未登录的管理员，其中禁用", disabled, "个")
+	}
+	return nil
+}
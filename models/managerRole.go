@@ -0,0 +1,196 @@
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego/orm"
+)
+
+// 数据库中sp_manager_role表的模型，一个管理员可以拥有多个角色，权限为所有角色权限的并集。
+// sp_manager.role_id为0表示超级管理员；分配过角色的管理员role_id为其主角色（第一个分配的角色），只用于兼容
+type SpManagerRole struct {
+	Id     int `orm:"pk;auto"`
+	MgId   int `description:"管理员id"`
+	RoleId int `description:"角色id"`
+}
+
+// 同一管理员的同一角色只保存一条
+func (this *SpManagerRole) TableUnique() [][]string {
+	return [][]string{{"MgId", "RoleId"}}
+}
+
+/* 管理员拥有的角色 */
+type ManagerRole struct {
+	RoleId   int    `json:"id"`
+	RoleName string `json:"roleName"`
+}
+
+/* 添加、移除管理员角色时返回数据中data的结构 接口：users/:id/roles 请求方式：post、delete */
+type ResUserRolesData struct {
+	Id       int           `json:"id"`
+	UserName string        `json:"username"`
+	Roles    []ManagerRole `json:"roles"`
+}
+
+/* 添加、移除管理员角色时返回数据的结构 */
+type ResUserRoles struct {
+	Data *ResUserRolesData `json:"data"`
+	Meta *ResUsersMeta     `json:"meta"`
+}
+
+// 查询管理员拥有的角色
+func GetManagerRoles(mgId int) ([]ManagerRole, error) {
+	sqlStr := `
+		SELECT
+			t2.role_id, t2.role_name
+		FROM
+			sp_manager_role t1 JOIN sp_role t2
+		ON
+			t1.role_id = t2.role_id
+		WHERE
+			t1.mg_id = ?
+		ORDER BY
+			t1.id`
+	roles := []ManagerRole{}
+	o := orm.NewOrm()
+	_, err := o.Raw(sqlStr, mgId).QueryRows(&roles)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// 为管理员添加一个角色。超级管理员分配角色后成为普通管理员
+func AddManagerRole(manager *SpManager, roleId int) error {
+	o := orm.NewOrm()
+	if o.QueryTable("sp_manager_role").Filter("mg_id", manager.MgId).Filter("role_id", roleId).Exist() {
+		return errors.New("管理员已拥有该角色")
+	}
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = o.Insert(&SpManagerRole{MgId: manager.MgId, RoleId: roleId})
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	err = bumpManagerVersion(o, manager.MgId)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if manager.RoleId == 0 {
+		manager.RoleId = roleId
+		_, err = o.Update(manager, "RoleId")
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	forgetManagerVersion(manager.MgId)
+	return nil
+}
+
+// 移除管理员的一个角色。移除的是主角色时，改用剩下的第一个角色作为主角色；
+// 所有角色都移除后管理员没有任何权限，不会变回超级管理员
+func RemoveManagerRole(manager *SpManager, roleId int) error {
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	num, err := o.QueryTable("sp_manager_role").Filter("mg_id", manager.MgId).Filter("role_id", roleId).Delete()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if num == 0 {
+		o.Rollback()
+		return errors.New("管理员没有该角色")
+	}
+	err = bumpManagerVersion(o, manager.MgId)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if manager.RoleId == roleId {
+		var next SpManagerRole
+		err = o.QueryTable("sp_manager_role").Filter("mg_id", manager.MgId).OrderBy("id").Limit(1).One(&next)
+		if err == nil {
+			manager.RoleId = next.RoleId
+			_, err = o.Update(manager, "RoleId")
+			if err != nil {
+				o.Rollback()
+				return err
+			}
+		} else if err != orm.ErrNoRows {
+			o.Rollback()
+			return err
+		}
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	forgetManagerVersion(manager.MgId)
+	return nil
+}
+
+// 查询管理员的所有角色（包括未到期的临时角色）及这些角色的祖先角色的id
+func managerEffectiveRoleIds(manager *SpManager) ([]int, error) {
+	roleIds, err := managerRoleIds(manager.MgId)
+	if err != nil {
+		return nil, err
+	}
+	return EffectiveRoleIds(roleIds)
+}
+
+// 判断管理员是否拥有某个权限（任意一个角色拥有、从祖先角色继承或临时授予即可，已到期的临时授权不算）
+func ManagerHasPermission(manager *SpManager, psId int) bool {
+	ids, err := ManagerPermissionIds(manager)
+	if err != nil {
+		return false
+	}
+	return ids == nil || ids[psId]
+}
+
+// 查询管理员拥有的所有权限id（所有角色及其祖先角色权限的并集，加上临时授予的权限），超级管理员返回nil
+func ManagerPermissionIds(manager *SpManager) (map[int]bool, error) {
+	if manager.RoleId == 0 {
+		return nil, nil
+	}
+	roleIds, err := managerEffectiveRoleIds(manager)
+	if err != nil {
+		return nil, err
+	}
+	psIds, err := grantedPermissionIds(manager.MgId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int]bool)
+	for _, psId := range psIds {
+		ids[psId] = true
+	}
+	if len(roleIds) == 0 {
+		return ids, nil
+	}
+	var grants []SpRolePermission
+	o := orm.NewOrm()
+	_, err = o.QueryTable("sp_role_permission").Filter("role_id__in", roleIds).All(&grants, "PsId")
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		ids[g.PsId] = true
+	}
+	return ids, nil
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpManagerRole))
+}
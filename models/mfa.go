@@ -0,0 +1,199 @@
+package models
+
+import (
+	"JDStore/utils"
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"strings"
+	"time"
+)
+
+// 数据库中sp_manager_mfa表的模型，保存管理员的两步验证（TOTP）信息
+type SpManagerMfa struct {
+	MgId          int    `orm:"pk" description:"管理员id"`
+	Secret        string `orm:"size(64)" description:"TOTP密钥"`
+	Enabled       int8   `description:"1:已启用 0:已生成密钥但尚未验证"`
+	RecoveryCodes string `orm:"size(1024);null" description:"恢复码的hash，逗号分隔"`
+	LastStep      int64  `description:"上一次验证通过的时间步长，防止验证码重复使用"`
+	UpdateTime    int    `description:"更新时间"`
+}
+
+/* 定义两步验证相关接口返回的data结构（接口：login、login/mfa/enroll、mfa） */
+type ResMfaData struct {
+	MfaToken       string   `json:"mfa_token,omitempty"`
+	EnrollRequired bool     `json:"enroll_required,omitempty"`
+	Secret         string   `json:"secret,omitempty"`
+	Uri            string   `json:"uri,omitempty"`
+	RecoveryCodes  []string `json:"recovery_codes,omitempty"`
+}
+
+/* 定义两步验证相关接口返回数据的结构 */
+type ResMfa struct {
+	Data *ResMfaData `json:"data"`
+	Meta *ResMeta    `json:"meta"`
+}
+
+/* 定义获取post参数的结构体（login/mfa、login/mfa/enroll、mfa接口） */
+type MfaParams struct {
+	MfaToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+/* put请求中参数的结构 接口：roles/:id/mfa 请求方式：put */
+type RoleMfaParams struct {
+	Required bool `json:"required"`
+}
+
+// 恢复码的个数
+const recoveryCodeCount = 10
+
+// 查询管理员的两步验证信息，没有绑定时返回nil
+func GetManagerMfa(mgId int) (*SpManagerMfa, error) {
+	mfa := SpManagerMfa{MgId: mgId}
+	o := orm.NewOrm()
+	err := o.Read(&mfa)
+	if err == orm.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// 判断管理员登录时是否需要两步验证：自己已启用，或者所属角色要求必须启用。
+// 角色与鉴权时相同，包括未到期的临时角色和继承的祖先角色。第二个返回值表示是否还需要先绑定
+func MfaRequired(manager *SpManager) (bool, bool, error) {
+	mfa, err := GetManagerMfa(manager.MgId)
+	if err != nil {
+		return false, false, err
+	}
+	if mfa != nil && mfa.Enabled == 1 {
+		return true, false, nil
+	}
+	// 超级管理员没有对应的角色
+	if manager.RoleId == 0 {
+		return false, false, nil
+	}
+	roleIds, err := managerEffectiveRoleIds(manager)
+	if err != nil {
+		return false, false, err
+	}
+	if len(roleIds) == 0 {
+		return false, false, nil
+	}
+	// 任意一个角色要求启用即需要启用
+	o := orm.NewOrm()
+	required, err := o.QueryTable("sp_role").Filter("role_id__in", roleIds).Filter("mfa_required", 1).Count()
+	if err != nil {
+		return false, false, err
+	}
+	return required > 0, required > 0, nil
+}
+
+// 开始绑定：生成新的密钥，验证通过之前不生效。已启用时需要先由管理员重置
+func StartMfaEnroll(manager *SpManager) (*ResMfaData, error) {
+	mfa, err := GetManagerMfa(manager.MgId)
+	if err != nil {
+		return nil, err
+	}
+	if mfa != nil && mfa.Enabled == 1 {
+		return nil, errors.New("已启用两步验证")
+	}
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	o := orm.NewOrm()
+	newMfa := SpManagerMfa{MgId: manager.MgId, Secret: secret, UpdateTime: int(time.Now().Unix())}
+	if mfa == nil {
+		_, err = o.Insert(&newMfa)
+	} else {
+		_, err = o.Update(&newMfa)
+	}
+	if err != nil {
+		return nil, err
+	}
+	issuer := beego.AppConfig.DefaultString("MfaIssuer", "JDStore")
+	return &ResMfaData{Secret: secret, Uri: utils.TotpProvisioningURI(issuer, manager.MgName, secret)}, nil
+}
+
+// 使用验证码完成绑定，返回恢复码（只在这里返回一次）
+func ConfirmMfaEnroll(mgId int, code string) ([]string, error) {
+	mfa, err := GetManagerMfa(mgId)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+	if mfa.Enabled == 1 {
+		return nil, errors.New("已启用两步验证")
+	}
+	step, ok := utils.ValidateTotp(mfa.Secret, code, mfa.LastStep)
+	if !ok {
+		return nil, errors.New("验证码错误")
+	}
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, utils.HashRecoveryCode(c))
+	}
+	// 只更新仍未启用、且该验证码还没有被使用过的记录，并发提交同一验证码时只有一个能成功
+	o := orm.NewOrm()
+	num, err := o.QueryTable("sp_manager_mfa").Filter("mg_id", mgId).Filter("enabled", 0).
+		Filter("secret", mfa.Secret).Filter("last_step__lt", step).Update(orm.Params{
+		"enabled": 1, "last_step": step, "recovery_codes": strings.Join(hashes, ","),
+		"update_time": time.Now().Unix()})
+	if err != nil {
+		return nil, err
+	}
+	if num == 0 {
+		return nil, errors.New("验证码错误")
+	}
+	return codes, nil
+}
+
+// 校验已启用的两步验证，code可以是验证码或者恢复码（恢复码使用后失效）
+func VerifyMfaCode(mgId int, code string) (bool, error) {
+	mfa, err := GetManagerMfa(mgId)
+	if err != nil || mfa == nil || mfa.Enabled != 1 {
+		return false, err
+	}
+	// 验证码和恢复码都使用条件更新，并发请求重复使用同一个码时只有一个能更新成功
+	o := orm.NewOrm()
+	if step, ok := utils.ValidateTotp(mfa.Secret, code, mfa.LastStep); ok {
+		num, err := o.QueryTable("sp_manager_mfa").Filter("mg_id", mgId).Filter("last_step__lt", step).
+			Update(orm.Params{"last_step": step})
+		return err == nil && num > 0, err
+	}
+
+	hash := utils.HashRecoveryCode(code)
+	hashes := strings.Split(mfa.RecoveryCodes, ",")
+	for i, h := range hashes {
+		if h != "" && h == hash {
+			// 恢复码保存在同一个字段中，只有字段仍是读取时的值才删除该恢复码
+			remaining := strings.Join(append(hashes[:i], hashes[i+1:]...), ",")
+			num, err := o.QueryTable("sp_manager_mfa").Filter("mg_id", mgId).
+				Filter("recovery_codes", mfa.RecoveryCodes).Update(orm.Params{"recovery_codes": remaining})
+			return err == nil && num > 0, err
+		}
+	}
+	return false, nil
+}
+
+// 重置管理员的两步验证，重置后可重新绑定
+func ResetMfa(mgId int) error {
+	o := orm.NewOrm()
+	_, err := o.Delete(&SpManagerMfa{MgId: mgId})
+	return err
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpManagerMfa))
+}
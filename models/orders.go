@@ -18,6 +18,7 @@ type SpOrder struct {
 	OrderFapiaoContent string  `json:"order_fapiao_content"`
 	ConsigneeAddr      string  `json:"consignee_addr"`
 	PayStatus          string  `json:"pay_status"`
+	OrderSource        int     `json:"order_source"`
 	CreateTime         int     `json:"create_time"`
 	UpdateTime         int     `json:"update_time"`
 }
@@ -53,10 +54,20 @@ func OrderExists(id int) bool {
 	return o.QueryTable("sp_order").Filter("order_id", id).Exist()
 }
 
-// 修改订单地址 【接口：orders/:order_id 请求方式：put】
-func UpdateAddr(order *SpOrder, id int) (*ResAddrData, error) {
-	order.OrderId = id
+// 修改订单地址，订单来源不在数据范围内时返回ErrOutOfScope 【接口：orders/:order_id 请求方式：put】
+func UpdateAddr(order *SpOrder, id int, scope *DataScope) (*ResAddrData, error) {
 	o := orm.NewOrm()
+	if scope.LimitsOrderSource() {
+		current := SpOrder{OrderId: id}
+		err := o.Read(&current, "OrderId")
+		if err != nil {
+			return nil, err
+		}
+		if !scope.AllowOrderSource(current.OrderSource) {
+			return nil, ErrOutOfScope
+		}
+	}
+	order.OrderId = id
 	err := o.Begin()
 	if err != nil {
 		return nil, err
@@ -71,8 +82,8 @@ func UpdateAddr(order *SpOrder, id int) (*ResAddrData, error) {
 	return resAddrData, nil
 }
 
-// 获取订单数据列表 【接口：orders 请求方式：get】
-func GetOrdersList(query string, pagenum, pagesize int) (int, []*SpOrder, error) {
+// 获取订单数据列表，只返回数据范围内的订单 【接口：orders 请求方式：get】
+func GetOrdersList(query string, pagenum, pagesize int, scope *DataScope) (int, []*SpOrder, error) {
 	o := orm.NewOrm()
 	orderList := make([]*SpOrder, 0)
 	offset := (pagenum - 1) * pagesize
@@ -81,6 +92,14 @@ func GetOrdersList(query string, pagenum, pagesize int) (int, []*SpOrder, error)
 		// 按条件模糊查询
 		qs = qs.Filter("order_number__icontains", query)
 	}
+	if scope.LimitsOrderSource() {
+		sources := scope.OrderSources()
+		if len(sources) == 0 {
+			// 没有可访问的订单来源
+			return 0, orderList, nil
+		}
+		qs = qs.Filter("order_source__in", sources)
+	}
 	total, err := qs.Count()
 	if err != nil {
 		return 0, nil, err
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"JDStore/utils"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 密码的最大长度
+const pwdMaxLength = 32
+
+// 数据库中sp_password_history表的模型，保存管理员用过的密码hash，修改密码时不能与最近几次相同
+type SpPasswordHistory struct {
+	Id         int    `orm:"pk;auto"`
+	MgId       int    `description:"管理员id"`
+	MgPwd      string `orm:"size(64);type(char)" description:"用过的密码hash"`
+	CreateTime int    `description:"修改时间"`
+}
+
+// 数据库中sp_password_reset表的模型，保存管理员发起的重置密码令牌
+type SpPasswordReset struct {
+	Id         int    `orm:"pk;auto"`
+	MgId       int    `description:"管理员id"`
+	TokenHash  string `orm:"size(64)" description:"令牌的sha256值"`
+	ExpireTime int    `description:"过期时间"`
+	Used       int8   `description:"1:已使用或已作废"`
+	CreateTime int    `description:"创建时间"`
+}
+
+/* put参数的结构体 接口：users/:id/password，请求：put。修改自己的密码时必须填写旧密码 */
+type PasswordParams struct {
+	OldPassword     string `json:"old_password"`
+	NewPassword     string `json:"new_password" valid:"Required;MaxSize(32)"`
+	ConfirmPassword string `json:"confirm_password" valid:"Required"`
+}
+
+func (this *PasswordParams) Valid(v *validation.Validation) {
+	if this.ConfirmPassword != this.NewPassword {
+		v.SetError("ConfirmPassword", "两次密码输入不一致")
+	}
+}
+
+/* post参数的结构体 接口：login/password/reset，请求：post */
+type ResetPasswordParams struct {
+	Token           string `json:"token" valid:"Required"`
+	NewPassword     string `json:"new_password" valid:"Required;MaxSize(32)"`
+	ConfirmPassword string `json:"confirm_password" valid:"Required"`
+}
+
+func (this *ResetPasswordParams) Valid(v *validation.Validation) {
+	if this.ConfirmPassword != this.NewPassword {
+		v.SetError("ConfirmPassword", "两次密码输入不一致")
+	}
+}
+
+// 校验密码强度：长度（PwdMinLength至32位）、至少包含三类字符、不能与用户名相同
+func CheckPasswordStrength(userName, pwd string) error {
+	// 长度按字符数计算，中文等多字节字符算一位
+	length := utf8.RuneCountInString(pwd)
+	minLength := beego.AppConfig.DefaultInt("PwdMinLength", 8)
+	if length < minLength {
+		return fmt.Errorf("密码长度不能少于%d位", minLength)
+	}
+	if length > pwdMaxLength {
+		return fmt.Errorf("密码长度不能超过%d位", pwdMaxLength)
+	}
+	var upper, lower, digit, other int
+	for _, r := range pwd {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if upper+lower+digit+other < 3 {
+		return errors.New("密码必须包含大写字母、小写字母、数字、特殊字符中的至少三种")
+	}
+	if strings.EqualFold(pwd, userName) {
+		return errors.New("密码不能与用户名相同")
+	}
+	return nil
+}
+
+// 校验新密码是否符合密码策略：密码强度，以及不能是当前密码或最近PwdHistory次用过的密码
+func CheckPasswordPolicy(manager *SpManager, pwd string) error {
+	err := CheckPasswordStrength(manager.MgName, pwd)
+	if err != nil {
+		return err
+	}
+	historyCount := beego.AppConfig.DefaultInt("PwdHistory", 5)
+	if utils.ComparePasswords(manager.MgPwd, []byte(pwd)) {
+		return fmt.Errorf("不能使用最近%d次用过的密码", historyCount)
+	}
+	var histories []SpPasswordHistory
+	o := orm.NewOrm()
+	_, err = o.QueryTable("sp_password_history").
+		Filter("mg_id", manager.MgId).
+		OrderBy("-id").
+		Limit(historyCount).
+		All(&histories)
+	if err != nil {
+		return err
+	}
+	for _, h := range histories {
+		if utils.ComparePasswords(h.MgPwd, []byte(pwd)) {
+			return fmt.Errorf("不能使用最近%d次用过的密码", historyCount)
+		}
+	}
+	return nil
+}
+
+// 修改管理员的密码：校验密码策略，保存旧密码到历史记录，并吊销该管理员已签发的所有token
+func SetPassword(manager *SpManager, pwd string) error {
+	err := CheckPasswordPolicy(manager, pwd)
+	if err != nil {
+		return err
+	}
+	o := orm.NewOrm()
+	err = o.Begin()
+	if err != nil {
+		return err
+	}
+	err = savePassword(o, manager, pwd)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	RevokeManagerTokens(manager.MgId, manager.MgName)
+	return nil
+}
+
+// 在调用方的事务中保存新密码，旧密码写入历史记录。密码策略由调用方校验
+func savePassword(o orm.Ormer, manager *SpManager, pwd string) error {
+	history := SpPasswordHistory{MgId: manager.MgId, MgPwd: manager.MgPwd, CreateTime: int(time.Now().Unix())}
+	_, err := o.Insert(&history)
+	if err != nil {
+		return err
+	}
+	manager.MgPwd = utils.HashAndSalt(pwd)
+	_, err = o.Update(manager, "MgPwd")
+	return err
+}
+
+// 为管理员生成重置密码的令牌（有效期PwdResetExpire分钟，只能使用一次），之前未使用的令牌作废
+func CreatePasswordReset(manager *SpManager) (string, error) {
+	token, err := utils.RandomToken()
+	if err != nil {
+		return "", err
+	}
+	o := orm.NewOrm()
+	_, err = o.QueryTable("sp_password_reset").
+		Filter("mg_id", manager.MgId).
+		Filter("used", 0).
+		Update(orm.Params{"used": 1})
+	if err != nil {
+		return "", err
+	}
+	now := int(time.Now().Unix())
+	expire := beego.AppConfig.DefaultInt("PwdResetExpire", 30) * 60
+	reset := SpPasswordReset{MgId: manager.MgId, TokenHash: utils.HashToken(token), ExpireTime: now + expire, CreateTime: now}
+	_, err = o.Insert(&reset)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// 使用重置密码的令牌设置新密码，成功后令牌失效，同时解除该管理员的登录锁定。
+// 令牌在修改密码的事务中用条件更新标记为已使用，并发使用同一令牌时只有一个请求能修改密码
+func ResetPassword(token, pwd string) error {
+	var reset SpPasswordReset
+	tokenHash := utils.HashToken(token)
+	now := time.Now().Unix()
+	o := orm.NewOrm()
+	err := o.QueryTable("sp_password_reset").
+		Filter("token_hash", tokenHash).
+		Filter("used", 0).
+		Filter("expire_time__gt", now).
+		One(&reset)
+	if err != nil {
+		return errors.New("重置令牌无效或已过期")
+	}
+	manager := SpManager{MgId: reset.MgId}
+	err = o.Read(&manager)
+	if err != nil || manager.IsDeleted() {
+		return errors.New("重置令牌无效或已过期")
+	}
+	// 新密码不符合策略时令牌仍然有效
+	err = CheckPasswordPolicy(&manager, pwd)
+	if err != nil {
+		return err
+	}
+
+	err = o.Begin()
+	if err != nil {
+		return err
+	}
+	num, err := o.QueryTable("sp_password_reset").
+		Filter("token_hash", tokenHash).
+		Filter("used", 0).
+		Filter("expire_time__gt", now).
+		Update(orm.Params{"used": 1})
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if num != 1 {
+		o.Rollback()
+		return errors.New("重置令牌无效或已过期")
+	}
+	err = savePassword(o, &manager, pwd)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	RevokeManagerTokens(manager.MgId, manager.MgName)
+	return UnlockManager(manager.MgName)
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpPasswordHistory), new(SpPasswordReset))
+}
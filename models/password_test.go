@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/astaxie/beego"
+	"strings"
+	"testing"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	beego.AppConfig.Set("PwdMinLength", "8")
+	cases := []struct {
+		name     string
+		userName string
+		pwd      string
+		err      string
+	}{
+		{"三类字符", "admin", "Abcdefg1", ""},
+		{"四类字符", "admin", "Abc-1234", ""},
+		{"无大写字母", "admin", "abc-1234", ""},
+		{"中文算作其他字符", "admin", "密码abcd1234", ""},
+		{"中文按字符计算长度", "admin", "密码Ab1", "密码长度不能少于8位"},
+		{"中文字符的最大长度", "admin", "Aa1" + strings.Repeat("密", 29), ""},
+		{"中文字符超过最大长度", "admin", "Aa1" + strings.Repeat("密", 30), "密码长度不能超过32位"},
+		{"最大长度", "admin", "Aa1" + strings.Repeat("x", 29), ""},
+		{"太短", "admin", "Abc-123", "密码长度不能少于8位"},
+		{"太长", "admin", "Aa1" + strings.Repeat("x", 30), "密码长度不能超过32位"},
+		{"只有两类字符", "admin", "abcd1234", "密码必须包含大写字母、小写字母、数字、特殊字符中的至少三种"},
+		{"只有一类字符", "admin", "abcdefghij", "密码必须包含大写字母、小写字母、数字、特殊字符中的至少三种"},
+		{"与用户名相同", "Admin-123", "admin-123", "密码不能与用户名相同"},
+		{"包含用户名", "admin", "Admin-123", ""},
+	}
+	for _, c := range cases {
+		err := CheckPasswordStrength(c.userName, c.pwd)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != c.err {
+			t.Errorf("%s: CheckPasswordStrength(%q, %q) = %q, want %q", c.name, c.userName, c.pwd, got, c.err)
+		}
+	}
+
+	// 最小长度可以配置
+	beego.AppConfig.Set("PwdMinLength", "12")
+	defer beego.AppConfig.Set("PwdMinLength", "8")
+	if err := CheckPasswordStrength("admin", "Abcdefg-123"); err == nil || err.Error() != "密码长度不能少于12位" {
+		t.Errorf("PwdMinLength=12 时返回 %v", err)
+	}
+}
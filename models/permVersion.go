@@ -0,0 +1,257 @@
+package models
+
+import (
+	"JDStore/utils"
+	"errors"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/orm"
+	"sync"
+)
+
+/* 权限版本号：角色的权限或父角色变化时角色的版本号加1，管理员的角色或临时授权变化时管理员的版本号加1。
+*  access token中保存签发时管理员及其所有角色的版本号之和，鉴权时与当前的版本号之和比较，不一致说明权限已变化，
+*  token失效，前端用refresh token换取新的token即可。版本号只增不减，任意一个变化都会使和变化 */
+
+const (
+	permVersionRole    = "role"
+	permVersionManager = "manager"
+	identityDataKey    = "_identity"
+)
+
+// 管理员的权限在token签发后发生了变化
+var ErrPermissionChanged = errors.New("权限已变化，请刷新token")
+
+// 鉴权通过后保存在请求上下文中的身份信息，roleVersions为鉴权时各角色的版本号
+type requestIdentity struct {
+	*utils.TokenIdentity
+	roleVersions map[int]int64
+}
+
+// 在调用方的事务中把角色的权限版本号加1，事务提交后需调用forgetRoleVersions
+func bumpRoleVersions(o orm.Ormer, roleIds ...int) error {
+	if len(roleIds) == 0 {
+		return nil
+	}
+	_, err := o.QueryTable("sp_role").Filter("role_id__in", roleIds).
+		Update(orm.Params{"perm_version": orm.ColValue(orm.ColAdd, 1)})
+	return err
+}
+
+// 在调用方的事务中把管理员的权限版本号加1，事务提交后需调用forgetManagerVersion
+func bumpManagerVersion(o orm.Ormer, mgId int) error {
+	_, err := o.QueryTable("sp_manager").Filter("mg_id", mgId).
+		Update(orm.Params{"perm_version": orm.ColValue(orm.ColAdd, 1)})
+	return err
+}
+
+// 删除角色版本号的缓存，使新的版本号立即生效
+func forgetRoleVersions(roleIds ...int) {
+	for _, roleId := range roleIds {
+		utils.ForgetPermVersion(permVersionRole, roleId)
+	}
+}
+
+// 删除管理员版本号的缓存，使新的版本号立即生效
+func forgetManagerVersion(mgId int) {
+	utils.ForgetPermVersion(permVersionManager, mgId)
+}
+
+/* 把角色的权限版本号加1（不在事务中修改角色时使用） */
+func BumpRoleVersions(roleIds ...int) error {
+	err := bumpRoleVersions(orm.NewOrm(), roleIds...)
+	if err != nil {
+		return err
+	}
+	forgetRoleVersions(roleIds...)
+	return nil
+}
+
+/* 把管理员的权限版本号加1（不在事务中修改管理员时使用） */
+func BumpManagerVersion(mgId int) error {
+	err := bumpManagerVersion(orm.NewOrm(), mgId)
+	if err != nil {
+		return err
+	}
+	forgetManagerVersion(mgId)
+	return nil
+}
+
+// 读取管理员的权限版本号，缓存中没有时从数据库读取。管理员已不存在时返回orm.ErrNoRows
+func managerVersion(mgId int) (int64, error) {
+	if version, ok := utils.CachedPermVersion(permVersionManager, mgId); ok {
+		return version, nil
+	}
+	manager := SpManager{MgId: mgId}
+	err := orm.NewOrm().Read(&manager, "MgId")
+	if err != nil {
+		return 0, err
+	}
+	utils.CachePermVersion(permVersionManager, mgId, int64(manager.PermVersion))
+	return int64(manager.PermVersion), nil
+}
+
+// 读取多个角色的权限版本号，缓存中没有的从数据库读取。有角色已被删除时返回orm.ErrNoRows
+func roleVersions(roleIds []int) (map[int]int64, error) {
+	versions := make(map[int]int64, len(roleIds))
+	var missing []int
+	for _, roleId := range roleIds {
+		if version, ok := utils.CachedPermVersion(permVersionRole, roleId); ok {
+			versions[roleId] = version
+		} else {
+			missing = append(missing, roleId)
+		}
+	}
+	if len(missing) == 0 {
+		return versions, nil
+	}
+	var roles []SpRole
+	_, err := orm.NewOrm().QueryTable("sp_role").Filter("role_id__in", missing).All(&roles, "RoleId", "PermVersion")
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		versions[role.RoleId] = int64(role.PermVersion)
+		utils.CachePermVersion(permVersionRole, role.RoleId, int64(role.PermVersion))
+	}
+	if len(versions) != len(uniqueInts(roleIds)) {
+		return nil, orm.ErrNoRows
+	}
+	return versions, nil
+}
+
+// 管理员及其所有角色的版本号之和
+func permVersionSum(mgId int, roleVersions map[int]int64) (int64, error) {
+	sum, err := managerVersion(mgId)
+	if err != nil {
+		return 0, err
+	}
+	for _, version := range roleVersions {
+		sum += version
+	}
+	return sum, nil
+}
+
+/* 生成管理员的access token中保存的身份和权限信息，登录和刷新token时调用 */
+func ManagerTokenIdentity(manager *SpManager, sid string) (*utils.TokenIdentity, error) {
+	identity := &utils.TokenIdentity{MgId: manager.MgId, UserName: manager.MgName, RoleId: manager.RoleId, Sid: sid}
+	if manager.RoleId != 0 {
+		var err error
+		identity.RoleIds, err = managerEffectiveRoleIds(manager)
+		if err != nil {
+			return nil, err
+		}
+		identity.PsIds, err = grantedPermissionIds(manager.MgId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// token的有效期不能超过最早到期的临时授权
+	var grant SpManagerGrant
+	err := activeGrants(orm.NewOrm()).Filter("mg_id", manager.MgId).OrderBy("expire_time").Limit(1).One(&grant, "ExpireTime")
+	if err == nil {
+		identity.GrantExpireTime = int64(grant.ExpireTime)
+	} else if err != orm.ErrNoRows {
+		return nil, err
+	}
+	versions, err := roleVersions(identity.RoleIds)
+	if err != nil {
+		return nil, err
+	}
+	identity.PermVersion, err = permVersionSum(manager.MgId, versions)
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+/* 校验请求头中的access token，通过后把token中的身份信息保存到请求上下文中，之后CurrentIdentity返回该信息。
+*  token签发后权限发生变化时返回ErrPermissionChanged，所属会话已退出时返回ErrSessionRevoked */
+func AuthenticateToken(ctx *context.Context, token string) error {
+	identity, ok := utils.ParseAccessToken(token)
+	if !ok {
+		return errors.New("无效token")
+	}
+	versions, err := roleVersions(identity.RoleIds)
+	if err == orm.ErrNoRows {
+		// 角色已被删除
+		return ErrPermissionChanged
+	}
+	if err != nil {
+		return err
+	}
+	sum, err := permVersionSum(identity.MgId, versions)
+	if err != nil {
+		return err
+	}
+	if sum != identity.PermVersion {
+		return ErrPermissionChanged
+	}
+	// token所属的会话必须仍然有效，并记录会话的最后访问时间
+	err = CheckSession(identity.Sid)
+	if err != nil {
+		return err
+	}
+	ctx.Input.SetData(identityDataKey, &requestIdentity{identity, versions})
+	return nil
+}
+
+/* 当前请求的token中的身份信息，使用API Key访问或未通过鉴权时返回nil */
+func CurrentIdentity(ctx *context.Context) *utils.TokenIdentity {
+	identity, ok := ctx.Input.GetData(identityDataKey).(*requestIdentity)
+	if !ok {
+		return nil
+	}
+	return identity.TokenIdentity
+}
+
+// 角色权限的缓存，key为角色id。权限变化时角色的版本号会变化，版本号不一致时重新查询
+type rolePermCacheItem struct {
+	version int64
+	psIds   map[int]bool
+}
+
+var (
+	rolePermCache     = make(map[int]rolePermCacheItem)
+	rolePermCacheLock sync.RWMutex
+)
+
+// 查询角色在某个版本时的权限id
+func cachedRolePermissionIds(roleId int, version int64) (map[int]bool, error) {
+	rolePermCacheLock.RLock()
+	item, ok := rolePermCache[roleId]
+	rolePermCacheLock.RUnlock()
+	if ok && item.version == version {
+		return item.psIds, nil
+	}
+	psIds, err := RolePermissionIds(roleId)
+	if err != nil {
+		return nil, err
+	}
+	rolePermCacheLock.Lock()
+	rolePermCache[roleId] = rolePermCacheItem{version, psIds}
+	rolePermCacheLock.Unlock()
+	return psIds, nil
+}
+
+// 判断当前请求的管理员是否拥有某个权限，只使用token中的信息和角色权限的缓存
+func identityHasPermission(identity *requestIdentity, psId int) bool {
+	if identity.RoleId == 0 {
+		return true
+	}
+	// token的有效期不超过临时授权的到期时间，token中的临时权限都还有效
+	for _, id := range identity.PsIds {
+		if id == psId {
+			return true
+		}
+	}
+	for roleId, version := range identity.roleVersions {
+		psIds, err := cachedRolePermissionIds(roleId, version)
+		if err != nil {
+			return false
+		}
+		if psIds[psId] {
+			return true
+		}
+	}
+	return false
+}
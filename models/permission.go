@@ -0,0 +1,270 @@
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"strconv"
+)
+
+/* 添加、修改权限时body中参数的结构 接口：rights、rights/:id 请求方式：post、put
+*  ps_level为0、1的权限同时是左侧菜单，ps_api_path为菜单路径；ps_level为2的权限对应一个接口，
+*  ps_api_action为请求方式，ps_api_path为去掉基准URL之后的路由 */
+type PermissionParams struct {
+	PsName      string `json:"ps_name" valid:"Required;MaxSize(20)"`
+	PsPid       int    `json:"ps_pid" valid:"Min(0)"`
+	PsLevel     string `json:"ps_level" valid:"Required"`
+	PsC         string `json:"ps_c" valid:"MaxSize(32)"`
+	PsA         string `json:"ps_a" valid:"MaxSize(32)"`
+	PsApiAction string `json:"ps_api_action"`
+	PsApiPath   string `json:"ps_api_path" valid:"MaxSize(255)"`
+	PsApiOrder  int    `json:"ps_api_order"`
+}
+
+func (this *PermissionParams) Valid(v *validation.Validation) {
+	if this.PsLevel != "0" && this.PsLevel != "1" && this.PsLevel != "2" {
+		v.SetError("PsLevel", "权限等级必须是0、1、2")
+	}
+	switch this.PsApiAction {
+	case "", "get", "post", "put", "delete":
+	default:
+		v.SetError("PsApiAction", "请求方式必须是get、post、put、delete")
+	}
+	if this.PsApiAction != "" && this.PsApiPath == "" {
+		v.SetError("PsApiPath", "设置了请求方式时路由不能为空")
+	}
+}
+
+/* 调整菜单顺序时body中每一项的结构 接口：rights/order 请求方式：put */
+type PermissionOrder struct {
+	PsId       int `json:"id"`
+	PsApiOrder int `json:"order"`
+}
+
+/* 添加、修改权限时返回数据中data的结构 */
+type ResPermissionData struct {
+	PsId        int    `json:"id"`
+	PsName      string `json:"authName"`
+	PsPid       int    `json:"pid"`
+	PsLevel     string `json:"level"`
+	PsC         string `json:"ps_c"`
+	PsA         string `json:"ps_a"`
+	PsApiAction string `json:"action"`
+	PsApiPath   string `json:"path"`
+	PsApiOrder  int    `json:"order"`
+}
+
+/* 添加、修改权限时返回数据的结构 */
+type ResPermission struct {
+	Data *ResPermissionData `json:"data"`
+	Meta *ResMeta           `json:"meta"`
+}
+
+/* 校验权限等级的嵌套关系：0级权限的父id必须为0，其他权限的父权限必须比它高一级；
+*  修改已有权限（id不为0）时，它的子权限也必须仍然比它低一级 */
+func checkPermissionLevel(o orm.Ormer, id int, params *PermissionParams) error {
+	level, _ := strconv.Atoi(params.PsLevel)
+	if level == 0 {
+		if params.PsPid != 0 {
+			return errors.New("0级权限的父权限id必须为0")
+		}
+	} else {
+		if params.PsPid == id {
+			return errors.New("父权限不能是自己")
+		}
+		parent := SpPermission{PsId: params.PsPid}
+		err := o.Read(&parent)
+		if err != nil {
+			return errors.New("父权限不存在")
+		}
+		if parent.PsLevel != strconv.Itoa(level-1) {
+			return errors.New("父权限的等级必须比当前权限高一级")
+		}
+	}
+	if id == 0 {
+		return nil
+	}
+	count, err := o.QueryTable("sp_permission").
+		Filter("ps_pid", id).
+		Exclude("ps_level", strconv.Itoa(level+1)).
+		Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("子权限的等级必须比当前权限低一级，请先调整子权限")
+	}
+	return nil
+}
+
+/* 添加权限，同时添加对应的sp_permission_api记录 */
+func AddPermission(params *PermissionParams) (*ResPermissionData, error) {
+	o := orm.NewOrm()
+	err := checkPermissionLevel(o, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	permission := SpPermission{PsName: params.PsName, PsPid: params.PsPid, PsC: params.PsC, PsA: params.PsA, PsLevel: params.PsLevel}
+	_, err = o.Insert(&permission)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	api := SpPermissionApi{PsId: permission.PsId, PsApiAction: params.PsApiAction, PsApiPath: params.PsApiPath, PsApiOrder: params.PsApiOrder}
+	_, err = o.Insert(&api)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	err = o.Commit()
+	if err != nil {
+		return nil, err
+	}
+	// 使新的接口权限立即生效
+	err = ReloadApiRights()
+	return permissionData(&permission, &api), err
+}
+
+/* 修改权限及其对应的sp_permission_api记录 */
+func UpdatePermission(id int, params *PermissionParams) (*ResPermissionData, error) {
+	o := orm.NewOrm()
+	permission := SpPermission{PsId: id}
+	err := o.Read(&permission)
+	if err != nil {
+		return nil, errors.New("权限不存在")
+	}
+	err = checkPermissionLevel(o, id, params)
+	if err != nil {
+		return nil, err
+	}
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	permission = SpPermission{PsId: id, PsName: params.PsName, PsPid: params.PsPid, PsC: params.PsC, PsA: params.PsA, PsLevel: params.PsLevel}
+	_, err = o.Update(&permission)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	// 旧数据中可能没有对应的sp_permission_api记录
+	api := SpPermissionApi{PsId: id}
+	_, _, err = o.ReadOrCreate(&api, "PsId")
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	api.PsApiAction = params.PsApiAction
+	api.PsApiPath = params.PsApiPath
+	api.PsApiOrder = params.PsApiOrder
+	_, err = o.Update(&api, "PsApiAction", "PsApiPath", "PsApiOrder")
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	err = o.Commit()
+	if err != nil {
+		return nil, err
+	}
+	err = ReloadApiRights()
+	return permissionData(&permission, &api), err
+}
+
+/* 删除权限。权限有子权限或者已分配给角色时，cascade为false则拒绝删除；
+*  cascade为true则同时删除所有子权限，并从角色的权限列表中移除 */
+func DeletePermission(id int, cascade bool) error {
+	o := orm.NewOrm()
+	permission := SpPermission{PsId: id}
+	err := o.Read(&permission)
+	if err != nil {
+		return errors.New("权限不存在")
+	}
+	ids, err := permissionSubtree(o, id)
+	if err != nil {
+		return err
+	}
+	granted, err := o.QueryTable("sp_role_permission").Filter("ps_id__in", ids).Count()
+	if err != nil {
+		return err
+	}
+	if !cascade {
+		if len(ids) > 1 {
+			return errors.New("该权限下还有子权限，不能删除")
+		}
+		if granted > 0 {
+			return errors.New("该权限已分配给角色，不能删除")
+		}
+	}
+
+	err = o.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = o.QueryTable("sp_role_permission").Filter("ps_id__in", ids).Delete()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.QueryTable("sp_permission_api").Filter("ps_id__in", ids).Delete()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.QueryTable("sp_permission").Filter("ps_id__in", ids).Delete()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	return ReloadApiRights()
+}
+
+/* 调整菜单顺序（ps_api_order） */
+func SortPermissions(orders []PermissionOrder) error {
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		qs := o.QueryTable("sp_permission_api").Filter("ps_id", order.PsId)
+		if !qs.Exist() {
+			o.Rollback()
+			return errors.New("权限id不存在：" + strconv.Itoa(order.PsId))
+		}
+		_, err = qs.Update(orm.Params{"ps_api_order": order.PsApiOrder})
+		if err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+/* 查询权限id及其所有子孙权限的id */
+func permissionSubtree(o orm.Ormer, id int) ([]int, error) {
+	ids := []int{id}
+	for i := 0; i < len(ids); i++ {
+		var children []SpPermission
+		_, err := o.QueryTable("sp_permission").Filter("ps_pid", ids[i]).All(&children, "PsId")
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			ids = append(ids, child.PsId)
+		}
+	}
+	return ids, nil
+}
+
+func permissionData(permission *SpPermission, api *SpPermissionApi) *ResPermissionData {
+	return &ResPermissionData{permission.PsId, permission.PsName, permission.PsPid, permission.PsLevel,
+		permission.PsC, permission.PsA, api.PsApiAction, api.PsApiPath, api.PsApiOrder}
+}
@@ -1,12 +1,19 @@
 package models
 
 import (
-	"JDStore/utils"
 	"github.com/astaxie/beego/context"
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/validation"
 	"strconv"
 	"strings"
+	"sync"
+)
+
+/* 缓存sp_permission_api中配置了请求方式的接口权限，避免每次请求都查询数据库 */
+var (
+	apiRightsLock   sync.RWMutex
+	apiRights       []SpPermissionApi
+	apiRightsLoaded bool
 )
 
 /* 定义获取权限列表（list形式）时data中数据的结构 */
@@ -59,6 +66,7 @@ type ResRole struct {
 	RoleId   int         `json:"id"`
 	RoleName string      `json:"roleName"`
 	RoleDesc string      `json:"roleDesc"`
+	RolePid  int         `json:"rolePid"`
 	Children interface{} `json:"children"`
 }
 
@@ -99,6 +107,7 @@ type ResRoleInfoData struct {
 	RoleId   interface{} `json:"roleId"`
 	RoleName interface{} `json:"roleName"`
 	RoleDesc interface{} `json:"roleDesc"`
+	RolePid  interface{} `json:"rolePid"`
 }
 
 /* 编辑角色返回的数据的结构 接口：roles/:id 请求方式：put */
@@ -111,39 +120,46 @@ type ResRoleInfo struct {
 type GetRoleParams struct {
 	RoleName string
 	RoleDesc string
+	/* 父角色id，修改角色时不传表示不修改，传0表示取消父角色 */
+	RolePid *int
 }
 
 func (this *PostRights) Valid(v *validation.Validation) {
-	if this.Rids == "" {
+	psIds, err := this.PsIds()
+	if err != nil {
+		v.SetError("Rids", "权限格式错误")
 		return
 	}
-	RightsSlice := strings.Split(this.Rids, ",")
-	for _, right := range RightsSlice {
-		/* 将切片中每个元素转换为数字 */
-		rightNum, err := strconv.Atoi(right)
+	if len(psIds) == 0 {
+		return
+	}
+	/* 验证权限列表中是否有权限id不存在于数据库表 */
+	o := orm.NewOrm()
+	count, err := o.QueryTable("sp_permission").Filter("ps_id__in", psIds).Count()
+	if err != nil || count != int64(len(psIds)) {
+		v.SetError("Rids", "包含不存在的权限")
+	}
+}
+
+/* 将Rids转换为权限id列表，去掉根权限0以及重复的id */
+func (this *PostRights) PsIds() ([]int, error) {
+	psIds := []int{}
+	if this.Rids == "" {
+		return psIds, nil
+	}
+	seen := make(map[int]bool)
+	for _, right := range strings.Split(this.Rids, ",") {
+		rightNum, err := strconv.Atoi(strings.TrimSpace(right))
 		if err != nil {
-			v.SetError("Rids", "权限格式错误")
-			return
+			return nil, err
 		}
-		/* 验证权限列表中是否有权限id不存在于数据库表 */
-		if rightNum == 0 {
-			/* 不验证根权限 */
+		if rightNum == 0 || seen[rightNum] {
 			continue
 		}
-		isNotInTable := true
-		var spPermission []SpPermission
-		o := orm.NewOrm()
-		o.QueryTable("sp_permission").All(&spPermission)
-		for _, per := range spPermission {
-			if per.PsId == rightNum {
-				isNotInTable = false
-				break
-			}
-		}
-		if isNotInTable {
-			v.SetError("Rids", "包含不存在的权限")
-		}
+		seen[rightNum] = true
+		psIds = append(psIds, rightNum)
 	}
+	return psIds, nil
 }
 
 /* 查询权限列表（list形式） */
@@ -208,7 +224,7 @@ func GetTree(id int) ([]*ResRight, error) {
 	return resRights, nil
 }
 
-/* 查询角色列表 */
+/* 查询角色列表，每个角色的权限包括从祖先角色继承的权限 */
 func QueryRoleList() ([]ResRole, error) {
 	var roles []SpRole
 	o := orm.NewOrm()
@@ -217,104 +233,229 @@ func QueryRoleList() ([]ResRole, error) {
 	if err != nil {
 		return nil, err
 	}
+	/* 一次查询出所有角色的权限，再按角色分组 */
+	rows, err := queryRoleRights()
+	if err != nil {
+		return nil, err
+	}
+	roleRows := make(map[int][]roleRight)
+	for _, row := range rows {
+		roleRows[row.RoleId] = append(roleRows[row.RoleId], row)
+	}
+	roleMap := make(map[int]SpRole, len(roles))
+	for _, v := range roles {
+		roleMap[v.RoleId] = v
+	}
 
 	var resRoles []ResRole
 	for _, v := range roles {
-		resRole := ResRole{RoleId: v.RoleId, RoleName: v.RoleName, RoleDesc: v.RoleDesc}
-		rightsSlice := strings.Split(v.PsIds, ",")
-		var children []ResRoleRight
-		/* 校验根权限0。这里调用了自定义的函数 */
-		if !utils.Contains(rightsSlice, "0") || v.PsIds == "" {
-			children = []ResRoleRight{}
-		} else {
-			children, err = GetRoleRights(0, rightsSlice)
-			if err != nil {
-				return nil, err
-			}
-		}
-		/* 当角色没有任何权限时，children字段不返回null，而是空切片 */
-		if children == nil {
-			children = []ResRoleRight{}
+		/* 合并角色自己及所有祖先角色的权限 */
+		var effective []roleRight
+		for _, id := range roleLineage(roleMap, v.RoleId) {
+			effective = append(effective, roleRows[id]...)
 		}
-		resRole.Children = children
+		resRole := ResRole{RoleId: v.RoleId, RoleName: v.RoleName, RoleDesc: v.RoleDesc, RolePid: v.RolePid}
+		resRole.Children = buildRoleRights(uniqueRoleRights(effective), 0)
 		resRoles = append(resRoles, resRole)
 	}
 	return resRoles, nil
 }
 
-/* 递归获取每个角色的权限列表 */
-func GetRoleRights(id int, rightsSlice []string) ([]ResRoleRight, error) {
-	/* 查询出rid为id的那些权限。例如id为0时，返回的是所有一级权限以及所有一级权限的子权限 */
-	/* 数据库中的order字段可能是渲染左侧菜单列表的时候才有用，
-	*  这里渲染权限列表时应该是不必按order字段排序的，所以这里不必使用order by子句 */
+/* 角色拥有的一条权限 */
+type roleRight struct {
+	RoleId    int
+	PsId      int
+	PsName    string
+	PsPid     int
+	PsApiPath string
+}
+
+/* 查询角色直接拥有的权限，不传roleIds时查询所有角色 */
+func queryRoleRights(roleIds ...int) ([]roleRight, error) {
 	sqlStr := `
 		SELECT 
-			t1.ps_id, t1.ps_name, t2.ps_api_path
+			rp.role_id, t1.ps_id, t1.ps_name, t1.ps_pid, t2.ps_api_path
 		FROM
-			sp_permission t1 JOIN sp_permission_api t2
+			sp_role_permission rp JOIN sp_permission t1
 		ON
-			t1.ps_id = t2.ps_id
-		WHERE
-			t1.ps_pid = ?`
-	/* 存储从数据库中查询到的结果集 */
-	var rights []Right
+			rp.ps_id = t1.ps_id JOIN sp_permission_api t2
+		ON
+			t1.ps_id = t2.ps_id`
+	var rows []roleRight
 	o := orm.NewOrm()
-	num, err := o.Raw(sqlStr, id).QueryRows(&rights)
+	var err error
+	if len(roleIds) == 0 {
+		_, err = o.Raw(sqlStr).QueryRows(&rows)
+	} else {
+		_, err = o.Raw(sqlStr+" WHERE rp.role_id IN ("+sqlPlaceholders(len(roleIds))+")", roleIds).QueryRows(&rows)
+	}
 	if err != nil {
 		return nil, err
 	}
-	/* 存储包含children成员的权限列表 */
-	var resRoleRights []ResRoleRight
-	/* 递归终止条件 */
-	if num == 0 {
-		/* 返回一个空切片 */
+	return rows, nil
+}
+
+/* 获取角色的权限树，包括从祖先角色继承的权限 */
+func GetRoleRights(roleId int) ([]ResRoleRight, error) {
+	roleIds, err := EffectiveRoleIds([]int{roleId})
+	if err != nil {
+		return nil, err
+	}
+	if len(roleIds) == 0 {
 		return []ResRoleRight{}, nil
 	}
+	rows, err := queryRoleRights(roleIds...)
+	if err != nil {
+		return nil, err
+	}
+	return buildRoleRights(uniqueRoleRights(rows), 0), nil
+}
 
-	for _, v := range rights {
-		/* 如果查询到的权限id不包含在角色的权限内，则中断本次循环 */
-		if !utils.Contains(rightsSlice, strconv.FormatInt(int64(v.PsId), 10)) {
+/* 递归地把角色拥有的权限组织成树，父权限不属于该角色时，子权限也不显示 */
+func buildRoleRights(rows []roleRight, pid int) []ResRoleRight {
+	/* 当对象没有任何子权限时，children字段不返回null，而是空切片 */
+	resRoleRights := []ResRoleRight{}
+	for _, v := range rows {
+		if v.PsPid != pid {
 			continue
 		}
 		resRight := ResRoleRight{RoleId: v.PsId, RoleName: v.PsName, PsApiPath: v.PsApiPath}
-		children, err := GetRoleRights(v.PsId, rightsSlice)
+		resRight.Children = buildRoleRights(rows, v.PsId)
+		resRoleRights = append(resRoleRights, resRight)
+	}
+	return resRoleRights
+}
+
+/* 重新加载接口权限配置。修改sp_permission_api表后调用，使新配置立即生效 */
+func ReloadApiRights() error {
+	var apis []SpPermissionApi
+	o := orm.NewOrm()
+	/* ps_api_action中保存的是请求方式（get、post、put、delete），
+	*  ps_api_path中保存的是去掉基准URL之后的路由，例如 users/:id */
+	_, err := o.QueryTable("sp_permission_api").
+		Filter("ps_api_action__in", "get", "post", "put", "delete").
+		All(&apis)
+	if err != nil {
+		return err
+	}
+	apiRightsLock.Lock()
+	apiRights = apis
+	apiRightsLoaded = true
+	apiRightsLock.Unlock()
+	return nil
+}
+
+/* 根据请求方式和请求路径（不含基准URL）查找访问该接口所需的权限id，第二个返回值表示是否找到 */
+func MatchApiRight(method, path string) (int, bool) {
+	apiRightsLock.RLock()
+	loaded := apiRightsLoaded
+	apiRightsLock.RUnlock()
+	if !loaded {
+		if err := ReloadApiRights(); err != nil {
+			return 0, false
+		}
+	}
+
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	psId, bestScore := 0, -1
+	apiRightsLock.RLock()
+	defer apiRightsLock.RUnlock()
+	for _, api := range apiRights {
+		if !strings.EqualFold(api.PsApiAction, method) {
+			continue
+		}
+		score, ok := matchRoute(api.PsApiPath, pathSegments)
+		/* 同时匹配多条路由时，取固定片段最多的那一条，例如 reports/type/1 优先于 reports/type/:id */
+		if ok && score > bestScore {
+			psId, bestScore = api.PsId, score
+		}
+	}
+	return psId, bestScore >= 0
+}
+
+/* 判断请求路径是否与路由匹配，以冒号开头的片段可匹配任意非空值。返回值score为匹配上的固定片段数 */
+func matchRoute(route string, pathSegments []string) (int, bool) {
+	routeSegments := strings.Split(strings.Trim(route, "/"), "/")
+	if len(routeSegments) != len(pathSegments) {
+		return 0, false
+	}
+	score := 0
+	for i, seg := range routeSegments {
+		if strings.HasPrefix(seg, ":") {
+			if pathSegments[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+		if seg != pathSegments[i] {
+			return 0, false
+		}
+		score++
+	}
+	return score, true
+}
+
+/* 判断请求路径是否与路由匹配，匹配时返回路由参数，例如 users/:id 匹配 users/500 时返回 {":id": "500"} */
+func RouteParams(route, path string) (map[string]string, bool) {
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if _, ok := matchRoute(route, pathSegments); !ok {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, seg := range strings.Split(strings.Trim(route, "/"), "/") {
+		if strings.HasPrefix(seg, ":") {
+			params[seg] = pathSegments[i]
+		}
+	}
+	return params, true
+}
+
+/* 根据请求头中的token查询当前登录的管理员 */
+func CurrentManager(ctx *context.Context) (*SpManager, error) {
+	// 使用API Key访问时，当前用户是Key所属的服务账号
+	if apiKey := requestApiKey(ctx); apiKey != nil {
+		manager := SpManager{MgId: apiKey.MgId}
+		o := orm.NewOrm()
+		err := o.Read(&manager)
 		if err != nil {
 			return nil, err
 		}
-		/* 当对象没有任何子权限时，children字段不返回null，而是空切片 */
-		if children == nil {
-			children = []ResRoleRight{}
-		}
-		resRight.Children = children
-		resRoleRights = append(resRoleRights, resRight)
+		return &manager, nil
+	}
+	// 使用token访问时，管理员id在鉴权时已从token中取出
+	identity := CurrentIdentity(ctx)
+	if identity == nil {
+		return nil, orm.ErrNoRows
+	}
+	manager := SpManager{MgId: identity.MgId}
+	o := orm.NewOrm()
+	err := o.Read(&manager)
+	if err != nil {
+		return nil, err
 	}
-	return resRoleRights, nil
+	return &manager, nil
 }
 
 /* 对用户访问资源进行权限验证 */
 func ValidateRight(ctx *context.Context, rid int) bool {
-	// 获取当前登陆的用户名
-	token := ctx.Input.Header("Authorization")
-	token = strings.Split(token, " ")[1]
-	user := utils.CheckToken(token)
+	// 使用token访问时，只根据token中的角色和临时授权判断，不查询管理员
+	if identity, ok := ctx.Input.GetData(identityDataKey).(*requestIdentity); ok {
+		return identityHasPermission(identity, rid)
+	}
+	manager, err := CurrentManager(ctx)
+	if err != nil {
+		// 用户已不存在，RoleId的零值会被误判为超级管理员，这里直接拒绝
+		return false
+	}
+	// 限定了权限范围的API Key只能访问范围内的接口
+	if apiKey := requestApiKey(ctx); apiKey != nil && !apiKeyAllows(apiKey, rid) {
+		return false
+	}
 	// 判断用户是否是超级管理员
-	manager := SpManager{MgName: user}
-	o := orm.NewOrm()
-	o.Read(&manager, "MgName")
 	if manager.RoleId == 0 {
 		// 当前用户是超级管理员，直接返回true
 		return true
 	}
-	// 获取当前用户的权限列表
-	role := SpRole{RoleId: int(manager.RoleId)}
-	o.Read(&role, "RoleId")
-	userRights := strings.Split(role.PsIds, ",")
-
-	// 判断接口对应的权限id是否存在于用户的权限列表
-	ridStr := strconv.FormatInt(int64(rid), 10)
-	if utils.Contains(userRights, ridStr) {
-		return true
-	} else {
-		return false
-	}
+	// 判断接口对应的权限id是否分配给了用户的任意一个角色
+	return ManagerHasPermission(manager, rid)
 }
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 使用测试用的接口权限，不从数据库加载
+func setTestApiRights(t *testing.T, rights []SpPermissionApi) {
+	apiRightsLock.Lock()
+	oldRights, oldLoaded := apiRights, apiRightsLoaded
+	apiRights, apiRightsLoaded = rights, true
+	apiRightsLock.Unlock()
+	t.Cleanup(func() {
+		apiRightsLock.Lock()
+		apiRights, apiRightsLoaded = oldRights, oldLoaded
+		apiRightsLock.Unlock()
+	})
+}
+
+func TestMatchRoute(t *testing.T) {
+	cases := []struct {
+		route string
+		path  string
+		score int
+		ok    bool
+	}{
+		{"users", "users", 1, true},
+		{"/users/", "users", 1, true},
+		{"users/:id", "users/500", 1, true},
+		{"users/:id/state/:type", "users/500/state/true", 2, true},
+		{"users/:id", "users", 0, false},
+		{"users/:id", "users/500/role", 0, false},
+		{"users/:id", "users/", 0, false},
+		{"users/:id/role", "users/500/roles", 0, false},
+		{"reports/type/1", "reports/type/1", 3, true},
+		{"reports/type/:id", "reports/type/1", 2, true},
+		{":a/:b", "x/y", 0, true},
+	}
+	for _, c := range cases {
+		score, ok := matchRoute(c.route, splitTestPath(c.path))
+		if score != c.score || ok != c.ok {
+			t.Errorf("matchRoute(%q, %q) = %d, %v, want %d, %v", c.route, c.path, score, ok, c.score, c.ok)
+		}
+	}
+}
+
+// 与MatchApiRight相同的方式拆分请求路径
+func splitTestPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func TestRouteParams(t *testing.T) {
+	cases := []struct {
+		route  string
+		path   string
+		params map[string]string
+		ok     bool
+	}{
+		{"users/:id", "users/500", map[string]string{":id": "500"}, true},
+		{"users/:id/state/:type", "/users/500/state/true/", map[string]string{":id": "500", ":type": "true"}, true},
+		{"users", "users", map[string]string{}, true},
+		{"users/:id", "goods/500", nil, false},
+		{"users/:id", "users", nil, false},
+	}
+	for _, c := range cases {
+		params, ok := RouteParams(c.route, c.path)
+		if ok != c.ok || !reflect.DeepEqual(params, c.params) {
+			t.Errorf("RouteParams(%q, %q) = %v, %v, want %v, %v", c.route, c.path, params, ok, c.params, c.ok)
+		}
+	}
+}
+
+func TestMatchApiRight(t *testing.T) {
+	setTestApiRights(t, []SpPermissionApi{
+		{PsId: 110, PsApiAction: "get", PsApiPath: "users"},
+		{PsId: 131, PsApiAction: "put", PsApiPath: "users/:id"},
+		{PsId: 145, PsApiAction: "get", PsApiPath: "reports/type/:id"},
+		{PsId: 146, PsApiAction: "get", PsApiPath: "reports/type/1"},
+		{PsId: 150, PsApiAction: "get", PsApiPath: ":kind/type/1"},
+		{PsId: 160, PsApiAction: "delete", PsApiPath: "users/:id/role"},
+		{PsId: 161, PsApiAction: "delete", PsApiPath: ":resource/:id/role"},
+	})
+	cases := []struct {
+		method string
+		path   string
+		psId   int
+		ok     bool
+	}{
+		{"GET", "users", 110, true},
+		{"get", "/users/", 110, true},
+		{"PUT", "users/500", 131, true},
+		{"GET", "users/500", 0, false},
+		{"POST", "users", 0, false},
+		// 固定片段最多的路由优先，与配置的顺序无关
+		{"GET", "reports/type/1", 146, true},
+		{"GET", "reports/type/2", 145, true},
+		{"GET", "orders/type/1", 150, true},
+		{"DELETE", "users/500/role", 160, true},
+		{"DELETE", "goods/500/role", 161, true},
+	}
+	for _, c := range cases {
+		psId, ok := MatchApiRight(c.method, c.path)
+		if psId != c.psId || ok != c.ok {
+			t.Errorf("MatchApiRight(%q, %q) = %d, %v, want %d, %v", c.method, c.path, psId, ok, c.psId, c.ok)
+		}
+	}
+}
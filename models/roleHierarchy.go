@@ -0,0 +1,182 @@
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego/orm"
+	"sort"
+)
+
+/* 角色的一条有效权限，Sources为授予该权限的角色（角色自己或祖先角色），按从近到远排列 */
+type ResEffectiveRight struct {
+	PsId      int           `json:"id"`
+	PsName    string        `json:"authName"`
+	PsPid     int           `json:"pid"`
+	PsApiPath string        `json:"path"`
+	Sources   []ManagerRole `json:"sources"`
+}
+
+/* 获取角色有效权限时返回数据中data的结构 接口：roles/:id/effective-rights 请求方式：get */
+type ResEffectiveRightsData struct {
+	RoleId    int                  `json:"roleId"`
+	RoleName  string               `json:"roleName"`
+	Ancestors []ManagerRole        `json:"ancestors"`
+	Rights    []*ResEffectiveRight `json:"rights"`
+}
+
+/* 获取角色有效权限时返回数据的结构 */
+type ResEffectiveRights struct {
+	Data *ResEffectiveRightsData `json:"data"`
+	Meta *ResMeta                `json:"meta"`
+}
+
+// 查询所有角色，key为角色id。角色数量很少，直接全部查出后在内存中计算继承关系
+func loadRoles() (map[int]SpRole, error) {
+	var roles []SpRole
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_role").All(&roles, "RoleId", "RoleName", "RolePid")
+	if err != nil {
+		return nil, err
+	}
+	roleMap := make(map[int]SpRole, len(roles))
+	for _, role := range roles {
+		roleMap[role.RoleId] = role
+	}
+	return roleMap, nil
+}
+
+// 角色及其所有祖先角色的id，按从近到远排列（第一个是角色自己）。遇到已存在的环时停止
+func roleLineage(roles map[int]SpRole, roleId int) []int {
+	var ids []int
+	seen := make(map[int]bool)
+	for id := roleId; id != 0 && !seen[id]; id = roles[id].RolePid {
+		if _, ok := roles[id]; !ok {
+			break
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// 多个角色及其所有祖先角色的id（去重），权限为这些角色权限的并集
+func EffectiveRoleIds(roleIds []int) ([]int, error) {
+	roles, err := loadRoles()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	seen := make(map[int]bool)
+	for _, roleId := range roleIds {
+		for _, id := range roleLineage(roles, roleId) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+/* 校验角色的父角色：父角色必须存在，且不能是角色自己或它的子孙角色（否则继承关系会形成环）。
+*  roleId为0表示新建角色 */
+func CheckRoleParent(roleId, pid int) error {
+	if pid == 0 {
+		return nil
+	}
+	if pid == roleId {
+		return errors.New("父角色不能是角色自己")
+	}
+	roles, err := loadRoles()
+	if err != nil {
+		return err
+	}
+	if _, ok := roles[pid]; !ok {
+		return errors.New("父角色不存在")
+	}
+	for _, id := range roleLineage(roles, pid) {
+		if id == roleId {
+			return errors.New("父角色不能是该角色的子孙角色")
+		}
+	}
+	return nil
+}
+
+// 判断角色是否有子角色
+func RoleHasChildren(roleId int) bool {
+	o := orm.NewOrm()
+	return o.QueryTable("sp_role").Filter("role_pid", roleId).Exist()
+}
+
+// 判断角色是否从祖先角色继承了某个权限
+func RoleInheritsPermission(roleId, psId int) (bool, error) {
+	roles, err := loadRoles()
+	if err != nil {
+		return false, err
+	}
+	ancestors := roleLineage(roles, roleId)
+	if len(ancestors) <= 1 {
+		return false, nil
+	}
+	o := orm.NewOrm()
+	count, err := o.QueryTable("sp_role_permission").
+		Filter("role_id__in", ancestors[1:]).
+		Filter("ps_id", psId).
+		Count()
+	return count > 0, err
+}
+
+// 去掉重复的权限（多个祖先角色授予了同一权限）
+func uniqueRoleRights(rows []roleRight) []roleRight {
+	seen := make(map[int]bool)
+	var unique []roleRight
+	for _, row := range rows {
+		if !seen[row.PsId] {
+			seen[row.PsId] = true
+			unique = append(unique, row)
+		}
+	}
+	return unique
+}
+
+/* 查询角色的有效权限（自己的权限加上所有祖先角色的权限），并标出每条权限来自哪些角色 */
+func GetEffectiveRights(roleId int) (*ResEffectiveRightsData, error) {
+	roles, err := loadRoles()
+	if err != nil {
+		return nil, err
+	}
+	role, ok := roles[roleId]
+	if !ok {
+		return nil, errors.New("角色id不存在")
+	}
+	lineage := roleLineage(roles, roleId)
+	rows, err := queryRoleRights(lineage...)
+	if err != nil {
+		return nil, err
+	}
+	// 按继承的远近排列来源，角色自己排在最前面
+	depth := make(map[int]int, len(lineage))
+	for i, id := range lineage {
+		depth[id] = i
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].PsId != rows[j].PsId {
+			return rows[i].PsId < rows[j].PsId
+		}
+		return depth[rows[i].RoleId] < depth[rows[j].RoleId]
+	})
+
+	data := &ResEffectiveRightsData{RoleId: role.RoleId, RoleName: role.RoleName,
+		Ancestors: []ManagerRole{}, Rights: []*ResEffectiveRight{}}
+	for _, id := range lineage[1:] {
+		data.Ancestors = append(data.Ancestors, ManagerRole{id, roles[id].RoleName})
+	}
+	var last *ResEffectiveRight
+	for _, row := range rows {
+		if last == nil || last.PsId != row.PsId {
+			last = &ResEffectiveRight{PsId: row.PsId, PsName: row.PsName, PsPid: row.PsPid, PsApiPath: row.PsApiPath}
+			data.Rights = append(data.Rights, last)
+		}
+		last.Sources = append(last.Sources, ManagerRole{row.RoleId, roles[row.RoleId].RoleName})
+	}
+	return data, nil
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"github.com/astaxie/beego/orm"
+)
+
+// 数据库中sp_role_permission表的模型，保存角色拥有的权限（原先保存在sp_role.ps_ids中）
+type SpRolePermission struct {
+	Id     int `orm:"pk;auto"`
+	RoleId int `description:"角色id"`
+	PsId   int `description:"权限id"`
+}
+
+// 同一角色的同一权限只保存一条
+func (this *SpRolePermission) TableUnique() [][]string {
+	return [][]string{{"RoleId", "PsId"}}
+}
+
+// 查询角色拥有的所有权限id
+func RolePermissionIds(roleId int) (map[int]bool, error) {
+	var grants []SpRolePermission
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_role_permission").Filter("role_id", roleId).All(&grants, "PsId")
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int]bool, len(grants))
+	for _, g := range grants {
+		ids[g.PsId] = true
+	}
+	return ids, nil
+}
+
+// 用psIds替换角色原有的所有权限
+func SetRolePermissions(roleId int, psIds []int) error {
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	err = replaceRolePermissions(o, roleId, psIds)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	err = o.Commit()
+	if err != nil {
+		return err
+	}
+	forgetRoleVersions(roleId)
+	return nil
+}
+
+// 在调用方的事务中替换角色的所有权限，同时把角色的权限版本号加1，事务提交后需调用forgetRoleVersions
+func replaceRolePermissions(o orm.Ormer, roleId int, psIds []int) error {
+	_, err := o.QueryTable("sp_role_permission").Filter("role_id", roleId).Delete()
+	if err != nil {
+		return err
+	}
+	err = bumpRoleVersions(o, roleId)
+	if err != nil {
+		return err
+	}
+	grants := make([]SpRolePermission, 0, len(psIds))
+	seen := make(map[int]bool)
+	for _, psId := range psIds {
+		if !seen[psId] {
+			seen[psId] = true
+			grants = append(grants, SpRolePermission{RoleId: roleId, PsId: psId})
+		}
+	}
+	if len(grants) > 0 {
+		_, err = o.InsertMulti(len(grants), grants)
+	}
+	return err
+}
+
+// 取消角色的某个权限，第一个返回值表示角色原先是否拥有该权限
+func RevokeRolePermission(roleId, psId int) (bool, error) {
+	o := orm.NewOrm()
+	num, err := o.QueryTable("sp_role_permission").
+		Filter("role_id", roleId).
+		Filter("ps_id", psId).
+		Delete()
+	if err != nil || num == 0 {
+		return false, err
+	}
+	return true, BumpRoleVersions(roleId)
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpRolePermission))
+}
@@ -0,0 +1,369 @@
+package models
+
+import (
+	"JDStore/utils"
+	"crypto/subtle"
+	"errors"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"strings"
+	"time"
+)
+
+// sp_manager.mg_type：普通管理员和服务账号。服务账号供仓库、ERP等系统调用接口，
+// 不能通过login登录，只能使用API Key访问；它的权限和普通管理员一样来自所分配的角色
+const (
+	ManagerHuman   int8 = 0
+	ManagerService int8 = 1
+)
+
+// 数据库中sp_api_key表的模型。API Key只在创建时返回一次，数据库中只保存前缀和sha256值
+type SpApiKey struct {
+	Id           int    `orm:"pk;auto"`
+	MgId         int    `description:"服务账号id（sp_manager.mg_id）"`
+	Name         string `orm:"size(32)" description:"名称"`
+	Prefix       string `orm:"size(16);unique" description:"前缀，用于查找和识别Key"`
+	KeyHash      string `orm:"size(64)" description:"完整Key的sha256值"`
+	CreateTime   int    `description:"创建时间"`
+	LastUsedTime int    `description:"最后使用时间"`
+	RevokeTime   int    `description:"吊销时间，0表示未吊销"`
+}
+
+// 数据库中sp_api_key_permission表的模型，限制API Key只能使用服务账号的部分权限；没有记录表示不限制
+type SpApiKeyPermission struct {
+	Id    int `orm:"pk;auto"`
+	KeyId int `description:"API Key id"`
+	PsId  int `description:"权限id"`
+}
+
+// 同一Key的同一权限只保存一条
+func (this *SpApiKeyPermission) TableUnique() [][]string {
+	return [][]string{{"KeyId", "PsId"}}
+}
+
+// API Key的格式：jds_前缀_密钥
+const apiKeyPrefix = "jds_"
+
+// 保存在请求上下文中的API Key的key
+const apiKeyDataKey = "_apiKey"
+
+/* 创建服务账号时body中参数的结构 接口：service-accounts 请求方式：post */
+type ServiceAccountParams struct {
+	UserName string `json:"username" valid:"Required;MaxSize(32)"`
+	Rid      int    `json:"rid" valid:"Required"`
+}
+
+func (this *ServiceAccountParams) Valid(v *validation.Validation) {
+	o := orm.NewOrm()
+	if !o.QueryTable("sp_role").Filter("role_id", this.Rid).Exist() {
+		v.SetError("Rid", "角色id不存在")
+	}
+	if o.QueryTable("sp_manager").Filter("mg_name", this.UserName).Exist() {
+		v.SetError("UserName", "用户名已存在")
+	}
+}
+
+/* 创建API Key时body中参数的结构 接口：service-accounts/:id/keys 请求方式：post
+*  ps_ids为空表示可以使用服务账号的所有权限 */
+type ApiKeyParams struct {
+	Name  string `json:"name" valid:"Required;MaxSize(32)"`
+	PsIds []int  `json:"ps_ids"`
+}
+
+/* API Key返回的结构，Key只在创建时返回 */
+type ResApiKey struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	Prefix       string `json:"prefix"`
+	Key          string `json:"key,omitempty"`
+	PsIds        []int  `json:"ps_ids"`
+	CreateTime   int    `json:"create_time"`
+	LastUsedTime int    `json:"last_used_time"`
+	RevokeTime   int    `json:"revoke_time"`
+}
+
+/* 服务账号返回的结构 */
+type ResServiceAccount struct {
+	Id         int           `json:"id"`
+	UserName   string        `json:"username"`
+	Roles      []ManagerRole `json:"roles"`
+	MgState    bool          `json:"mg_state"`
+	CreateTime int           `json:"create_time"`
+	Keys       []*ResApiKey  `json:"keys"`
+}
+
+/* 创建、删除服务账号时返回数据的结构 */
+type ResServiceAccountInfo struct {
+	Data *ResServiceAccount `json:"data"`
+	Meta *ResMeta           `json:"meta"`
+}
+
+/* 获取服务账号列表时返回数据的结构 接口：service-accounts 请求方式：get */
+type ResServiceAccounts struct {
+	Data []*ResServiceAccount `json:"data"`
+	Meta *ResMeta             `json:"meta"`
+}
+
+/* 创建、吊销API Key时返回数据的结构 */
+type ResApiKeyInfo struct {
+	Data *ResApiKey `json:"data"`
+	Meta *ResMeta   `json:"meta"`
+}
+
+/* 创建服务账号，并分配角色。服务账号的密码是随机生成的，不会返回，也不能用于登录 */
+func CreateServiceAccount(params *ServiceAccountParams) (*ResServiceAccount, error) {
+	pwd, err := utils.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	// 服务账号只有一个主角色，role_id不能为0，否则会被当作超级管理员
+	manager := SpManager{MgName: params.UserName, MgPwd: utils.HashAndSalt(pwd), MgTime: int(time.Now().Unix()),
+		RoleId: params.Rid, MgState: 1, MgType: ManagerService}
+	o := orm.NewOrm()
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = o.Insert(&manager)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	_, err = o.Insert(&SpManagerRole{MgId: manager.MgId, RoleId: params.Rid})
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	err = o.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return GetServiceAccount(manager.MgId)
+}
+
+/* 查询服务账号 */
+func GetServiceAccount(mgId int) (*ResServiceAccount, error) {
+	manager := SpManager{MgId: mgId}
+	o := orm.NewOrm()
+	err := o.Read(&manager)
+	if err != nil || manager.MgType != ManagerService {
+		return nil, errors.New("服务账号不存在")
+	}
+	accounts, err := resServiceAccounts([]SpManager{manager})
+	if err != nil {
+		return nil, err
+	}
+	return accounts[0], nil
+}
+
+/* 查询所有服务账号 */
+func GetServiceAccounts() ([]*ResServiceAccount, error) {
+	var managers []SpManager
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_manager").Filter("mg_type", ManagerService).OrderBy("mg_id").All(&managers)
+	if err != nil {
+		return nil, err
+	}
+	return resServiceAccounts(managers)
+}
+
+/* 删除服务账号，它的API Key随之删除 */
+func DeleteServiceAccount(mgId int) error {
+	o := orm.NewOrm()
+	num, err := o.QueryTable("sp_manager").Filter("mg_id", mgId).Filter("mg_type", ManagerService).Delete()
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		return errors.New("服务账号不存在")
+	}
+	return nil
+}
+
+/* 为服务账号创建API Key，返回的Key只在这里出现一次 */
+func CreateApiKey(mgId int, params *ApiKeyParams) (*ResApiKey, error) {
+	o := orm.NewOrm()
+	if !o.QueryTable("sp_manager").Filter("mg_id", mgId).Filter("mg_type", ManagerService).Exist() {
+		return nil, errors.New("服务账号不存在")
+	}
+	if len(params.PsIds) > 0 {
+		count, err := o.QueryTable("sp_permission").Filter("ps_id__in", params.PsIds).Count()
+		if err != nil {
+			return nil, err
+		}
+		if int(count) != len(uniqueInts(params.PsIds)) {
+			return nil, errors.New("权限id不存在")
+		}
+	}
+	prefix, err := utils.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := utils.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix[:12]
+	key := apiKeyPrefix + prefix + "_" + secret
+	apiKey := SpApiKey{MgId: mgId, Name: params.Name, Prefix: prefix, KeyHash: utils.HashToken(key), CreateTime: int(time.Now().Unix())}
+
+	err = o.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = o.Insert(&apiKey)
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	psIds := uniqueInts(params.PsIds)
+	if len(psIds) > 0 {
+		perms := make([]SpApiKeyPermission, 0, len(psIds))
+		for _, psId := range psIds {
+			perms = append(perms, SpApiKeyPermission{KeyId: apiKey.Id, PsId: psId})
+		}
+		_, err = o.InsertMulti(len(perms), perms)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	err = o.Commit()
+	if err != nil {
+		return nil, err
+	}
+	res := resApiKey(&apiKey, psIds)
+	res.Key = key
+	return res, nil
+}
+
+/* 吊销服务账号的API Key */
+func RevokeApiKey(mgId, keyId int) (*ResApiKey, error) {
+	apiKey := SpApiKey{Id: keyId}
+	o := orm.NewOrm()
+	err := o.Read(&apiKey)
+	if err != nil || apiKey.MgId != mgId {
+		return nil, errors.New("API Key不存在")
+	}
+	if apiKey.RevokeTime > 0 {
+		return nil, errors.New("API Key已吊销")
+	}
+	apiKey.RevokeTime = int(time.Now().Unix())
+	_, err = o.Update(&apiKey, "RevokeTime")
+	if err != nil {
+		return nil, err
+	}
+	psIds, err := apiKeyPermissionIds(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return resApiKey(&apiKey, psIds), nil
+}
+
+/* 校验请求中的API Key，通过后保存到请求上下文中，之后CurrentManager返回Key所属的服务账号 */
+func AuthenticateApiKey(ctx *context.Context, key string) error {
+	parts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 {
+		return errors.New("API Key格式错误")
+	}
+	apiKey := SpApiKey{Prefix: parts[0]}
+	o := orm.NewOrm()
+	err := o.Read(&apiKey, "Prefix")
+	if err != nil || apiKey.RevokeTime > 0 {
+		return errors.New("API Key不存在或已吊销")
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(utils.HashToken(key))) != 1 {
+		return errors.New("API Key错误")
+	}
+	manager := SpManager{MgId: apiKey.MgId}
+	err = o.Read(&manager)
+	if err != nil || manager.MgType != ManagerService || manager.CheckActive() != nil {
+		return errors.New("服务账号不存在或已禁用")
+	}
+	apiKey.LastUsedTime = int(time.Now().Unix())
+	_, err = o.Update(&apiKey, "LastUsedTime")
+	if err != nil {
+		return err
+	}
+	ctx.Input.SetData(apiKeyDataKey, &apiKey)
+	return nil
+}
+
+// 请求使用的API Key，使用token访问时返回nil
+func requestApiKey(ctx *context.Context) *SpApiKey {
+	apiKey, _ := ctx.Input.GetData(apiKeyDataKey).(*SpApiKey)
+	return apiKey
+}
+
+// 判断API Key是否可以使用某个权限（还需要服务账号本身拥有该权限）
+func apiKeyAllows(apiKey *SpApiKey, psId int) bool {
+	o := orm.NewOrm()
+	qs := o.QueryTable("sp_api_key_permission").Filter("key_id", apiKey.Id)
+	return !qs.Exist() || qs.Filter("ps_id", psId).Exist()
+}
+
+// 查询API Key限定的权限id
+func apiKeyPermissionIds(keyId int) ([]int, error) {
+	var perms []SpApiKeyPermission
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_api_key_permission").Filter("key_id", keyId).OrderBy("ps_id").All(&perms, "PsId")
+	if err != nil {
+		return nil, err
+	}
+	psIds := make([]int, 0, len(perms))
+	for _, p := range perms {
+		psIds = append(psIds, p.PsId)
+	}
+	return psIds, nil
+}
+
+func resServiceAccounts(managers []SpManager) ([]*ResServiceAccount, error) {
+	accounts := make([]*ResServiceAccount, 0, len(managers))
+	o := orm.NewOrm()
+	for _, m := range managers {
+		roles, err := GetManagerRoles(m.MgId)
+		if err != nil {
+			return nil, err
+		}
+		var keys []*SpApiKey
+		_, err = o.QueryTable("sp_api_key").Filter("mg_id", m.MgId).OrderBy("id").All(&keys)
+		if err != nil {
+			return nil, err
+		}
+		account := ResServiceAccount{Id: m.MgId, UserName: m.MgName, Roles: roles, MgState: m.MgState == 1,
+			CreateTime: m.MgTime, Keys: make([]*ResApiKey, 0, len(keys))}
+		for _, k := range keys {
+			psIds, err := apiKeyPermissionIds(k.Id)
+			if err != nil {
+				return nil, err
+			}
+			account.Keys = append(account.Keys, resApiKey(k, psIds))
+		}
+		accounts = append(accounts, &account)
+	}
+	return accounts, nil
+}
+
+func resApiKey(k *SpApiKey, psIds []int) *ResApiKey {
+	return &ResApiKey{Id: k.Id, Name: k.Name, Prefix: apiKeyPrefix + k.Prefix, PsIds: psIds,
+		CreateTime: k.CreateTime, LastUsedTime: k.LastUsedTime, RevokeTime: k.RevokeTime}
+}
+
+// 去掉重复的id
+func uniqueInts(ids []int) []int {
+	seen := make(map[int]bool)
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpApiKey), new(SpApiKeyPermission))
+}
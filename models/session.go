@@ -0,0 +1,221 @@
+package models
+
+import (
+	"JDStore/utils"
+	"errors"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+	"time"
+)
+
+// 数据库中sp_manager_session表的模型，每次登录生成一个会话，刷新token时延续同一个会话。
+// 会话被远程退出后记录revoke_time，并吊销该会话签发的所有token。鉴权时以这里的记录为准，
+// 吊销列表使用内存存储时，重启服务或部署多个实例也不会使已退出会话的token重新生效
+type SpManagerSession struct {
+	Id           int    `orm:"pk;auto"`
+	Sid          string `orm:"size(32);unique" description:"会话id，保存在token的sid中"`
+	MgId         int    `description:"管理员id"`
+	UserAgent    string `orm:"size(255)" description:"登录设备（User-Agent）"`
+	Ip           string `orm:"size(64)" description:"登录ip"`
+	IssueTime    int    `description:"登录时间"`
+	LastSeenTime int    `description:"最后访问时间"`
+	ExpireTime   int    `description:"到期时间，每次刷新token时延长"`
+	RevokeTime   int    `description:"退出时间，0表示未退出"`
+	RefreshJti   string `orm:"size(32)" description:"当前有效的refresh token的jti，每次刷新时轮换"`
+}
+
+// 最后访问时间的更新间隔（秒），避免每个请求都写一次数据库
+const sessionTouchInterval = 60
+
+var (
+	// 会话已退出、已到期或不存在
+	ErrSessionRevoked = errors.New("会话不存在或已退出")
+	// 已经换取过新token的refresh token被再次使用，整个会话已退出
+	ErrRefreshReused = errors.New("refresh token已被使用，请重新登录")
+)
+
+/* 登录会话返回的结构 */
+type ResSession struct {
+	Sid          string `json:"id"`
+	UserAgent    string `json:"user_agent"`
+	Ip           string `json:"ip"`
+	IssueTime    int    `json:"issued_at"`
+	LastSeenTime int    `json:"last_seen"`
+	ExpireTime   int    `json:"expire_time"`
+	/* 是否是发起本次请求的会话 */
+	Current bool `json:"current"`
+}
+
+/* 获取登录会话列表时返回数据的结构 接口：users/:id/sessions 请求方式：get */
+type ResSessionList struct {
+	Data []*ResSession `json:"data"`
+	Meta *ResMeta      `json:"meta"`
+}
+
+/* 退出登录会话时返回数据的结构 接口：users/:id/sessions/:sid 请求方式：delete */
+type ResSessionInfo struct {
+	Data *ResSession `json:"data"`
+	Meta *ResMeta    `json:"meta"`
+}
+
+/* 登录成功时创建会话，返回会话id。refreshJti为同时签发的refresh token的jti */
+func CreateSession(manager *SpManager, ip, userAgent, refreshJti string) (string, error) {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := int(time.Now().Unix())
+	session := SpManagerSession{Sid: utils.NewSessionId(), MgId: manager.MgId, UserAgent: userAgent, Ip: ip,
+		IssueTime: now, LastSeenTime: now, ExpireTime: now + int(utils.RefreshTokenExp().Seconds()),
+		RefreshJti: refreshJti}
+	o := orm.NewOrm()
+	_, err := o.Insert(&session)
+	if err != nil {
+		return "", err
+	}
+	return session.Sid, nil
+}
+
+/* 刷新token时轮换会话的refresh token并延长会话的到期时间。oldJti为本次使用的refresh token的jti，
+*  newJti为新签发的refresh token的jti。只有oldJti是会话当前的refresh token时才能更新成功，
+*  并发使用同一个refresh token时只有一个成功；已经换取过新token的refresh token再次使用时
+*  （可能已泄露）退出整个会话，返回ErrRefreshReused */
+func RotateSession(sid, oldJti, newJti string) error {
+	now := int(time.Now().Unix())
+	o := orm.NewOrm()
+	// 升级前创建的会话没有记录refresh token，第一次刷新时记录
+	num, err := o.QueryTable("sp_manager_session").
+		Filter("sid", sid).
+		Filter("revoke_time", 0).
+		Filter("expire_time__gt", now).
+		Filter("refresh_jti__in", oldJti, "").
+		Update(orm.Params{"refresh_jti": newJti, "last_seen_time": now,
+			"expire_time": now + int(utils.RefreshTokenExp().Seconds())})
+	if err != nil {
+		return err
+	}
+	if num > 0 {
+		return nil
+	}
+	session := SpManagerSession{Sid: sid}
+	err = o.Read(&session, "Sid")
+	if err != nil || session.RevokeTime > 0 || session.ExpireTime <= now {
+		return ErrSessionRevoked
+	}
+	logs.Warn("refresh token被重复使用，退出会话", sid)
+	_, err = RevokeSession(session.MgId, sid)
+	if err != nil {
+		logs.Error("退出会话出错", err)
+	}
+	return ErrRefreshReused
+}
+
+/* 校验access token所属的会话，由路由拦截器在token校验通过后调用：会话已退出、已到期，
+*  或者管理员已被删除、禁用时返回错误。同时更新会话的最后访问时间。升级前签发的token没有会话，不校验 */
+func CheckSession(sid string) error {
+	if sid == "" {
+		return nil
+	}
+	var mgId, lastSeenTime, expireTime, revokeTime, mgState, deletedAt int
+	o := orm.NewOrm()
+	err := o.Raw(`
+		SELECT
+			t1.mg_id, t1.last_seen_time, t1.expire_time, t1.revoke_time, IFNULL(t2.mg_state, 0), t2.deleted_at
+		FROM
+			sp_manager_session AS t1 JOIN sp_manager AS t2
+		ON
+			t1.mg_id = t2.mg_id
+		WHERE
+			t1.sid = ?`, sid).QueryRow(&mgId, &lastSeenTime, &expireTime, &revokeTime, &mgState, &deletedAt)
+	if err == orm.ErrNoRows {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	now := int(time.Now().Unix())
+	if revokeTime > 0 || expireTime <= now {
+		return ErrSessionRevoked
+	}
+	if deletedAt > 0 {
+		return ErrManagerDeleted
+	}
+	if mgState != 1 {
+		return ErrManagerDisabled
+	}
+	if lastSeenTime < now-sessionTouchInterval {
+		_, err = o.QueryTable("sp_manager_session").Filter("sid", sid).Update(orm.Params{"last_seen_time": now})
+		if err != nil {
+			logs.Error("更新会话最后访问时间出错", err)
+		}
+	}
+	return nil
+}
+
+/* 查询管理员未退出且未到期的会话，按最后访问时间倒序。currentSid为发起请求的会话 */
+func GetManagerSessions(mgId int, currentSid string) ([]*ResSession, error) {
+	var sessions []*SpManagerSession
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_manager_session").
+		Filter("mg_id", mgId).
+		Filter("revoke_time", 0).
+		Filter("expire_time__gt", time.Now().Unix()).
+		OrderBy("-last_seen_time").
+		All(&sessions)
+	if err != nil {
+		return nil, err
+	}
+	resList := make([]*ResSession, 0, len(sessions))
+	for _, s := range sessions {
+		resList = append(resList, resSession(s, currentSid))
+	}
+	return resList, nil
+}
+
+/* 退出管理员的一个会话，该会话签发的access token和refresh token随即失效 */
+func RevokeSession(mgId int, sid string) (*ResSession, error) {
+	session := SpManagerSession{Sid: sid}
+	o := orm.NewOrm()
+	err := o.Read(&session, "Sid")
+	if err != nil || session.MgId != mgId {
+		return nil, errors.New("会话不存在")
+	}
+	if session.RevokeTime > 0 {
+		return nil, errors.New("会话已退出")
+	}
+	session.RevokeTime = int(time.Now().Unix())
+	_, err = o.Update(&session, "RevokeTime")
+	if err != nil {
+		return nil, err
+	}
+	utils.RevokeSession(sid)
+	return resSession(&session, ""), nil
+}
+
+/* 吊销管理员已签发的所有token（删除、禁用管理员或修改密码时调用）：退出该管理员所有未退出的会话，
+*  并把之前签发的token加入吊销列表（升级前签发的token没有会话，只能通过吊销列表吊销） */
+func RevokeManagerTokens(mgId int, userName string) {
+	var sessions []*SpManagerSession
+	o := orm.NewOrm()
+	_, err := o.QueryTable("sp_manager_session").Filter("mg_id", mgId).Filter("revoke_time", 0).All(&sessions, "Sid")
+	if err != nil {
+		logs.Error("查询管理员的登录会话出错", err)
+	}
+	_, err = o.QueryTable("sp_manager_session").Filter("mg_id", mgId).Filter("revoke_time", 0).
+		Update(orm.Params{"revoke_time": time.Now().Unix()})
+	if err != nil {
+		logs.Error("退出管理员的登录会话出错", err)
+	}
+	for _, session := range sessions {
+		utils.RevokeSession(session.Sid)
+	}
+	utils.RevokeUserTokens(userName)
+}
+
+func resSession(s *SpManagerSession, currentSid string) *ResSession {
+	return &ResSession{s.Sid, s.UserAgent, s.Ip, s.IssueTime, s.LastSeenTime, s.ExpireTime, s.Sid == currentSid}
+}
+
+// 初始化模型
+func init() {
+	orm.RegisterModel(new(SpManagerSession))
+}
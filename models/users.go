@@ -5,6 +5,8 @@ import (
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/validation"
+	"strconv"
+	"strings"
 )
 
 /* 获取get请求中的参数（users接口） */
@@ -14,15 +16,28 @@ type UsersParams struct {
 	Pagesize int `valid:"Required;Min(1)"`
 }
 
-/*定义login接口返回响应数据中的data中的users结构（users接口）*/
+/*定义login接口返回响应数据中的data中的users结构（users接口）。
+* 管理员可以拥有多个角色，role_name是用逗号连接的所有角色名称，role_names是角色名称列表*/
 type ResUser struct {
-	MgId     int    `json:"id"`
-	RoleName string `json:"role_name"`
-	MgName   string `json:"username"`
-	MgTime   int    `json:"create_time"`
-	MgMobile string `json:"mobile"`
-	MgEmail  string `json:"email"`
-	MgState  bool   `json:"mg_state"`
+	MgId      int      `json:"id"`
+	RoleName  string   `json:"role_name"`
+	RoleNames []string `json:"role_names"`
+	MgName    string   `json:"username"`
+	MgTime    int      `json:"create_time"`
+	MgMobile  string   `json:"mobile"`
+	MgEmail   string   `json:"email"`
+	MgState   bool     `json:"mg_state"`
+	/* 删除时间，未删除的管理员为0 */
+	DeletedAt int `json:"deleted_at"`
+	/* 最后登录时间（从未登录为0）和IP */
+	LastLoginAt int    `json:"last_login_at"`
+	LastLoginIp string `json:"last_login_ip"`
+	/* 标记为长期未登录的时间，未标记为0 */
+	InactiveAt int `json:"inactive_at"`
+	/* 所有角色的id，与role_names对应，超级管理员为空 */
+	RoleIds []int `json:"role_ids"`
+	/* 查询结果中用逗号连接的角色id，用于生成RoleIds */
+	RoleIdList string `json:"-"`
 }
 
 /*定义login接口返回响应数据中的data结构（users接口）*/
@@ -48,9 +63,14 @@ type ResUsers struct {
 type SpRole struct {
 	RoleId   int    `orm:"pk;auto;size(6)" description:"主键id"`
 	RoleName string `orm:"size(20)" description:"角色名称"`
-	PsIds    string `orm:"size(512)" description:"权限"`
 	PsCa     string `orm:"null" description:"控制器-操作"`
 	RoleDesc string `orm:"null" description:"角色描述"`
+	/* 该角色的管理员是否必须启用两步验证 */
+	MfaRequired int8 `orm:"null" description:"1:必须启用两步验证"`
+	/* 父角色，角色继承父角色（以及所有祖先角色）的权限 */
+	RolePid int `orm:"null" description:"父角色id，0表示没有父角色"`
+	/* 权限或父角色变化时加1，拥有该角色的管理员已签发的access token随之失效 */
+	PermVersion int `orm:"default(0)" description:"权限版本号"`
 }
 
 /* 修改用户状态时返回的数据中data格式 */
@@ -72,7 +92,7 @@ type ResUserState struct {
 /* 添加用户时获取参数的数据结构（uers接口post） */
 type CreateUser struct {
 	UserName   string `valid:"Required;MinSize(3);MaxSize(10)"`
-	PassWord   string `valid:"Required;MinSize(6);MaxSize(15)"`
+	PassWord   string `valid:"Required;MaxSize(32)"`
 	ConfirmPwd string `valid:"Required;"`
 	Email      string `valid:"Required;Email"`
 	Mobile     string `valid:"Required;Mobile"`
@@ -137,45 +157,180 @@ func (this *CreateUser) Valid(v *validation.Validation) {
 		// 通过 SetError 设置 Name 的错误信息，HasErrors 将会返回 true
 		v.SetError("ConfirmPwd", "两次密码输入不一致")
 	}
+	// 新用户的密码同样需要符合密码策略
+	if err := CheckPasswordStrength(this.UserName, this.PassWord); err != nil {
+		v.SetError("PassWord", err.Error())
+	}
+}
+
+/* 查询管理员列表的条件，除分页外都是可选的 */
+type ManagerSearchParams struct {
+	Pagenum  int
+	Pagesize int
+	/* 用户名包含的内容 */
+	Query string
+	/* 邮箱、手机号包含的内容 */
+	Email  string
+	Mobile string
+	/* 拥有该角色的管理员，0表示超级管理员，-1表示不限 */
+	RoleId int
+	/* 1表示启用，0表示禁用，-1表示不限 */
+	State int
+	/* 为true时只查询已删除的管理员，否则只查询未删除的 */
+	Deleted bool
+	/* 创建时间范围（unix时间戳），0表示不限 */
+	StartTime int
+	EndTime   int
+	/* 排序字段（managerSortColumns中的字段），为空时按id排序 */
+	SortBy string
+	Desc   bool
+}
+
+// 可用于排序的字段（与ResUser中的json名称相同）及对应的sql表达式
+var managerSortColumns = map[string]string{
+	"id":            "t1.mg_id",
+	"username":      "t1.mg_name",
+	"email":         "t1.mg_email",
+	"mobile":        "t1.mg_mobile",
+	"role_name":     "role_name",
+	"create_time":   "t1.mg_time",
+	"mg_state":      "t1.mg_state",
+	"deleted_at":    "t1.deleted_at",
+	"last_login_at": "t1.last_login_at",
+	"last_login_ip": "t1.last_login_ip",
+	"inactive_at":   "t1.inactive_at",
+}
+
+// 判断是否可以按该字段排序
+func IsManagerSortColumn(column string) bool {
+	_, ok := managerSortColumns[column]
+	return ok
 }
 
-/* sql查询管理员列表 */
-func GetManagers(query string, pageNum int, pageSize int) ([]ResUser, error) {
+// LIKE查询的参数，转义其中的通配符
+func containsPattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// 根据查询条件生成WHERE子句（不含WHERE）及其参数
+func managerSearchWhere(params *ManagerSearchParams) (string, []interface{}) {
+	conds := []string{"t1.mg_type = ?"}
+	args := []interface{}{ManagerHuman}
+	if params.Deleted {
+		conds = append(conds, "t1.deleted_at > 0")
+	} else {
+		conds = append(conds, "t1.deleted_at = 0")
+	}
+	if params.Query != "" {
+		conds = append(conds, "t1.mg_name LIKE ?")
+		args = append(args, containsPattern(params.Query))
+	}
+	if params.Email != "" {
+		conds = append(conds, "t1.mg_email LIKE ?")
+		args = append(args, containsPattern(params.Email))
+	}
+	if params.Mobile != "" {
+		conds = append(conds, "t1.mg_mobile LIKE ?")
+		args = append(args, containsPattern(params.Mobile))
+	}
+	if params.RoleId == 0 {
+		conds = append(conds, "t1.role_id = 0")
+	} else if params.RoleId > 0 {
+		conds = append(conds, "EXISTS (SELECT 1 FROM sp_manager_role AS r WHERE r.mg_id = t1.mg_id AND r.role_id = ?)")
+		args = append(args, params.RoleId)
+	}
+	if params.State >= 0 {
+		// 早期创建的管理员mg_state可能为NULL，按禁用处理
+		conds = append(conds, "IFNULL(t1.mg_state, 0) = ?")
+		args = append(args, params.State)
+	}
+	if params.StartTime > 0 {
+		conds = append(conds, "t1.mg_time >= ?")
+		args = append(args, params.StartTime)
+	}
+	if params.EndTime > 0 {
+		conds = append(conds, "t1.mg_time <= ?")
+		args = append(args, params.EndTime)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+/* 按条件分页查询管理员列表，同时返回符合条件的总记录数 */
+func SearchManagers(params *ManagerSearchParams) (int64, []ResUser, error) {
+	where, args := managerSearchWhere(params)
+	o := orm.NewOrm()
+	var total int64
+	err := o.Raw("SELECT COUNT(*) FROM sp_manager AS t1 WHERE "+where, args...).QueryRow(&total)
+	if err != nil {
+		logs.Error(err)
+		return 0, nil, err
+	}
+
+	orderBy := "t1.mg_id"
+	if column, ok := managerSortColumns[params.SortBy]; ok {
+		orderBy = column
+	}
+	if params.Desc {
+		orderBy += " DESC"
+	}
 	/* 计算LIMIT后的查询起始位置start（从0开始）
 	*  start = [ 当前页码(不含0)-1 ] × 每页显示的记录数 */
-	start := (pageNum - 1) * pageSize
+	start := (params.Pagenum - 1) * params.Pagesize
+	// 排序字段和方向只能是上面固定的内容，其他条件都通过参数传入
 	sqlStr := fmt.Sprintf(`
 		SELECT
 			t1.mg_id,
-			(CASE WHEN t2.role_name is null && t1.role_id = 0 
-				THEN '超级管理员' ELSE t2.role_name END) AS role_name,
+			(CASE WHEN t1.role_id = 0 
+				THEN '超级管理员' ELSE GROUP_CONCAT(t3.role_name ORDER BY t2.id SEPARATOR ',') END) AS role_name,
+			IFNULL(GROUP_CONCAT(t2.role_id ORDER BY t2.id SEPARATOR ','), '') AS role_id_list,
 			t1.mg_name,
 			t1.mg_time,
 			t1.mg_mobile,
 			t1.mg_email,
-			t1.mg_state
+			t1.mg_state,
+			t1.deleted_at,
+			t1.last_login_at,
+			t1.last_login_ip,
+			t1.inactive_at
 		FROM
-			sp_manager AS t1 LEFT JOIN sp_role AS t2
+			sp_manager AS t1 LEFT JOIN sp_manager_role AS t2
 		ON
-			t1.role_id = t2.role_id
+			t1.mg_id = t2.mg_id LEFT JOIN sp_role AS t3
+		ON
+			t2.role_id = t3.role_id
 		WHERE
-			t1.mg_name LIKE '%%%s%%'
+			%s
+		GROUP BY
+			t1.mg_id
+		ORDER BY
+			%s, t1.mg_id
 		LIMIT 
-			%d,%d
-		`, query, start, pageSize)
+			?,?
+		`, where, orderBy)
 	var managers []ResUser
-	/* 根据菜单等级选择最终执行的相应sql语句 */
-	o := orm.NewOrm()
-	raws, err := o.Raw(sqlStr).QueryRows(&managers)
+	raws, err := o.Raw(sqlStr, append(args, start, params.Pagesize)...).QueryRows(&managers)
 	if err != nil {
 		logs.Error(err)
-		return nil, err
+		return 0, nil, err
 	}
 	/* 查询到的记录数为0时，返回[]，而不是null */
 	if raws == 0 {
 		managers = []ResUser{}
 	}
-	return managers, nil
+	for i := range managers {
+		managers[i].RoleNames = []string{}
+		if managers[i].RoleName != "" {
+			managers[i].RoleNames = strings.Split(managers[i].RoleName, ",")
+		}
+		managers[i].RoleIds = []int{}
+		for _, id := range strings.Split(managers[i].RoleIdList, ",") {
+			if roleId, err := strconv.Atoi(id); err == nil {
+				managers[i].RoleIds = append(managers[i].RoleIds, roleId)
+			}
+		}
+	}
+	return total, managers, nil
 }
 
 //初始化模型
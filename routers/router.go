@@ -3,7 +3,6 @@ package routers
 import (
 	"JDStore/controllers"
 	"JDStore/models"
-	"JDStore/utils"
 	"encoding/json"
 	"fmt"
 	"github.com/astaxie/beego"
@@ -11,6 +10,7 @@ import (
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/plugins/cors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -34,54 +34,68 @@ func init() {
 		AllowCredentials: true,
 	}))
 
-	// 路由拦截器
-	beego.InsertFilter(baseURL+"*", beego.BeforeRouter, func(ctx *context.Context) {
-		// jwt鉴权
-		var token string
-		if strings.Index(ctx.Request.RequestURI, "/login") >= 0 {
-			// url中包含/login  ，放行
-			return
-		} else {
-			// 获取请求头中的token
-			token = ctx.Input.Header("Authorization")
-			// 去掉token值前面的“Bearer ”部分，截取出后面真正的token值
-			if token != "" {
-				token = strings.Split(token, " ")[1]
-				// logs.Info("token:",token)
-			}
-			logs.Info("current router path is ", ctx.Request.RequestURI)
-		}
-		if token == "" || utils.CheckToken(token) == "" {
-			// 验证未通过（请求头中不含有令牌，或者令牌未通过验证）
-			var resLogin models.ResLogin
-			resLogin.Meta = &models.ResMeta{"无效token", 401}
-			logs.Error("请求中不包含token或token无效")
-			res, _ := json.Marshal(resLogin)
-			ctx.ResponseWriter.Write(res)
-		} else if utils.CheckToken(token) != "" {
-			// 验证token通过
-			logs.Info(fmt.Sprintf("用户:%v 鉴权成功", utils.CheckToken(token)))
-		}
-	})
+	// 路由拦截器：jwt鉴权
+	beego.InsertFilter(baseURL+"*", beego.BeforeRouter, CheckToken)
+
+	// 接口权限拦截器，在jwt鉴权通过之后执行（jwt鉴权未通过时已输出响应，不会再执行到这里）
+	beego.InsertFilter(baseURL+"*", beego.BeforeRouter, CheckApiRight)
+
+	// 审计日志：执行前记录修改前的数据，执行后记录修改后的数据（响应已输出，需要设置returnOnOutput为false）
+	beego.InsertFilter(baseURL+"*", beego.BeforeRouter, models.StartAudit)
+	beego.InsertFilter(baseURL+"*", beego.AfterExec, models.FinishAudit, false)
 
 	beego.Router("/", &controllers.MainController{})
+	beego.Router("/.well-known/jwks.json", &controllers.JwksController{}, "get:GetJwks")
 	beego.Router(baseURL+"login", &controllers.LoginController{}, "post:HandlePost")
+	beego.Router(baseURL+"login/refresh", &controllers.LoginController{}, "post:RefreshToken")
+	beego.Router(baseURL+"login/mfa", &controllers.LoginController{}, "post:HandleMfa")
+	beego.Router(baseURL+"login/mfa/enroll", &controllers.LoginController{}, "post:HandleMfaEnroll")
+	beego.Router(baseURL+"login/password/reset", &controllers.LoginController{}, "post:ResetPassword")
+	beego.Router(baseURL+"login/oidc", &controllers.LoginController{}, "get:OidcLoginURL;post:HandleOidcLogin")
+	beego.Router(baseURL+"logout", &controllers.LoginController{}, "post:Logout")
+	beego.Router(baseURL+"mfa", &controllers.UsersController{}, "post:StartMfa;put:ConfirmMfa")
 	beego.Router(baseURL+"menus", &controllers.MenusController{}, "get:HandleGetMenus")
 	beego.Router(baseURL+"users", &controllers.UsersController{},
 		"get:HandleGetUsers;post:AddUser")
+	beego.Router(baseURL+"users/import", &controllers.UsersController{}, "post:ImportUsers")
+	beego.Router(baseURL+"users/export", &controllers.UsersController{}, "get:ExportUsers")
 	beego.Router(baseURL+"users/:uId/state/:type", &controllers.UsersController{}, "put:PutUserState")
 	beego.Router(baseURL+"users/:id", &controllers.UsersController{},
 		"get:GetUserInfo;put:UpdateUserInfo;delete:DeleteUser")
 	beego.Router(baseURL+"rights/:type", &controllers.RightsController{}, "get:GetRightsList")
+	beego.Router(baseURL+"rights", &controllers.RightsController{}, "post:AddPermission")
+	beego.Router(baseURL+"rights/order", &controllers.RightsController{}, "put:SortPermissions")
+	beego.Router(baseURL+"rights/:id", &controllers.RightsController{}, "put:UpdatePermission;delete:DeletePermission")
 	beego.Router(baseURL+"roles", &controllers.RightsController{},
 		"get:GetRolesList;post:AddRole")
 	beego.Router(baseURL+"roles/:roleId/rights/:rightId", &controllers.RightsController{},
 		"delete:DeleteRight")
 	beego.Router(baseURL+"roles/:roleId/rights", &controllers.RightsController{},
 		"post:UpdateRoleRights")
-	beego.Router(baseURL+"users/:id/role", &controllers.UsersController{}, "put:UpdateRole")
+	beego.Router(baseURL+"users/:id/roles", &controllers.UsersController{}, "post:AddUserRole")
+	beego.Router(baseURL+"users/:id/roles/:roleId", &controllers.UsersController{}, "delete:RemoveUserRole")
+	beego.Router(baseURL+"users/:id/grants", &controllers.GrantsController{}, "get:GetUserGrants;post:AddUserGrant")
+	beego.Router(baseURL+"users/:id/grants/:grantId", &controllers.GrantsController{}, "delete:RevokeUserGrant")
+	beego.Router(baseURL+"grants/expiring", &controllers.GrantsController{}, "get:GetExpiringGrants")
+	beego.Router(baseURL+"users/:id/sessions", &controllers.SessionsController{}, "get:GetUserSessions")
+	beego.Router(baseURL+"users/:id/logins", &controllers.SessionsController{}, "get:GetUserLogins")
+	beego.Router(baseURL+"users/:id/sessions/:sid", &controllers.SessionsController{}, "delete:RevokeUserSession")
+	beego.Router(baseURL+"service-accounts", &controllers.ServiceAccountsController{},
+		"get:GetServiceAccounts;post:AddServiceAccount")
+	beego.Router(baseURL+"service-accounts/:id", &controllers.ServiceAccountsController{}, "delete:DeleteServiceAccount")
+	beego.Router(baseURL+"service-accounts/:id/keys", &controllers.ServiceAccountsController{}, "post:AddApiKey")
+	beego.Router(baseURL+"service-accounts/:id/keys/:keyId", &controllers.ServiceAccountsController{}, "delete:RevokeApiKey")
+	beego.Router(baseURL+"users/:id/restore", &controllers.UsersController{}, "post:RestoreUser")
+	beego.Router(baseURL+"users/:id/purge", &controllers.UsersController{}, "delete:PurgeUser")
+	beego.Router(baseURL+"users/:id/unlock", &controllers.UsersController{}, "put:UnlockUser")
+	beego.Router(baseURL+"users/:id/mfa", &controllers.UsersController{}, "delete:ResetMfa")
+	beego.Router(baseURL+"users/:id/password", &controllers.UsersController{}, "put:UpdatePassword")
+	beego.Router(baseURL+"users/:id/password/reset", &controllers.UsersController{}, "post:SendPasswordReset")
 	beego.Router(baseURL+"roles/:id", &controllers.RightsController{},
 		"put:UpdateRoleInfo;delete:DeleteRole")
+	beego.Router(baseURL+"roles/:id/mfa", &controllers.RightsController{}, "put:UpdateRoleMfa")
+	beego.Router(baseURL+"roles/:id/scope", &controllers.RightsController{}, "get:GetRoleScope;put:UpdateRoleScope")
+	beego.Router(baseURL+"roles/:id/effective-rights", &controllers.RightsController{}, "get:GetEffectiveRights")
 	beego.Router(baseURL+"categories", &controllers.GoodsController{},
 		"get:GetGoodsCate;post:AddGoodsCate")
 	beego.Router(baseURL+"categories/:id", &controllers.GoodsController{},
@@ -95,18 +109,146 @@ func init() {
 	beego.Router(baseURL+"upload", &controllers.GoodsController{},
 		"post:UploadPicture")
 	beego.Router(baseURL+"goods/:id", &controllers.GoodsController{},
-		"put:UpdateGoodInfo;delete:DeleteGood")
+		"get:GetGoodInfo;put:UpdateGoodInfo;delete:DeleteGood")
 	beego.Router(baseURL+"orders", &controllers.OrdersController{},
 		"get:GetOrdersList")
 	beego.Router(baseURL+"orders/:order_id", &controllers.OrdersController{},
 		"put:UpdateOrderAddr")
 	beego.Router(baseURL+"reports/type/1", &controllers.ReportController{},
 		"get:GetReport")
+	beego.Router(baseURL+"audit-logs", &controllers.AuditController{}, "get:GetAuditLogs")
+	beego.Router(baseURL+"change-requests", &controllers.ApprovalController{}, "get:GetChangeRequests")
+	beego.Router(baseURL+"change-requests/:id/approve", &controllers.ApprovalController{}, "put:ApproveChangeRequest")
+	beego.Router(baseURL+"change-requests/:id/reject", &controllers.ApprovalController{}, "put:RejectChangeRequest")
 }
 
 func TransparentStatic(ctx *context.Context) {
 	if strings.Index(ctx.Request.URL.Path, "v1/") >= 0 {
 		return
 	}
+	// JWKS由JwksController返回，不是静态文件
+	if strings.HasPrefix(ctx.Request.URL.Path, "/.well-known/") {
+		return
+	}
 	http.ServeFile(ctx.ResponseWriter, ctx.Request, "static/"+ctx.Request.URL.Path)
 }
+
+// 接口的请求路径（去掉基准URL和首尾的“/”），例如 users/500/logins
+func requestPath(ctx *context.Context) string {
+	return strings.Trim(strings.TrimPrefix(ctx.Request.URL.Path, beego.AppConfig.String("baseURL")), "/")
+}
+
+// 登录、两步验证、刷新token、OpenID Connect登录等接口，这些接口不需要携带token
+func isLoginRoute(path string) bool {
+	return path == "login" || strings.HasPrefix(path, "login/")
+}
+
+// jwt鉴权：校验请求头中的token（或服务账号的API Key），通过后把身份信息保存到请求上下文中
+func CheckToken(ctx *context.Context) {
+	// jwt鉴权
+	var token string
+	if isLoginRoute(requestPath(ctx)) {
+		// 登录、刷新token等接口不需要携带token，放行
+		return
+	} else if key := ctx.Input.Header("Authorization"); strings.HasPrefix(key, "ApiKey ") {
+		// 服务账号使用“ApiKey <key>”代替token访问接口，之后的权限校验和普通管理员相同
+		if err := models.AuthenticateApiKey(ctx, strings.TrimPrefix(key, "ApiKey ")); err != nil {
+			var resLogin models.ResLogin
+			resLogin.Meta = &models.ResMeta{"无效API Key", 401}
+			logs.Error("API Key验证未通过", err)
+			res, _ := json.Marshal(resLogin)
+			ctx.ResponseWriter.Write(res)
+		}
+		return
+	} else {
+		// 获取请求头中的token
+		token = ctx.Input.Header("Authorization")
+		// 去掉token值前面的“Bearer ”部分，截取出后面真正的token值
+		if token != "" {
+			token = strings.Split(token, " ")[1]
+			// logs.Info("token:",token)
+		}
+		logs.Info("current router path is ", ctx.Request.RequestURI)
+	}
+	if token == "" {
+		// 验证未通过（请求头中不含有令牌）
+		var resLogin models.ResLogin
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		logs.Error("请求中不包含token")
+		res, _ := json.Marshal(resLogin)
+		ctx.ResponseWriter.Write(res)
+	} else if err := models.AuthenticateToken(ctx, token); err != nil {
+		// 令牌未通过验证，或者签发后权限已变化（需要用refresh token换取新的token）
+		var resLogin models.ResLogin
+		resLogin.Meta = &models.ResMeta{"无效token", 401}
+		if err == models.ErrPermissionChanged {
+			resLogin.Meta.Msg = err.Error()
+		}
+		logs.Error("token无效", err)
+		res, _ := json.Marshal(resLogin)
+		ctx.ResponseWriter.Write(res)
+	} else {
+		// 验证token通过，token中的身份信息已保存到请求上下文中
+		logs.Info(fmt.Sprintf("用户:%v 鉴权成功", models.CurrentIdentity(ctx).UserName))
+	}
+}
+
+// 登录后即可访问、不需要校验接口权限的路由（不含基准URL）
+var rightFreeRoutes = map[string]bool{
+	"menus":  true,
+	"logout": true,
+	"mfa":    true,
+}
+
+// 管理员操作自己时不需要校验接口权限的路由及其请求方式，路由中的:id必须是当前管理员的id
+var selfRoutes = map[string]string{
+	"users/:id/password":      "PUT",
+	"users/:id/sessions":      "GET",
+	"users/:id/logins":        "GET",
+	"users/:id/sessions/:sid": "DELETE",
+}
+
+// 判断请求是否是管理员对自己的操作
+func isSelfRoute(ctx *context.Context, path string) bool {
+	for route, method := range selfRoutes {
+		if method != ctx.Input.Method() {
+			continue
+		}
+		params, ok := models.RouteParams(route, path)
+		if !ok {
+			continue
+		}
+		// 使用API Key访问时没有token中的身份信息，需要查询服务账号
+		if identity := models.CurrentIdentity(ctx); identity != nil {
+			return strconv.Itoa(identity.MgId) == params[":id"]
+		}
+		manager, err := models.CurrentManager(ctx)
+		return err == nil && strconv.Itoa(manager.MgId) == params[":id"]
+	}
+	return false
+}
+
+// 根据请求方式和路由，从sp_permission_api表中找出接口所需的权限，校验当前用户是否拥有该权限
+func CheckApiRight(ctx *context.Context) {
+	path := requestPath(ctx)
+	// 登录、刷新token的接口不需要携带token
+	if isLoginRoute(path) || rightFreeRoutes[path] {
+		return
+	}
+	if isSelfRoute(ctx, path) {
+		return
+	}
+	// 没有配置权限的接口只有超级管理员可以访问，ValidateRight中不会存在id为-1的权限
+	psId, ok := models.MatchApiRight(ctx.Input.Method(), path)
+	if !ok {
+		logs.Warn(fmt.Sprintf("接口 %s %s 没有在sp_permission_api中配置权限", ctx.Input.Method(), path))
+		psId = -1
+	}
+	if !models.ValidateRight(ctx, psId) {
+		var resLogin models.ResLogin
+		resLogin.Meta = &models.ResMeta{"权限不足", 403}
+		logs.Error("权限不足")
+		res, _ := json.Marshal(resLogin)
+		ctx.ResponseWriter.Write(res)
+	}
+}
@@ -0,0 +1,100 @@
+package routers
+
+import (
+	"JDStore/models"
+	"JDStore/utils"
+	"encoding/json"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 与conf/app.conf相同的基准URL，测试时不读取配置文件
+const testBaseURL = "/api/private/v1/"
+
+// 依次执行jwt鉴权和接口权限拦截器，返回拦截器输出的响应（拦截器放行时为空）和请求上下文
+func runAuthFilters(method, path, token string) (*httptest.ResponseRecorder, *context.Context) {
+	beego.AppConfig.Set("baseURL", testBaseURL)
+	req := httptest.NewRequest(method, testBaseURL+path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", utils.GetHeaderTokenValue(token))
+	}
+	rec := httptest.NewRecorder()
+	ctx := context.NewContext()
+	ctx.Reset(rec, req)
+	for _, filter := range []beego.FilterFunc{CheckToken, CheckApiRight} {
+		filter(ctx)
+		if ctx.ResponseWriter.Started {
+			break
+		}
+	}
+	return rec, ctx
+}
+
+// 测试用的管理员token。token中没有会话id，权限版本号预先放入缓存，鉴权时不需要查询数据库
+func testToken(t *testing.T, mgId int) string {
+	beego.AppConfig.Set("TokenSecrets", "router-test-secret")
+	utils.CachePermVersion("manager", mgId, 0)
+	token := utils.CreateToken(&utils.TokenIdentity{MgId: mgId, UserName: "tester", RoleId: 30, RoleIds: []int{}})
+	if token == "" {
+		t.Fatal("签发token失败")
+	}
+	return token
+}
+
+func TestIsLoginRoute(t *testing.T) {
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"login", true},
+		{"login/refresh", true},
+		{"login/mfa", true},
+		{"login/oidc", true},
+		{"logout", false},
+		{"loginx", false},
+		{"users/500/logins", false},
+		{"users/login", false},
+	}
+	for _, c := range cases {
+		if got := isLoginRoute(c.path); got != c.want {
+			t.Errorf("isLoginRoute(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestOwnLoginsWithToken(t *testing.T) {
+	token := testToken(t, 500)
+	rec, ctx := runAuthFilters("GET", "users/500/logins", token)
+	if rec.Body.Len() > 0 {
+		t.Fatalf("管理员查询自己的登录记录被拦截：%s", rec.Body.String())
+	}
+	identity := models.CurrentIdentity(ctx)
+	if identity == nil || identity.MgId != 500 {
+		t.Fatalf("鉴权后没有保存身份信息：%+v", identity)
+	}
+}
+
+func TestLoginsRequiresToken(t *testing.T) {
+	rec, _ := runAuthFilters("GET", "users/500/logins", "")
+	var res models.ResLogin
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("响应不是json：%q", rec.Body.String())
+	}
+	meta, _ := json.Marshal(res.Meta)
+	if string(meta) != `{"msg":"无效token","status":401}` {
+		t.Fatalf("没有token时返回 %s", meta)
+	}
+}
+
+func TestLoginRouteSkipsToken(t *testing.T) {
+	rec, ctx := runAuthFilters("POST", "login/mfa", "")
+	if rec.Body.Len() > 0 || models.CurrentIdentity(ctx) != nil {
+		t.Fatalf("登录接口不应校验token：%s", rec.Body.String())
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("状态码 %d", rec.Code)
+	}
+}
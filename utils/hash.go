@@ -1,15 +1,24 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // 对用户密码进行hash加密、解密，详情参见：https://www.kancloud.cn/golang_programe/golang/1144844
 
+// 生成密码hash时使用的计算强度，可在app.conf中通过PwdHashCost调整
+func pwdHashCost() int {
+	return beego.AppConfig.DefaultInt("PwdHashCost", bcrypt.DefaultCost)
+}
+
 // 根据用户的密码字符串，生成哈希字符串
 func HashAndSalt(pwd string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.MinCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), pwdHashCost())
 	if err != nil {
 		logs.Error(err)
 		return ""
@@ -28,3 +37,28 @@ func ComparePasswords(hashedPwd string, plainPwd []byte) bool {
 	}
 	return true
 }
+
+// 判断密码hash的计算强度是否低于当前配置，低于时应在用户下次登录时重新生成
+func NeedsRehash(hashedPwd string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPwd))
+	if err != nil {
+		return false
+	}
+	return cost < pwdHashCost()
+}
+
+// 生成一个随机的一次性令牌（十六进制字符串），例如重置密码的令牌
+func RandomToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// 一次性令牌在数据库中只保存sha256值
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
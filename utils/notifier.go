@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"os"
+	"sync"
+	"time"
+)
+
+// 向管理员发送通知（例如重置密码的令牌）。通过app.conf中的NotifierAdapter选择发送方式，
+// 默认的log只写入日志，file写入NotifierFile指定的文件，需要短信、邮件等方式时用RegisterNotifier注册
+type Notifier interface {
+	Notify(to, subject, content string) error
+}
+
+var (
+	notifiersLock sync.RWMutex
+	notifiers     = map[string]Notifier{
+		"log":  logNotifier{},
+		"file": fileNotifier{},
+	}
+)
+
+// 注册一种通知方式，name与app.conf中NotifierAdapter的值对应
+func RegisterNotifier(name string, notifier Notifier) {
+	notifiersLock.Lock()
+	notifiers[name] = notifier
+	notifiersLock.Unlock()
+}
+
+// 使用配置的通知方式发送通知
+func Notify(to, subject, content string) error {
+	name := beego.AppConfig.DefaultString("NotifierAdapter", "log")
+	notifiersLock.RLock()
+	notifier, ok := notifiers[name]
+	notifiersLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("未知的通知方式：%s", name)
+	}
+	return notifier.Notify(to, subject, content)
+}
+
+// 只写入日志，本地开发时使用
+type logNotifier struct{}
+
+func (logNotifier) Notify(to, subject, content string) error {
+	logs.Info(fmt.Sprintf("通知 %s：%s %s", to, subject, content))
+	return nil
+}
+
+// 追加写入文件，每条通知一行
+type fileNotifier struct{}
+
+func (fileNotifier) Notify(to, subject, content string) error {
+	path := beego.AppConfig.DefaultString("NotifierFile", "notify.log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\n", time.Now().Format("2006-01-02 15:04:05"), to, subject, content)
+	return err
+}
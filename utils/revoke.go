@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/cache"
+	"github.com/astaxie/beego/logs"
+	"sync"
+	"time"
+)
+
+// token吊销列表。底层使用beego的cache模块存储，默认是内存，
+// 可在app.conf中通过RevokeCacheAdapter、RevokeCacheConfig切换为file、redis等适配器
+// （redis、memcache等适配器需要在main.go中匿名导入相应的包）
+
+var (
+	revokeStore     cache.Cache
+	revokeStoreOnce sync.Once
+)
+
+// 替换吊销列表的存储，需在服务启动前调用
+func SetRevokeStore(store cache.Cache) {
+	revokeStoreOnce.Do(func() {})
+	revokeStore = store
+}
+
+func getRevokeStore() cache.Cache {
+	revokeStoreOnce.Do(func() {
+		adapter := beego.AppConfig.DefaultString("RevokeCacheAdapter", "memory")
+		config := beego.AppConfig.DefaultString("RevokeCacheConfig", `{"interval":60}`)
+		store, err := cache.NewCache(adapter, config)
+		if err != nil {
+			logs.Error("创建token吊销列表失败，改用内存存储", err)
+			store, _ = cache.NewCache("memory", `{"interval":60}`)
+		}
+		revokeStore = store
+	})
+	return revokeStore
+}
+
+// 吊销单个token。记录只需保留到token本身过期为止
+func RevokeToken(jti string, exp int64) {
+	timeout := time.Until(time.Unix(exp, 0))
+	if jti == "" || timeout <= 0 {
+		return
+	}
+	err := getRevokeStore().Put("jti:"+jti, true, timeout)
+	if err != nil {
+		logs.Error("吊销token失败", err)
+	}
+}
+
+// 判断token是否已被吊销
+func IsTokenRevoked(jti string) bool {
+	return getRevokeStore().IsExist("jti:" + jti)
+}
+
+// 吊销某个用户当前已签发的所有token（签发时间早于当前这一秒的token均视为失效）
+func RevokeUserTokens(userName string) {
+	timeout := RefreshTokenExp()
+	err := getRevokeStore().Put("user:"+userName, time.Now().Unix(), timeout)
+	if err != nil {
+		logs.Error("吊销用户token失败", err)
+	}
+}
+
+// 判断用户在iat时刻签发的token是否已被整体吊销。iat只精确到秒，与吊销同一秒签发的token不在这里判断，
+// 否则吊销后立即重新登录（例如修改密码后）签发的新token也会失效；吊销前同一秒签发的token由会话的吊销使其失效
+func IsUserTokenRevoked(userName string, iat int64) bool {
+	// 不同的适配器取回的值类型不同（内存中是int64，redis中是[]byte），统一转换
+	revokedAt := cache.GetInt64(getRevokeStore().Get("user:" + userName))
+	return revokedAt > 0 && iat < revokedAt
+}
+
+// 吊销一个登录会话签发的所有token。会话可能一直在刷新token，记录保留一个refresh token的有效期，
+// 之后该会话最后一个refresh token也已过期
+func RevokeSession(sid string) {
+	err := getRevokeStore().Put("sid:"+sid, true, RefreshTokenExp())
+	if err != nil {
+		logs.Error("吊销会话失败", err)
+	}
+}
+
+// 判断登录会话是否已被吊销
+func IsSessionRevoked(sid string) bool {
+	return getRevokeStore().IsExist("sid:" + sid)
+}
+
+// 权限版本号的缓存，与吊销列表共用存储，多个实例共用redis等适配器时版本号的变化对所有实例立即生效。
+// kind为role或manager。缓存的有效期较短，缓存与数据库不一致时最多持续这么长时间
+const permVersionTimeout = time.Minute * 10
+
+// 读取缓存的权限版本号，第二个返回值表示缓存中是否存在
+func CachedPermVersion(kind string, id int) (int64, bool) {
+	value := getRevokeStore().Get(fmt.Sprintf("pv:%s:%d", kind, id))
+	if value == nil {
+		return 0, false
+	}
+	return cache.GetInt64(value), true
+}
+
+// 缓存从数据库读取的权限版本号
+func CachePermVersion(kind string, id int, version int64) {
+	err := getRevokeStore().Put(fmt.Sprintf("pv:%s:%d", kind, id), version, permVersionTimeout)
+	if err != nil {
+		logs.Error("缓存权限版本号失败", err)
+	}
+}
+
+// 权限版本号变化后删除缓存，下次使用时从数据库读取
+func ForgetPermVersion(kind string, id int) {
+	key := fmt.Sprintf("pv:%s:%d", kind, id)
+	// 内存适配器删除不存在的key时会返回错误
+	if !getRevokeStore().IsExist(key) {
+		return
+	}
+	err := getRevokeStore().Delete(key)
+	if err != nil {
+		logs.Error("删除权限版本号缓存失败", err)
+	}
+}
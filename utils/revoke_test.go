@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"github.com/astaxie/beego/cache"
+	"testing"
+	"time"
+)
+
+func TestIsUserTokenRevoked(t *testing.T) {
+	RevokeUserTokens("revoke-tester")
+	// 吊销时记录的时间
+	now := cache.GetInt64(getRevokeStore().Get("user:revoke-tester"))
+	if now < time.Now().Unix()-1 {
+		t.Fatalf("吊销时间错误：%d", now)
+	}
+	cases := []struct {
+		name     string
+		userName string
+		iat      int64
+		revoked  bool
+	}{
+		{"吊销前签发", "revoke-tester", now - 1, true},
+		{"很早之前签发", "revoke-tester", now - 3600, true},
+		{"吊销的同一秒重新登录", "revoke-tester", now, false},
+		{"吊销后签发", "revoke-tester", now + 1, false},
+		{"其他用户", "other-tester", now - 1, false},
+	}
+	for _, c := range cases {
+		if got := IsUserTokenRevoked(c.userName, c.iat); got != c.revoked {
+			t.Errorf("%s: IsUserTokenRevoked(%q, %d) = %v, want %v", c.name, c.userName, c.iat, got, c.revoked)
+		}
+	}
+}
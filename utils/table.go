@@ -0,0 +1,487 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 导入导出使用的表格格式：csv（UTF-8编码）和xlsx（只读写第一个工作表）。
+// xlsx只需要最基本的读写，这里直接按Office Open XML格式读写，不引入第三方库
+
+const (
+	TableCSV  = "csv"
+	TableXLSX = "xlsx"
+)
+
+const (
+	// 读取表格时最多读取的列数，之后的列忽略
+	tableMaxCols = 256
+	// xlsx中单个文件解压后的最大字节数，防止压缩炸弹
+	xlsxMaxPartSize = 64 << 20
+)
+
+var (
+	ErrTableFormat  = errors.New("文件格式错误，只支持csv和xlsx文件")
+	ErrTableTooLong = errors.New("文件行数超过限制")
+)
+
+var utf8Bom = []byte("\xef\xbb\xbf")
+
+// 根据文件名后缀判断表格格式，没有后缀时根据文件内容判断（xlsx是zip文件）
+func TableFormat(fileName string, data []byte) (string, error) {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".csv":
+		return TableCSV, nil
+	case ".xlsx":
+		return TableXLSX, nil
+	case "":
+		if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+			return TableXLSX, nil
+		}
+		return TableCSV, nil
+	}
+	return "", ErrTableFormat
+}
+
+// 表格格式对应的Content-Type
+func TableContentType(format string) string {
+	if format == TableXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+/* 读取表格的所有行，空行保留为空切片，使返回的下标加1就是文件中的行号。
+*  有内容的行超过maxRows行时返回ErrTableTooLong */
+func ReadTable(data []byte, format string, maxRows int) ([][]string, error) {
+	switch format {
+	case TableCSV:
+		return readCsvTable(data, maxRows)
+	case TableXLSX:
+		return readXlsxTable(data, maxRows)
+	}
+	return nil, ErrTableFormat
+}
+
+func readCsvTable(data []byte, maxRows int) ([][]string, error) {
+	data = bytes.TrimPrefix(data, utf8Bom)
+	reader := csv.NewReader(bytes.NewReader(data))
+	// 每行的列数可以不同，缺少的列按空值处理
+	reader.FieldsPerRecord = -1
+	// csv.Reader会跳过空行，按每一行开始的行号补上空行
+	lines := csvRecordLines(data)
+	var rows [][]string
+	for count := 0; ; count++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv文件解析出错：%s", err.Error())
+		}
+		if count >= maxRows {
+			return nil, ErrTableTooLong
+		}
+		if len(record) > tableMaxCols {
+			record = record[:tableMaxCols]
+		}
+		for i := range record {
+			record[i] = unescapeCsvFormula(record[i])
+		}
+		if count < len(lines) {
+			for len(rows) < lines[count]-1 {
+				rows = append(rows, []string{})
+			}
+		}
+		rows = append(rows, record)
+	}
+	return rows, nil
+}
+
+// csv文件中每一行（不包括空行）开始的行号，引号中的换行不算新的一行
+func csvRecordLines(data []byte) []int {
+	var lines []int
+	line, lineStart, fieldStart, quoted := 1, true, true, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if quoted {
+			if c == '"' {
+				if i+1 < len(data) && data[i+1] == '"' {
+					i++
+				} else {
+					quoted = false
+				}
+			} else if c == '\n' {
+				line++
+			}
+			continue
+		}
+		if lineStart {
+			if c == '\n' {
+				line++
+				continue
+			}
+			if c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+				continue
+			}
+			lines = append(lines, line)
+			lineStart = false
+		}
+		switch c {
+		case '"':
+			quoted = fieldStart
+			fieldStart = false
+		case ',':
+			fieldStart = true
+		case '\n':
+			line++
+			lineStart, fieldStart = true, true
+		default:
+			fieldStart = false
+		}
+	}
+	return lines
+}
+
+// xlsx中读取需要用到的部分
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// 共享字符串或单元格内的字符串，富文本的字符串由多段r组成
+type xlsxString struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (this *xlsxString) String() string {
+	if len(this.R) == 0 {
+		return this.T
+	}
+	var b strings.Builder
+	for _, r := range this.R {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxString `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R  string      `xml:"r,attr"`
+			T  string      `xml:"t,attr"`
+			V  string      `xml:"v"`
+			Is *xlsxString `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// 解析zip中的一个xml文件，文件不存在时返回false
+func readXlsxPart(files map[string]*zip.File, name string, v interface{}) (bool, error) {
+	file, ok := files[name]
+	if !ok {
+		return false, nil
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	err = xml.NewDecoder(io.LimitReader(reader, xlsxMaxPartSize)).Decode(v)
+	if err != nil {
+		return false, fmt.Errorf("xlsx文件解析出错：%s", err.Error())
+	}
+	return true, nil
+}
+
+// 第一个工作表在zip中的路径
+func xlsxFirstSheet(files map[string]*zip.File) (string, error) {
+	var workbook xlsxWorkbook
+	ok, err := readXlsxPart(files, "xl/workbook.xml", &workbook)
+	if err != nil {
+		return "", err
+	}
+	var rels xlsxRelationships
+	if ok && len(workbook.Sheets) > 0 {
+		ok, err = readXlsxPart(files, "xl/_rels/workbook.xml.rels", &rels)
+		if err != nil {
+			return "", err
+		}
+	}
+	if ok && len(workbook.Sheets) > 0 {
+		for _, rel := range rels.Relationships {
+			if rel.Id != workbook.Sheets[0].RId {
+				continue
+			}
+			// Target一般是相对xl目录的路径，也可能是以/开头的绝对路径
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return "xl/worksheets/sheet1.xml", nil
+}
+
+// 单元格引用（例如AB12）中的列号，从0开始
+func xlsxColumnIndex(ref string) int {
+	index := 0
+	for _, c := range strings.ToUpper(ref) {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		index = index*26 + int(c-'A') + 1
+	}
+	return index - 1
+}
+
+// 列号（从0开始）对应的列名，例如0对应A，27对应AB
+func xlsxColumnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+func readXlsxTable(data []byte, maxRows int) ([][]string, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ErrTableFormat
+	}
+	files := make(map[string]*zip.File, len(zipReader.File))
+	for _, file := range zipReader.File {
+		files[file.Name] = file
+	}
+	var sharedStrings xlsxSharedStrings
+	_, err = readXlsxPart(files, "xl/sharedStrings.xml", &sharedStrings)
+	if err != nil {
+		return nil, err
+	}
+	sheetName, err := xlsxFirstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	var sheet xlsxSheet
+	ok, err := readXlsxPart(files, sheetName, &sheet)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTableFormat
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		var record []string
+		for i, cell := range row.Cells {
+			// 没有r属性时按顺序排列
+			col := i
+			if cell.R != "" {
+				col = xlsxColumnIndex(cell.R)
+			}
+			if col < 0 || col >= tableMaxCols {
+				continue
+			}
+			var value string
+			switch cell.T {
+			case "s":
+				index, err := strconv.Atoi(cell.V)
+				if err == nil && index >= 0 && index < len(sharedStrings.Items) {
+					value = sharedStrings.Items[index].String()
+				}
+			case "inlineStr":
+				if cell.Is != nil {
+					value = cell.Is.String()
+				}
+			default:
+				// 数字、布尔值以及公式的计算结果
+				value = cell.V
+			}
+			if value == "" {
+				continue
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			record[col] = value
+		}
+		// 只有格式没有内容的行不计入行数
+		if record == nil {
+			continue
+		}
+		rowNum := row.R
+		if rowNum <= 0 {
+			rowNum = len(rows) + 1
+		}
+		if rowNum > maxRows {
+			return nil, ErrTableTooLong
+		}
+		for len(rows) < rowNum {
+			rows = append(rows, []string{})
+		}
+		rows[rowNum-1] = record
+	}
+	return rows, nil
+}
+
+/* 按行写入表格，写完后必须调用Close */
+type TableWriter interface {
+	WriteRow(cells []string) error
+	Close() error
+}
+
+/* 创建写入w的表格，xlsx逐行写入工作表，不需要把整个文件保存在内存中 */
+func NewTableWriter(w io.Writer, format string) (TableWriter, error) {
+	switch format {
+	case TableCSV:
+		// 写入BOM，否则Excel打开UTF-8编码的csv文件会乱码
+		_, err := w.Write(utf8Bom)
+		if err != nil {
+			return nil, err
+		}
+		return &csvTableWriter{csv.NewWriter(w)}, nil
+	case TableXLSX:
+		return newXlsxTableWriter(w)
+	}
+	return nil, ErrTableFormat
+}
+
+type csvTableWriter struct {
+	writer *csv.Writer
+}
+
+// 以=、+、-、@开头的内容在Excel中会被当作公式，写入时在前面加单引号，读取时去掉
+func escapeCsvFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func unescapeCsvFormula(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune("=+-@", rune(cell[1])) {
+		return cell[1:]
+	}
+	return cell
+}
+
+func (this *csvTableWriter) WriteRow(cells []string) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = escapeCsvFormula(cell)
+	}
+	return this.writer.Write(record)
+}
+
+func (this *csvTableWriter) Close() error {
+	this.writer.Flush()
+	return this.writer.Error()
+}
+
+// xlsx中除工作表外的固定内容
+var xlsxStaticParts = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+type xlsxTableWriter struct {
+	zipWriter *zip.Writer
+	sheet     io.Writer
+	rowNum    int
+}
+
+func newXlsxTableWriter(w io.Writer) (*xlsxTableWriter, error) {
+	zipWriter := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		writer, err := zipWriter.Create(part.Name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(writer, part.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 工作表最后写入，之后逐行写入
+	sheet, err := zipWriter.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxTableWriter{zipWriter: zipWriter, sheet: sheet}, nil
+}
+
+// 所有单元格都写成inlineStr，避免手机号等内容被Excel当作数字
+func (this *xlsxTableWriter) WriteRow(cells []string) error {
+	this.rowNum++
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<row r="%d">`, this.rowNum)
+	for i, cell := range cells {
+		fmt.Fprintf(&b, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">`, xlsxColumnName(i), this.rowNum)
+		err := xml.EscapeText(&b, []byte(cell))
+		if err != nil {
+			return err
+		}
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString(`</row>`)
+	_, err := this.sheet.Write(b.Bytes())
+	return err
+}
+
+func (this *xlsxTableWriter) Close() error {
+	_, err := io.WriteString(this.sheet, `</sheetData></worksheet>`)
+	if err != nil {
+		return err
+	}
+	return this.zipWriter.Close()
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// 把文件内容打包成zip，用于构造测试用的xlsx文件
+func testZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for name, content := range files {
+		writer, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 写入表格后再读取
+func writeTestTable(t *testing.T, format string, rows [][]string) []byte {
+	var buf bytes.Buffer
+	writer, err := NewTableWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTableFormat(t *testing.T) {
+	cases := []struct {
+		fileName string
+		data     []byte
+		format   string
+		err      error
+	}{
+		{"users.csv", nil, TableCSV, nil},
+		{"USERS.XLSX", nil, TableXLSX, nil},
+		{"users", []byte("PK\x03\x04..."), TableXLSX, nil},
+		{"users", []byte("username,email"), TableCSV, nil},
+		{"users.xls", nil, "", ErrTableFormat},
+		{"users.txt", nil, "", ErrTableFormat},
+	}
+	for _, c := range cases {
+		format, err := TableFormat(c.fileName, c.data)
+		if format != c.format || err != c.err {
+			t.Errorf("TableFormat(%q) = %q, %v, want %q, %v", c.fileName, format, err, c.format, c.err)
+		}
+	}
+}
+
+func TestXlsxColumn(t *testing.T) {
+	cases := []struct {
+		ref   string
+		name  string
+		index int
+	}{
+		{"A1", "A", 0},
+		{"Z9", "Z", 25},
+		{"AA10", "AA", 26},
+		{"ab1", "AB", 27},
+		{"AZ3", "AZ", 51},
+		{"ZZ1", "ZZ", 701},
+		{"AAA1", "AAA", 702},
+	}
+	for _, c := range cases {
+		if got := xlsxColumnIndex(c.ref); got != c.index {
+			t.Errorf("xlsxColumnIndex(%q) = %d, want %d", c.ref, got, c.index)
+		}
+		if got := xlsxColumnName(c.index); got != c.name {
+			t.Errorf("xlsxColumnName(%d) = %q, want %q", c.index, got, c.name)
+		}
+	}
+}
+
+func TestCsvRecordLines(t *testing.T) {
+	cases := []struct {
+		data  string
+		lines []int
+	}{
+		{"a,b\nc,d\n", []int{1, 2}},
+		{"a\n\n\nb", []int{1, 4}},
+		{"a\r\n\r\nb\r\n", []int{1, 3}},
+		{"\"x\n\ny\",1\nb\n", []int{1, 4}},
+		{"\"x\"\"\n\",1\n\nb", []int{1, 4}},
+		{"a\"b,c\nd", []int{1, 2}},
+		{" \nb", []int{1, 2}},
+		{"", nil},
+	}
+	for _, c := range cases {
+		if got := csvRecordLines([]byte(c.data)); !reflect.DeepEqual(got, c.lines) {
+			t.Errorf("csvRecordLines(%q) = %v, want %v", c.data, got, c.lines)
+		}
+	}
+}
+
+func TestReadCsvTable(t *testing.T) {
+	data := []byte("\xef\xbb\xbfusername,email,role\n" +
+		"zhangsan,zs@example.com,\"主管,运营\"\n" +
+		"\n" +
+		"lisi,'=1+1\n" +
+		"'-,'abc\n")
+	rows, err := ReadTable(data, TableCSV, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"username", "email", "role"},
+		{"zhangsan", "zs@example.com", "主管,运营"},
+		{},
+		{"lisi", "=1+1"},
+		{"-", "'abc"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ReadTable(csv) = %q, want %q", rows, want)
+	}
+	if _, err := ReadTable(data, TableCSV, 4); err != nil {
+		t.Errorf("空行不计入行数，返回 %v", err)
+	}
+	if _, err := ReadTable(data, TableCSV, 3); err != ErrTableTooLong {
+		t.Errorf("超过行数限制时返回 %v", err)
+	}
+	if _, err := ReadTable([]byte("a,\"b\n"), TableCSV, 10); err == nil {
+		t.Error("csv格式错误时应返回错误")
+	}
+}
+
+func TestReadXlsxTable(t *testing.T) {
+	// 共享字符串、富文本、数字、稀疏的行和列，以及以/开头的工作表路径
+	data := testZip(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="管理员" sheetId="1" r:id="rId3"/><sheet name="其他" sheetId="2" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId3" Target="/xl/worksheets/users.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<si><t>username</t></si><si><t>mobile</t></si><si><r><t>zhang</t></r><r><t>san</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>wrong sheet</t></is></c></row></sheetData></worksheet>`,
+		"xl/worksheets/users.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>` +
+			`<row r="2"><c r="A2" t="s"><v>2</v></c><c r="C2"><v>13800000000</v></c></row>` +
+			`<row r="3"><c r="A3" s="1"/></row>` +
+			`<row r="5"><c r="B5" t="inlineStr"><is><t>lisi</t></is></c><c r="D5" t="s"><v>99</v></c></row>` +
+			`</sheetData></worksheet>`,
+	})
+	rows, err := ReadTable(data, TableXLSX, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"username", "", "mobile"},
+		{"zhangsan", "", "13800000000"},
+		{},
+		{},
+		{"", "lisi"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ReadTable(xlsx) = %q, want %q", rows, want)
+	}
+	// 只有格式没有内容的行不计入行数，第5行超过限制
+	if _, err := ReadTable(data, TableXLSX, 4); err != ErrTableTooLong {
+		t.Errorf("超过行数限制时返回 %v", err)
+	}
+	if _, err := ReadTable([]byte("username,email"), TableXLSX, 10); err != ErrTableFormat {
+		t.Errorf("不是zip文件时返回 %v", err)
+	}
+	if _, err := ReadTable(testZip(t, map[string]string{"a.txt": "a"}), TableXLSX, 10); err != ErrTableFormat {
+		t.Errorf("没有工作表时返回 %v", err)
+	}
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	rows := [][]string{
+		{"id", "username", "role", "mobile"},
+		{"1", "zhangsan", "主管,运营", "013800000000"},
+		{"2", "<lisi> & \"wang\"", "=cmd|' /C calc'!A0", "  空格  "},
+		{"3", "-1", "+86", "@sum"},
+	}
+	for _, format := range []string{TableCSV, TableXLSX} {
+		data := writeTestTable(t, format, rows)
+		got, err := ReadTable(data, format, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(got, rows) {
+			t.Errorf("%s: 读取写入的表格 = %q, want %q", format, got, rows)
+		}
+	}
+	// csv中以公式字符开头的内容写入时加单引号
+	data := writeTestTable(t, TableCSV, [][]string{{"=1+1", "a"}})
+	if want := "\xef\xbb\xbf'=1+1,a\n"; string(data) != want {
+		t.Errorf("csv写入 %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,368 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rs/xid"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// token签名密钥。密钥保存在TokenKeyDir目录中：keys.json记录每个密钥的kid、算法、状态和创建时间，
+// <kid>.pem是PKCS8格式的私钥。最新创建的active密钥用于签名，所有active密钥都可以用于校验，
+// 所以轮换密钥后，旧密钥签发的token在到期前仍然有效；retired的密钥不再用于校验，也不会出现在JWKS中。
+// 目录中还没有密钥时仍使用TokenSecrets进行HS256签名（兼容旧的配置）
+
+// 密钥的状态
+const (
+	KeyActive  = "active"
+	KeyRetired = "retired"
+)
+
+// 支持的非对称签名算法
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+/* keys.json中一个密钥的信息 */
+type TokenKeyInfo struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"`
+	Status     string `json:"status"`
+	CreateTime int64  `json:"create_time"`
+	RetireTime int64  `json:"retire_time,omitempty"`
+}
+
+type tokenKey struct {
+	TokenKeyInfo
+	signer crypto.Signer
+}
+
+type tokenKeyring struct {
+	// 按创建时间排列，最后一个active密钥用于签名
+	keys    []*tokenKey
+	modTime time.Time
+}
+
+var (
+	tokenKeys     *tokenKeyring
+	tokenKeysLock sync.Mutex
+)
+
+func tokenKeyDir() string {
+	return beego.AppConfig.DefaultString("TokenKeyDir", "conf/jwt-keys")
+}
+
+func tokenKeyManifest() string {
+	return filepath.Join(tokenKeyDir(), "keys.json")
+}
+
+// 读取签名密钥。keys.json修改后（例如执行了轮换命令）自动重新读取，不需要重启服务
+func loadTokenKeys() *tokenKeyring {
+	info, err := os.Stat(tokenKeyManifest())
+	tokenKeysLock.Lock()
+	defer tokenKeysLock.Unlock()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logs.Error("读取token签名密钥出错", err)
+		}
+		if tokenKeys == nil {
+			tokenKeys = &tokenKeyring{}
+		}
+		return tokenKeys
+	}
+	if tokenKeys != nil && tokenKeys.modTime.Equal(info.ModTime()) {
+		return tokenKeys
+	}
+	ring, err := readTokenKeyring()
+	if err != nil {
+		// 读取失败时继续使用之前读取的密钥
+		logs.Error("读取token签名密钥出错", err)
+		if tokenKeys == nil {
+			tokenKeys = &tokenKeyring{}
+		}
+		return tokenKeys
+	}
+	ring.modTime = info.ModTime()
+	tokenKeys = ring
+	return ring
+}
+
+func readTokenKeyManifest() ([]TokenKeyInfo, error) {
+	data, err := ioutil.ReadFile(tokenKeyManifest())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var infos []TokenKeyInfo
+	err = json.Unmarshal(data, &infos)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].CreateTime < infos[j].CreateTime
+	})
+	return infos, nil
+}
+
+// 读取keys.json以及其中未停用的私钥
+func readTokenKeyring() (*tokenKeyring, error) {
+	infos, err := readTokenKeyManifest()
+	if err != nil {
+		return nil, err
+	}
+	ring := &tokenKeyring{}
+	for _, info := range infos {
+		if info.Status != KeyActive {
+			continue
+		}
+		signer, err := readPrivateKey(info)
+		if err != nil {
+			return nil, fmt.Errorf("密钥%s：%v", info.Kid, err)
+		}
+		ring.keys = append(ring.keys, &tokenKey{info, signer})
+	}
+	return ring, nil
+}
+
+func readPrivateKey(info TokenKeyInfo) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(filepath.Join(tokenKeyDir(), info.Kid+".pem"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("私钥格式错误")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if info.Alg == AlgRS256 {
+			return key, nil
+		}
+	case ed25519.PrivateKey:
+		if info.Alg == AlgEdDSA {
+			return key, nil
+		}
+	}
+	return nil, errors.New("私钥类型与算法不一致")
+}
+
+// 当前用于签名的密钥，没有时返回nil
+func (this *tokenKeyring) signingKey() *tokenKey {
+	if len(this.keys) == 0 {
+		return nil
+	}
+	return this.keys[len(this.keys)-1]
+}
+
+// 根据kid查找可以用于校验的密钥
+func (this *tokenKeyring) verifyingKey(kid string) *tokenKey {
+	for _, key := range this.keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// 使用当前的签名密钥签发token，header中的kid标明使用的密钥
+func signToken(claims jwt.MapClaims) (string, error) {
+	if key := loadTokenKeys().signingKey(); key != nil {
+		token := jwt.NewWithClaims(signingMethod(key.Alg), claims)
+		token.Header["kid"] = key.Kid
+		return token.SignedString(key.signer)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(beego.AppConfig.String("TokenSecrets")))
+}
+
+// 校验token时根据kid返回对应的公钥。token的算法必须与密钥一致，防止用公钥冒充HMAC密钥等算法混淆攻击。
+// 没有kid的token是使用TokenSecrets签发的，TokenSecrets为空表示不再接受这类token
+func tokenVerifyKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		secret := beego.AppConfig.String("TokenSecrets")
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || secret == "" {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	}
+	key := loadTokenKeys().verifyingKey(kid)
+	if key == nil {
+		return nil, fmt.Errorf("Unknown kid: %v", kid)
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.signer.Public(), nil
+}
+
+/* 轮换签名密钥：生成一个新密钥用于签名，旧密钥继续用于校验，直到它签发的token全部过期。
+*  停止签名超过refresh token有效期的旧密钥此时已没有有效的token，自动停用 */
+func RotateTokenKey(alg string) (*TokenKeyInfo, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("不支持的签名算法：%s，可选%s、%s", alg, AlgRS256, AlgEdDSA)
+	}
+	if err != nil {
+		return nil, err
+	}
+	infos, err := readTokenKeyManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	for i := range infos {
+		if infos[i].Status != KeyActive || i == len(infos)-1 {
+			continue
+		}
+		// 下一个密钥创建时，这个密钥停止签名
+		if infos[i+1].CreateTime+int64(RefreshTokenExp().Seconds()) < now {
+			infos[i].Status = KeyRetired
+			infos[i].RetireTime = now
+		}
+	}
+	info := TokenKeyInfo{Kid: xid.New().String(), Alg: alg, Status: KeyActive, CreateTime: now}
+
+	err = os.MkdirAll(tokenKeyDir(), 0700)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = ioutil.WriteFile(filepath.Join(tokenKeyDir(), info.Kid+".pem"), data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = writeTokenKeyManifest(append(infos, info))
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+/* 停用一个密钥（例如密钥泄露），它签发的token立即失效。当前用于签名的密钥需要先轮换才能停用 */
+func RetireTokenKey(kid string) (*TokenKeyInfo, error) {
+	infos, err := readTokenKeyManifest()
+	if err != nil {
+		return nil, err
+	}
+	last := -1
+	for i := range infos {
+		if infos[i].Status == KeyActive {
+			last = i
+		}
+	}
+	for i := range infos {
+		if infos[i].Kid != kid {
+			continue
+		}
+		if infos[i].Status != KeyActive {
+			return nil, errors.New("密钥已停用")
+		}
+		if i == last {
+			return nil, errors.New("该密钥正在用于签名，请先轮换密钥")
+		}
+		infos[i].Status = KeyRetired
+		infos[i].RetireTime = time.Now().Unix()
+		err = writeTokenKeyManifest(infos)
+		if err != nil {
+			return nil, err
+		}
+		return &infos[i], nil
+	}
+	return nil, errors.New("密钥不存在")
+}
+
+// 先写入临时文件再重命名，避免服务读到写了一半的keys.json
+func writeTokenKeyManifest(infos []TokenKeyInfo) error {
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := tokenKeyManifest() + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, tokenKeyManifest())
+}
+
+/* JWKS中一个公钥的结构（RFC 7517），RSA公钥使用n、e，Ed25519公钥使用crv、x */
+type Jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+/* JWKS的结构 接口：/.well-known/jwks.json 请求方式：get */
+type JwkSet struct {
+	Keys []Jwk `json:"keys"`
+}
+
+/* 所有可以用于校验token的公钥，供其他服务校验token */
+func Jwks() *JwkSet {
+	set := &JwkSet{Keys: []Jwk{}}
+	for _, key := range loadTokenKeys().keys {
+		jwk := Jwk{Use: "sig", Alg: key.Alg, Kid: key.Kid}
+		switch public := key.signer.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -3,42 +3,200 @@ package utils
 import (
 	"fmt"
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
 	"github.com/dgrijalva/jwt-go"
-	"strconv"
+	"github.com/rs/xid"
 	"time"
 )
 
-// 需要在配置文件app.conf中设置Tokenexp的值
-func CreateToken(userName string) string {
-	token := jwt.New(jwt.SigningMethodHS256)
+// token的类型：access token用于访问接口，refresh token只能用于换取新的token，
+// mfa token表示密码已验证、等待输入动态验证码，只能用于两步验证接口，
+// oidc state token在跳转到身份提供方之前签发，登录回来时用于确认是本系统发起的登录
+const (
+	AccessToken    = "access"
+	RefreshToken   = "refresh"
+	MfaToken       = "mfa-pending"
+	OidcStateToken = "oidc-state"
+)
+
+/* access token中保存的管理员身份和权限信息。鉴权时直接使用token中的信息，不需要每次请求都查询管理员和角色 */
+type TokenIdentity struct {
+	MgId     int
+	UserName string
+	// 主角色，0表示超级管理员
+	RoleId int
+	// 所有角色（包括未到期的临时角色）及其祖先角色的id，权限为这些角色权限的并集
+	RoleIds []int
+	// 临时授予的权限id
+	PsIds []int
+	// 签发时的权限版本号，权限变化后token失效，见models/permVersion.go
+	PermVersion int64
+	// 登录会话的id
+	Sid string
+	// 临时授权最早的到期时间，0表示没有临时授权。token的有效期不会超过这个时间
+	GrantExpireTime int64
+}
+
+// 根据身份信息签发access token，有效期为配置文件中的AccessTokenExp（分钟），但不超过临时授权最早的到期时间。
+// 除jti、typ、exp、iat、userName外，claims中还有mgId、roleId、roleIds、psIds和权限版本号pv；
+// identity.Sid不为空时写入sid，同一次登录（包括之后刷新）签发的token属于同一个会话。
+// 使用当前的签名密钥签名，header中的kid标识该密钥，见tokenKeys.go
+func CreateToken(identity *TokenIdentity) string {
+	accessTokenExp := beego.AppConfig.DefaultInt("AccessTokenExp", 30)
+	exp := time.Minute * time.Duration(accessTokenExp)
+	// 临时授权到期后必须重新签发token，否则token中仍带着已到期的角色或权限
+	if identity.GrantExpireTime > 0 {
+		if untilGrant := time.Until(time.Unix(identity.GrantExpireTime, 0)); untilGrant < exp {
+			exp = untilGrant
+		}
+	}
+	claims := newClaims(identity.UserName, AccessToken, identity.Sid, exp)
+	claims["mgId"] = identity.MgId
+	claims["roleId"] = identity.RoleId
+	claims["roleIds"] = identity.RoleIds
+	claims["psIds"] = identity.PsIds
+	claims["pv"] = identity.PermVersion
+	return signClaims(claims)
+}
+
+// 创建refresh token，用于在access token过期后换取新的token。jti由调用方生成（NewTokenId），
+// 签发前先保存到登录会话中，刷新时用于判断refresh token是否已被使用过
+func CreateRefreshToken(userName, sid, jti string) string {
+	claims := newClaims(userName, RefreshToken, sid, RefreshTokenExp())
+	claims["jti"] = jti
+	return signClaims(claims)
+}
+
+// refresh token的有效期，也是登录会话在不刷新token的情况下保持有效的时间
+func RefreshTokenExp() time.Duration {
+	return time.Hour * time.Duration(beego.AppConfig.DefaultInt("RefreshTokenExp", 72))
+}
+
+// 创建mfa token，有效期5分钟
+func CreateMfaToken(userName string) string {
+	return createToken(userName, MfaToken, "", time.Minute*5)
+}
+
+// 创建oidc state token，有效期10分钟。这个token还没有对应的用户，userName中保存发给身份提供方的nonce
+func CreateOidcStateToken(nonce string) string {
+	return createToken(nonce, OidcStateToken, "", time.Minute*10)
+}
+
+// 生成一个新的登录会话id
+func NewSessionId() string {
+	return xid.New().String()
+}
+
+// 生成一个新的token唯一标识（jti）
+func NewTokenId() string {
+	return xid.New().String()
+}
+
+func createToken(userName, tokenType, sid string, exp time.Duration) string {
+	return signClaims(newClaims(userName, tokenType, sid, exp))
+}
+
+func newClaims(userName, tokenType, sid string, exp time.Duration) jwt.MapClaims {
 	claims := make(jwt.MapClaims)
 	//添加令牌关键信息
-	TokenExp, _ := strconv.Atoi(beego.AppConfig.String("TokenExp"))
-	//添加令牌期限，TokenExp变量的值为多少，期限就是多少小时
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(TokenExp)).Unix()
+	//jti是令牌的唯一标识，吊销令牌时使用
+	claims["jti"] = NewTokenId()
+	claims["typ"] = tokenType
+	claims["exp"] = time.Now().Add(exp).Unix()
 	claims["iat"] = time.Now().Unix()
 	claims["userName"] = userName
-	token.Claims = claims
-	tokenString, _ := token.SignedString([]byte(beego.AppConfig.String("TokenSecrets")))
+	if sid != "" {
+		claims["sid"] = sid
+	}
+	return claims
+}
+
+func signClaims(claims jwt.MapClaims) string {
+	// 使用当前的签名密钥签名，见tokenKeys.go
+	tokenString, err := signToken(claims)
+	if err != nil {
+		logs.Error("签发token出错", err)
+	}
 	return tokenString
 }
 
+// 校验access token，返回token中的用户名，校验未通过时返回空字符串
 func CheckToken(tokenString string) string {
-	userName := ""
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	identity, ok := ParseAccessToken(tokenString)
+	if !ok {
+		return ""
+	}
+	return identity.UserName
+}
+
+// 校验access token，返回token中的身份信息。升级前签发的token没有管理员id，视为无效，需要刷新
+func ParseAccessToken(tokenString string) (*TokenIdentity, bool) {
+	claims, ok := ParseToken(tokenString, AccessToken)
+	if !ok {
+		return nil, false
+	}
+	mgId, _ := claims["mgId"].(float64)
+	if mgId <= 0 {
+		return nil, false
+	}
+	identity := &TokenIdentity{MgId: int(mgId), RoleIds: claimInts(claims["roleIds"]), PsIds: claimInts(claims["psIds"])}
+	identity.UserName, _ = claims["userName"].(string)
+	identity.Sid, _ = claims["sid"].(string)
+	roleId, _ := claims["roleId"].(float64)
+	identity.RoleId = int(roleId)
+	pv, _ := claims["pv"].(float64)
+	identity.PermVersion = int64(pv)
+	return identity, true
+}
+
+// 解析后的claims中数字都是float64，数组是[]interface{}
+func claimInts(value interface{}) []int {
+	values, _ := value.([]interface{})
+	ids := make([]int, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(float64); ok {
+			ids = append(ids, int(id))
 		}
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return []byte(beego.AppConfig.String("TokenSecrets")), nil
-	})
+	}
+	return ids
+}
+
+// 校验token的签名、有效期、类型以及是否已被吊销，校验通过时返回token中的信息
+func ParseToken(tokenString string, tokenType string) (jwt.MapClaims, bool) {
+	// 根据header中的kid选择校验的密钥，并确认算法与密钥一致
+	token, _ := jwt.Parse(tokenString, tokenVerifyKey)
+	if token == nil || !token.Valid {
+		return nil, false
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	// 没有jti的token（升级前签发的）无法吊销，一律视为无效
+	jti, _ := claims["jti"].(string)
+	typ, _ := claims["typ"].(string)
+	userName, _ := claims["userName"].(string)
+	iat, _ := claims["iat"].(float64)
+	if jti == "" || typ != tokenType || userName == "" {
+		return nil, false
+	}
+	if IsTokenRevoked(jti) || IsUserTokenRevoked(userName, int64(iat)) {
+		return nil, false
+	}
+	// 会话被远程退出后，属于该会话的token全部失效
+	if sid, _ := claims["sid"].(string); sid != "" && IsSessionRevoked(sid) {
+		return nil, false
+	}
+	return claims, true
+}
 
-	if token != nil && token.Valid {
-		claims, _ := token.Claims.(jwt.MapClaims)
-		userName = claims["userName"].(string)
+// 吊销一个token，token无效时忽略
+func RevokeTokenString(tokenString string, tokenType string) {
+	claims, ok := ParseToken(tokenString, tokenType)
+	if !ok {
+		return
 	}
-	return userName
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	RevokeToken(jti, int64(exp))
 }
 
 // return this result to client then all later request should have header "Authorization: Bearer <token> "
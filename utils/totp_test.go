@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// RFC 6238附录B的SHA1测试密钥"12345678901234567890"的base32编码
+const rfcTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238附录B的测试向量，取8位验证码的后6位
+	cases := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, c := range cases {
+		code, err := TotpCode(rfcTotpSecret, c.time/totpPeriod)
+		if err != nil || code != c.code {
+			t.Errorf("TotpCode(step of %d) = %q, %v, want %q", c.time, code, err, c.code)
+		}
+	}
+	// 密钥不区分大小写
+	code, err := TotpCode(strings.ToLower(rfcTotpSecret), 1)
+	if err != nil || code != "287082" {
+		t.Errorf("小写密钥 TotpCode = %q, %v", code, err)
+	}
+	if _, err := TotpCode("not base32!", 1); err == nil {
+		t.Error("密钥格式错误时应返回错误")
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	// 时间步长37037036的验证码，对应时间1111111080~1111111109
+	const step = 1111111109 / totpPeriod
+	code, _ := TotpCode(rfcTotpSecret, step)
+	cases := []struct {
+		name     string
+		code     string
+		lastStep int64
+		now      int64
+		wantStep int64
+		ok       bool
+	}{
+		{"当前步长", code, 0, 1111111109, step, true},
+		{"当前步长的第一秒", code, 0, step * totpPeriod, step, true},
+		{"客户端快一个步长", code, 0, step*totpPeriod - 1, step, true},
+		{"客户端快两个步长", code, 0, (step-1)*totpPeriod - 1, 0, false},
+		{"客户端慢一个步长", code, 0, (step+2)*totpPeriod - 1, step, true},
+		{"客户端慢两个步长", code, 0, (step + 2) * totpPeriod, 0, false},
+		{"已使用过的步长", code, step, 1111111109, 0, false},
+		{"使用过之前的步长", code, step - 1, 1111111109, step, true},
+		{"使用过之后的步长", code, step + 1, step * totpPeriod, 0, false},
+		{"位数错误", code[:5], 0, 1111111109, 0, false},
+		{"验证码错误", "000000", 0, 1111111109, 0, false},
+	}
+	for _, c := range cases {
+		gotStep, ok := validateTotpAt(rfcTotpSecret, c.code, c.lastStep, c.now)
+		if gotStep != c.wantStep || ok != c.ok {
+			t.Errorf("%s: validateTotpAt = %d, %v, want %d, %v", c.name, gotStep, ok, c.wantStep, c.ok)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes = %v, %v", codes, err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 9 || code[4] != '-' || code != strings.ToLower(code) || seen[code] {
+			t.Errorf("恢复码格式错误或重复：%q", code)
+		}
+		seen[code] = true
+	}
+	// 输入时忽略大小写和首尾空格
+	hash := HashRecoveryCode("abcd-efgh")
+	for _, input := range []string{"ABCD-EFGH", " abcd-efgh\n", "Abcd-Efgh"} {
+		if HashRecoveryCode(input) != hash {
+			t.Errorf("HashRecoveryCode(%q) 与 abcd-efgh 不一致", input)
+		}
+	}
+	if HashRecoveryCode("abcd-efgi") == hash {
+		t.Error("不同的恢复码hash相同")
+	}
+}